@@ -0,0 +1,88 @@
+package cert_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/neepoo/pcbook/cert"
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+func startTestServer(t *testing.T, creds credentials.TransportCredentials) string {
+	grpcServer := grpc.NewServer(grpc.Creds(creds))
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return listener.Addr().String()
+}
+
+func createLaptop(serverAddress string, creds credentials.TransportCredentials) error {
+	conn, err := grpc.Dial(serverAddress, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	laptopClient := pb.NewLaptopServiceClient(conn)
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	return err
+}
+
+func TestMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	files, err := cert.GenerateDev(t.TempDir(), []string{"localhost", "127.0.0.1"})
+	require.NoError(t, err)
+
+	serverCreds, err := cert.LoadServerCredentials(files.ServerCert, files.ServerKey, files.CACert)
+	require.NoError(t, err)
+	serverAddress := startTestServer(t, serverCreds)
+
+	clientCreds, err := cert.LoadClientCredentials(files.CACert, files.ClientCert, files.ClientKey, "")
+	require.NoError(t, err)
+	require.NoError(t, createLaptop(serverAddress, clientCreds))
+
+	noCertCreds, err := cert.LoadClientCredentials(files.CACert, "", "", "")
+	require.NoError(t, err)
+	require.Error(t, createLaptop(serverAddress, noCertCreds))
+
+	otherFiles, err := cert.GenerateDev(t.TempDir(), []string{"localhost", "127.0.0.1"})
+	require.NoError(t, err)
+	otherCreds, err := cert.LoadClientCredentials(files.CACert, otherFiles.ClientCert, otherFiles.ClientKey, "")
+	require.NoError(t, err)
+	require.Error(t, createLaptop(serverAddress, otherCreds))
+}
+
+func TestServerTLS(t *testing.T) {
+	t.Parallel()
+
+	files, err := cert.GenerateDev(t.TempDir(), []string{"localhost"})
+	require.NoError(t, err)
+
+	serverCreds, err := cert.LoadServerCredentials(files.ServerCert, files.ServerKey, "")
+	require.NoError(t, err)
+	serverAddress := startTestServer(t, serverCreds)
+
+	clientCreds, err := cert.LoadClientCredentials(files.CACert, "", "", "localhost")
+	require.NoError(t, err)
+	require.NoError(t, createLaptop(serverAddress, clientCreds))
+
+	wrongNameCreds, err := cert.LoadClientCredentials(files.CACert, "", "", "example.com")
+	require.NoError(t, err)
+	require.Error(t, createLaptop(serverAddress, wrongNameCreds))
+}
@@ -0,0 +1,133 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DevFiles are the paths of the files written by GenerateDev
+type DevFiles struct {
+	CACert     string
+	CAKey      string
+	ServerCert string
+	ServerKey  string
+	ClientCert string
+	ClientKey  string
+}
+
+// GenerateDev writes a throwaway CA, and a server and a client certificate
+// signed by it, to dir. The server certificate is valid for the given hosts.
+// They are meant for local development and tests only.
+func GenerateDev(dir string, hosts []string) (*DevFiles, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create certificate folder: %w", err)
+	}
+
+	files := &DevFiles{
+		CACert:     filepath.Join(dir, "ca-cert.pem"),
+		CAKey:      filepath.Join(dir, "ca-key.pem"),
+		ServerCert: filepath.Join(dir, "server-cert.pem"),
+		ServerKey:  filepath.Join(dir, "server-key.pem"),
+		ClientCert: filepath.Join(dir, "client-cert.pem"),
+		ClientKey:  filepath.Join(dir, "client-key.pem"),
+	}
+
+	caTemplate := newTemplate("pcbook dev CA")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	caCert, caKey, err := writeCertificate(caTemplate, nil, nil, files.CACert, files.CAKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate CA certificate: %w", err)
+	}
+
+	serverTemplate := newTemplate("pcbook dev server")
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+	_, _, err = writeCertificate(serverTemplate, caCert, caKey, files.ServerCert, files.ServerKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate server certificate: %w", err)
+	}
+
+	clientTemplate := newTemplate("pcbook dev client")
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	_, _, err = writeCertificate(clientTemplate, caCert, caKey, files.ClientCert, files.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate client certificate: %w", err)
+	}
+
+	return files, nil
+}
+
+func newTemplate(commonName string) *x509.Certificate {
+	serialNumber, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"pcbook"},
+			CommonName:   commonName,
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+	}
+}
+
+// writeCertificate creates a key and a certificate signed by parent, or self-signed if parent is nil
+func writeCertificate(
+	template *x509.Certificate,
+	parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey,
+	certFile string,
+	keyFile string,
+) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
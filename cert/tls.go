@@ -0,0 +1,83 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// LoadServerCredentials loads the certificate and key of the server.
+// If clientCAFile is not empty, clients must present a certificate signed by that CA.
+func LoadServerCredentials(certFile, keyFile, clientCAFile string) (credentials.TransportCredentials, error) {
+	config, err := LoadServerTLSConfig(certFile, keyFile, clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// LoadServerTLSConfig is LoadServerCredentials for the servers which are not gRPC servers
+func LoadServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	serverCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load server certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.NoClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		certPool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = certPool
+	}
+
+	return config, nil
+}
+
+// LoadClientCredentials trusts the servers signed by the CA in caFile.
+// If certFile and keyFile are not empty, the client presents that certificate to the server.
+func LoadClientCredentials(caFile, certFile, keyFile, serverName string) (credentials.TransportCredentials, error) {
+	certPool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		RootCAs:    certPool,
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certFile != "" || keyFile != "" {
+		clientCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return credentials.NewTLS(config), nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pemCA, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA certificate: %w", err)
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(pemCA) {
+		return nil, fmt.Errorf("cannot add CA certificate %s to the pool", caFile)
+	}
+	return certPool, nil
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const loginMethod = "/pcbook.AuthService/Login"
+
+// minRefreshDelay is the min delay before refreshing a token, so that a token
+// coming back already expired, e.g. from a server whose clock is late, isn't refreshed in a loop
+const minRefreshDelay = time.Second
+
+// AuthInterceptor attaches an access token to every call and refreshes it in the background
+type AuthInterceptor struct {
+	authClient    *AuthClient
+	refreshBefore time.Duration
+
+	mutex       sync.RWMutex
+	accessToken string
+	expiresAt   time.Time
+
+	// after returns a channel receiving the time once a duration has elapsed, like time.After
+	after     func(time.Duration) <-chan time.Time
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAuthInterceptor logs in and starts refreshing the access token refreshBefore it expires
+func NewAuthInterceptor(authClient *AuthClient, refreshBefore time.Duration) (*AuthInterceptor, error) {
+	return newAuthInterceptor(authClient, refreshBefore, time.After)
+}
+
+func newAuthInterceptor(
+	authClient *AuthClient,
+	refreshBefore time.Duration,
+	after func(time.Duration) <-chan time.Time,
+) (*AuthInterceptor, error) {
+	interceptor := &AuthInterceptor{
+		authClient:    authClient,
+		refreshBefore: refreshBefore,
+		after:         after,
+		done:          make(chan struct{}),
+	}
+
+	err := interceptor.refreshToken()
+	if err != nil {
+		return nil, err
+	}
+	go interceptor.scheduleRefreshToken()
+	return interceptor, nil
+}
+
+// Close stops refreshing the access token, it can be called several times
+func (interceptor *AuthInterceptor) Close() {
+	interceptor.closeOnce.Do(func() {
+		close(interceptor.done)
+	})
+}
+
+func (interceptor *AuthInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if method == loginMethod {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		err := invoker(interceptor.attachToken(ctx), method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unauthenticated {
+			return err
+		}
+
+		log.Printf("%s is unauthenticated, retry with a new token", method)
+		if refreshErr := interceptor.refreshToken(); refreshErr != nil {
+			return err
+		}
+		return invoker(interceptor.attachToken(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// Stream attaches the access token to streaming calls. A server-streaming call
+// failing with Unauthenticated before any response is retried once with a new
+// token; calls streaming from the client are not, since their requests are gone.
+func (interceptor *AuthInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		if method == loginMethod {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		newStream := func() (grpc.ClientStream, error) {
+			return streamer(interceptor.attachToken(ctx), desc, cc, method, opts...)
+		}
+
+		stream, err := newStream()
+		if status.Code(err) == codes.Unauthenticated {
+			if refreshErr := interceptor.refreshToken(); refreshErr != nil {
+				return nil, err
+			}
+			stream, err = newStream()
+		}
+		if err != nil || desc.ClientStreams {
+			return stream, err
+		}
+		return &retryClientStream{ClientStream: stream, interceptor: interceptor, newStream: newStream}, nil
+	}
+}
+
+func (interceptor *AuthInterceptor) attachToken(ctx context.Context) context.Context {
+	interceptor.mutex.RLock()
+	defer interceptor.mutex.RUnlock()
+	return metadata.AppendToOutgoingContext(ctx, "authorization", interceptor.accessToken)
+}
+
+func (interceptor *AuthInterceptor) refreshToken() error {
+	accessToken, expiresAt, err := interceptor.authClient.Login()
+	if err != nil {
+		return fmt.Errorf("cannot refresh access token: %w", err)
+	}
+
+	interceptor.mutex.Lock()
+	defer interceptor.mutex.Unlock()
+	interceptor.accessToken = accessToken
+	interceptor.expiresAt = expiresAt
+	if !expiresAt.After(time.Now()) {
+		log.Printf("token refreshed but already expired at %v, is the clock of the client ahead of the server?", expiresAt)
+		return nil
+	}
+	log.Printf("token refreshed, expires at %v", expiresAt)
+	return nil
+}
+
+// refreshDelay returns how long to wait before refreshing the current token.
+// It never waits less than half of the remaining lifetime of the token, nor than minRefreshDelay.
+func (interceptor *AuthInterceptor) refreshDelay() time.Duration {
+	interceptor.mutex.RLock()
+	defer interceptor.mutex.RUnlock()
+
+	lifetime := time.Until(interceptor.expiresAt)
+	delay := lifetime - interceptor.refreshBefore
+	if delay < lifetime/2 {
+		delay = lifetime / 2
+	}
+	if delay < minRefreshDelay {
+		delay = minRefreshDelay
+	}
+	return delay
+}
+
+func (interceptor *AuthInterceptor) scheduleRefreshToken() {
+	wait := interceptor.refreshDelay()
+	for {
+		select {
+		case <-interceptor.done:
+			return
+		case <-interceptor.after(wait):
+		}
+
+		err := interceptor.refreshToken()
+		if err != nil {
+			log.Print(err)
+			wait = time.Second
+			continue
+		}
+		wait = interceptor.refreshDelay()
+	}
+}
+
+// retryClientStream recreates a server-streaming call with a new token
+// if its first response is an Unauthenticated error
+type retryClientStream struct {
+	grpc.ClientStream
+	interceptor *AuthInterceptor
+	newStream   func() (grpc.ClientStream, error)
+	request     interface{}
+	received    bool
+}
+
+func (stream *retryClientStream) SendMsg(m interface{}) error {
+	stream.request = m
+	return stream.ClientStream.SendMsg(m)
+}
+
+func (stream *retryClientStream) RecvMsg(m interface{}) error {
+	err := stream.ClientStream.RecvMsg(m)
+	if stream.received || stream.request == nil || status.Code(err) != codes.Unauthenticated {
+		stream.received = true
+		return err
+	}
+	stream.received = true
+
+	if refreshErr := stream.interceptor.refreshToken(); refreshErr != nil {
+		return err
+	}
+	other, err := stream.newStream()
+	if err != nil {
+		return err
+	}
+	if err := other.SendMsg(stream.request); err != nil {
+		return err
+	}
+	if err := other.CloseSend(); err != nil {
+		return err
+	}
+	stream.ClientStream = other
+	return other.RecvMsg(m)
+}
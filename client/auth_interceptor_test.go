@@ -0,0 +1,166 @@
+package client_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/client"
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+// rejectingExtractor rejects the next calls while reject is positive
+type rejectingExtractor struct {
+	service.IdentityExtractor
+	reject int32
+}
+
+func (extractor *rejectingExtractor) Extract(ctx context.Context) (*service.Identity, error) {
+	if atomic.AddInt32(&extractor.reject, -1) >= 0 {
+		return nil, status.Error(codes.Unauthenticated, "rejected")
+	}
+	return extractor.IdentityExtractor.Extract(ctx)
+}
+
+// startTestServer starts a server counting the logins in logins if it is not nil
+func startTestServer(t *testing.T, tokenDuration time.Duration, extractor *rejectingExtractor, logins *int32) string {
+	jwtManager := service.NewJWTManager("secret", tokenDuration)
+	extractor.IdentityExtractor = service.NewJWTIdentityExtractor(jwtManager)
+
+	userStore := service.NewInMemoryUserStore()
+	user, err := service.NewUser("admin1", "secret", "admin")
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+
+	accessibleRoles := map[string][]string{
+		"/pcbook.LaptopService/CreateLaptop": {"admin"},
+		"/pcbook.LaptopService/SearchLaptop": {"admin"},
+	}
+	interceptor := service.NewAuthInterceptor(extractor, accessibleRoles, nil, map[string]bool{"/pcbook.AuthService/Login": true})
+	countLogins := func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if logins != nil && info.FullMethod == "/pcbook.AuthService/Login" {
+			atomic.AddInt32(logins, 1)
+		}
+		return handler(ctx, req)
+	}
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(countLogins, interceptor.Unary()),
+		grpc.StreamInterceptor(interceptor.Stream()),
+	)
+	pb.RegisterAuthServiceServer(grpcServer, service.NewAuthServer(userStore, jwtManager))
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil))
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return listener.Addr().String()
+}
+
+func newTestLaptopClient(t *testing.T, serverAddress string, refreshBefore time.Duration) pb.LaptopServiceClient {
+	authConn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+
+	authClient := client.NewAuthClient(authConn, "admin1", "secret")
+	interceptor, err := client.NewAuthInterceptor(authClient, refreshBefore)
+	require.NoError(t, err)
+	t.Cleanup(interceptor.Close)
+
+	conn, err := grpc.Dial(
+		serverAddress,
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(interceptor.Unary()),
+		grpc.WithStreamInterceptor(interceptor.Stream()),
+	)
+	require.NoError(t, err)
+	return pb.NewLaptopServiceClient(conn)
+}
+
+func TestAuthInterceptorRefreshToken(t *testing.T) {
+	t.Parallel()
+
+	var logins int32
+	serverAddress := startTestServer(t, time.Minute, &rejectingExtractor{}, &logins)
+	authConn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+
+	// the interceptor asks for the wait before every refresh, which happens when refresh receives
+	waits := make(chan time.Duration)
+	refresh := make(chan time.Time)
+	after := func(wait time.Duration) <-chan time.Time {
+		waits <- wait
+		return refresh
+	}
+	interceptor, err := client.NewAuthInterceptorWithTimer(client.NewAuthClient(authConn, "admin1", "secret"), 20*time.Second, after)
+	require.NoError(t, err)
+	t.Cleanup(interceptor.Close)
+
+	wait := <-waits
+	require.True(t, wait > 35*time.Second && wait <= 40*time.Second, "wait: %v", wait)
+	require.Equal(t, int32(1), atomic.LoadInt32(&logins))
+
+	refresh <- time.Now()
+	<-waits
+	require.Equal(t, int32(2), atomic.LoadInt32(&logins))
+
+	interceptor.Close()
+	interceptor.Close()
+}
+
+func TestAuthInterceptorRefreshExpiredToken(t *testing.T) {
+	t.Parallel()
+
+	// the tokens come back already expired, as from a server whose clock is late
+	serverAddress := startTestServer(t, -time.Minute, &rejectingExtractor{}, nil)
+	authConn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+
+	waits := make(chan time.Duration)
+	after := func(wait time.Duration) <-chan time.Time {
+		waits <- wait
+		return make(chan time.Time)
+	}
+	interceptor, err := client.NewAuthInterceptorWithTimer(client.NewAuthClient(authConn, "admin1", "secret"), 20*time.Second, after)
+	require.NoError(t, err)
+	defer interceptor.Close()
+
+	require.Equal(t, time.Second, <-waits)
+}
+
+func TestAuthInterceptorRetryUnauthenticated(t *testing.T) {
+	t.Parallel()
+
+	extractor := &rejectingExtractor{}
+	serverAddress := startTestServer(t, time.Minute, extractor, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress, 0)
+
+	atomic.StoreInt32(&extractor.reject, 1)
+	_, err := laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+
+	atomic.StoreInt32(&extractor.reject, 1)
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	atomic.StoreInt32(&extractor.reject, 2)
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,13 @@
+package client
+
+import "time"
+
+// NewAuthInterceptorWithTimer returns an interceptor refreshing the access token
+// when the channel returned by after receives, so that tests decide when it happens
+func NewAuthInterceptorWithTimer(
+	authClient *AuthClient,
+	refreshBefore time.Duration,
+	after func(time.Duration) <-chan time.Time,
+) (*AuthInterceptor, error) {
+	return newAuthInterceptor(authClient, refreshBefore, after)
+}
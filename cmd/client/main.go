@@ -6,6 +6,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"github.com/google/uuid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
@@ -18,8 +19,13 @@ import (
 
 	"google.golang.org/grpc"
 
+	"github.com/neepoo/pcbook/cert"
+	"github.com/neepoo/pcbook/client"
+	"github.com/neepoo/pcbook/money"
 	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/rpcerror"
 	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/units"
 )
 
 func init()  {
@@ -28,15 +34,26 @@ func init()  {
 
 func createLaptop(laptopClient pb.LaptopServiceClient, laptop *pb.Laptop) {
 	req := &pb.CreateLaptopRequest{Laptop: laptop}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	res, err := laptopClient.CreateLaptop(ctx, req)
+	// the retries of a timed out creation send the same idempotency key, so the laptop is created once
+	idempotencyKey := uuid.NewString()
+	var res *pb.CreateLaptopResponse
+	var err error
+	for attempt := 1; attempt <= 3; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx = metadata.AppendToOutgoingContext(ctx, "idempotency-key", idempotencyKey)
+		res, err = laptopClient.CreateLaptop(ctx, req)
+		cancel()
+		if code := status.Code(err); code != codes.DeadlineExceeded && code != codes.Unavailable {
+			break
+		}
+		log.Printf("cannot create laptop, attempt %d: %v", attempt, err)
+	}
 	if err != nil {
 		st, ok := status.FromError(err)
 		if ok && st.Code() == codes.AlreadyExists {
 			log.Printf("laptop already exists")
 		} else {
-			log.Panic("cannot create laptop")
+			log.Panic("cannot create laptop: ", rpcerror.Describe(err))
 		}
 		return
 	}
@@ -67,8 +84,14 @@ func searchLaptop(laptopClient pb.LaptopServiceClient, filter *pb.Filter) {
 		log.Print("  + name: ", laptop.GetName())
 		log.Print("  + cpu cores: ", laptop.GetCpu().GetNumberCores())
 		log.Print("  + cpu min ghz: ", laptop.GetCpu().GetMinGhz())
-		log.Print("  + ram: ", laptop.GetRam())
+		log.Print("  + ram: ", units.FormatMemory(laptop.GetRam()))
 		log.Print("  + price: ", laptop.GetPriceUsd())
+		for _, price := range laptop.GetPrices() {
+			log.Print("  + price: ", money.Format(price))
+		}
+		if weight := units.LaptopWeight(laptop); weight != nil {
+			log.Print("  + weight: ", units.FormatWeight(weight))
+		}
 	}
 }
 
@@ -104,7 +127,7 @@ func uploadImage(laptopClient pb.LaptopServiceClient, laptopID string, imagePath
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	// 添加header
-	ctx = metadata.AppendToOutgoingContext(ctx, "name", "wzk")
+	ctx = metadata.AppendToOutgoingContext(ctx, "name", "wzk", "idempotency-key", uuid.NewString())
 	defer cancel()
 	stream, err := laptopClient.UploadImage(ctx)
 	if err != nil {
@@ -139,7 +162,7 @@ func uploadImage(laptopClient pb.LaptopServiceClient, laptopID string, imagePath
 	}
 	res, err := stream.CloseAndRecv()
 	if err != nil {
-		log.Fatal("cannot receive response: ", err)
+		log.Fatal("cannot receive response: ", rpcerror.Describe(err))
 	}
 	log.Printf("image uploaded with id: %s, size: %d", res.GetId(), res.GetSize())
 }
@@ -171,6 +194,7 @@ func rateLaptop(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores
 			}
 			if err != nil {
 				waitResponse <- fmt.Errorf("cannot receive stream response: %w", err)
+				return
 			}
 			log.Println("receive response: ", res)
 		}
@@ -201,6 +225,73 @@ func rateLaptop(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores
 
 }
 
+// batchCreateLaptops creates laptops in one stream and returns the number of created ones
+func batchCreateLaptops(laptopClient pb.LaptopServiceClient, laptops []*pb.Laptop, mode pb.BatchCreateLaptopsRequest_Mode) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	stream, err := laptopClient.BatchCreateLaptops(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create laptops: %w", err)
+	}
+
+	// the results must be received while sending, the server stops receiving when they are not
+	type result struct {
+		created int
+		err     error
+	}
+	waitResponse := make(chan result, 1)
+	go func() {
+		created := 0
+		for {
+			res, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				waitResponse <- result{created: created}
+				return
+			}
+			if err != nil {
+				waitResponse <- result{created: created, err: fmt.Errorf("cannot receive result: %w", err)}
+				return
+			}
+			if codes.Code(res.GetCode()) == codes.OK {
+				created++
+			} else {
+				log.Printf("laptop %d is not created: %s: %s", res.GetIndex(), codes.Code(res.GetCode()), res.GetError())
+			}
+		}
+	}()
+
+	for _, laptop := range laptops {
+		err = stream.Send(&pb.BatchCreateLaptopsRequest{Mode: mode, Laptop: laptop})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	res := <-waitResponse
+	if res.err != nil {
+		return res.created, res.err
+	}
+	if err != nil {
+		return res.created, fmt.Errorf("cannot send laptop: %w", err)
+	}
+	return res.created, nil
+}
+
+func testBatchCreateLaptops(laptopClient pb.LaptopServiceClient) {
+	laptops := make([]*pb.Laptop, 1000)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+	}
+	created, err := batchCreateLaptops(laptopClient, laptops, pb.BatchCreateLaptopsRequest_ALL_OR_NOTHING)
+	if err != nil {
+		log.Fatal(rpcerror.Describe(err))
+	}
+	log.Printf("created %d laptops", created)
+}
+
 func testRateLaptop(laptopClient pb.LaptopServiceClient){
 	n := 3
 	laptopIDs := make([]string, n)
@@ -223,17 +314,58 @@ func testRateLaptop(laptopClient pb.LaptopServiceClient){
 			scores[i] = sample.RandomLaptopScore()
 		}
 		err := rateLaptop(laptopClient, laptopIDs, scores)
+		if delay, ok := rpcerror.RetryDelay(err); ok {
+			log.Printf("rating is throttled, try again in %s", delay.Round(time.Second))
+			continue
+		}
 		if err != nil{
-			log.Fatal(err)
+			log.Fatal(rpcerror.Describe(err))
 		}
 	}
 }
 
 func main() {
 	serverAddr := flag.String("address", "0.0.0.0:9000", "the server address")
+	username := flag.String("username", "admin1", "the user to log in as")
+	password := flag.String("password", "secret", "the password of the user")
+	refreshBefore := flag.Duration("refresh-before", 30*time.Second, "how long before its expiry the access token is refreshed")
+	tlsCA := flag.String("tls-ca", "", "the CA file the server certificate must be signed by, enables TLS")
+	tlsCert := flag.String("tls-cert", "", "the certificate file of the client, for mutual TLS")
+	tlsKey := flag.String("tls-key", "", "the private key file of the client, for mutual TLS")
+	tlsServerName := flag.String("tls-server-name", "", "the name to verify the server certificate against, defaults to the host of the address")
 	flag.Parse()
 	log.Printf("dial server %s\n", *serverAddr)
-	conn, err := grpc.Dial(*serverAddr, grpc.WithInsecure())
+
+	// the TLS flags without a CA would silently connect in plaintext
+	if *tlsCA == "" && (*tlsCert != "" || *tlsKey != "" || *tlsServerName != "") {
+		log.Fatal("-tls-cert, -tls-key and -tls-server-name need -tls-ca")
+	}
+	transportOption := grpc.WithInsecure()
+	if *tlsCA != "" {
+		creds, err := cert.LoadClientCredentials(*tlsCA, *tlsCert, *tlsKey, *tlsServerName)
+		if err != nil {
+			log.Fatal("cannot load TLS credentials: ", err)
+		}
+		transportOption = grpc.WithTransportCredentials(creds)
+	}
+
+	authConn, err := grpc.Dial(*serverAddr, transportOption)
+	if err != nil {
+		log.Fatal("connect grpc server error", err)
+	}
+	authClient := client.NewAuthClient(authConn, *username, *password)
+	interceptor, err := client.NewAuthInterceptor(authClient, *refreshBefore)
+	if err != nil {
+		log.Fatal("cannot create auth interceptor: ", err)
+	}
+	defer interceptor.Close()
+
+	conn, err := grpc.Dial(
+		*serverAddr,
+		transportOption,
+		grpc.WithUnaryInterceptor(interceptor.Unary()),
+		grpc.WithStreamInterceptor(interceptor.Stream()),
+	)
 	if err != nil {
 		log.Fatal("connect grpc server error", err)
 	}
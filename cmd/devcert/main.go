@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/neepoo/pcbook/cert"
+)
+
+func main() {
+	out := flag.String("out", "certs", "the folder to write the certificates to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,0.0.0.0", "comma separated hosts the server certificate is valid for")
+	flag.Parse()
+
+	files, err := cert.GenerateDev(*out, strings.Split(*hosts, ","))
+	if err != nil {
+		log.Fatal("cannot generate certificates: ", err)
+	}
+	log.Printf("CA certificate: %s", files.CACert)
+	log.Printf("server certificate: %s, key: %s", files.ServerCert, files.ServerKey)
+	log.Printf("client certificate: %s, key: %s", files.ClientCert, files.ClientKey)
+}
@@ -1,36 +1,462 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/neepoo/pcbook/cert"
+	"github.com/neepoo/pcbook/config"
+	"github.com/neepoo/pcbook/gateway"
+	"github.com/neepoo/pcbook/logging"
+	"github.com/neepoo/pcbook/metrics"
 	"github.com/neepoo/pcbook/pb"
 	"github.com/neepoo/pcbook/service"
+	"github.com/neepoo/pcbook/units"
 )
 
+// seedDemoUsers creates users with a well-known password, for development only
+func seedDemoUsers(userStore service.UserStore) error {
+	err := createUser(userStore, "admin1", "secret", "admin")
+	if err != nil {
+		return err
+	}
+	return createUser(userStore, "user1", "secret", "user")
+}
+
+func createUser(userStore service.UserStore, username, password, role string) error {
+	user, err := service.NewUser(username, password, role)
+	if err != nil {
+		return err
+	}
+	return userStore.Save(user)
+}
+
+// accessibleRoles returns the roles allowed to call each method
+func accessibleRoles() map[string][]string {
+	const laptopServicePath = "/pcbook.LaptopService/"
+	const adminServicePath = "/pcbook.AdminService/"
+
+	return map[string][]string{
+		laptopServicePath + "CreateLaptop":         {"admin"},
+		laptopServicePath + "UploadImage":          {"admin"},
+		laptopServicePath + "UpdateLaptop":         {"admin"},
+		laptopServicePath + "DeleteLaptop":         {"admin"},
+		laptopServicePath + "BatchCreateLaptops":   {"admin"},
+		laptopServicePath + "GetLaptop":            {"admin", "user"},
+		laptopServicePath + "SearchLaptop":         {"admin", "user"},
+		laptopServicePath + "RateLaptop":           {"admin", "user"},
+		laptopServicePath + "WatchLaptops":         {"admin", "user"},
+		laptopServicePath + "GetPriceHistory":      {"admin", "user"},
+		laptopServicePath + "CreatePriceAlert":     {"admin", "user"},
+		laptopServicePath + "ListPriceAlerts":      {"admin", "user"},
+		laptopServicePath + "DeletePriceAlert":     {"admin", "user"},
+		laptopServicePath + "WatchPriceAlerts":     {"admin", "user"},
+		adminServicePath + "ListFlaggedRatings":    {"admin"},
+		adminServicePath + "ReviewFlaggedRating":   {"admin"},
+		adminServicePath + "CreateAPIKey":          {"admin"},
+		adminServicePath + "ListAPIKeys":           {"admin"},
+		adminServicePath + "RevokeAPIKey":          {"admin"},
+		adminServicePath + "RegisterWebhook":       {"admin"},
+		adminServicePath + "ListWebhooks":          {"admin"},
+		adminServicePath + "DeleteWebhook":         {"admin"},
+		adminServicePath + "ListWebhookDeliveries": {"admin"},
+		adminServicePath + "RetryWebhookDelivery":  {"admin"},
+		adminServicePath + "QueryAuditLog":         {"admin"},
+		adminServicePath + "UpdateExchangeRates":   {"admin"},
+	}
+}
+
+// requiredScopes returns the scope an API key needs to call each method.
+// API keys cannot call the methods missing from it.
+func requiredScopes() map[string]string {
+	const laptopServicePath = "/pcbook.LaptopService/"
+
+	return map[string]string{
+		laptopServicePath + "CreateLaptop":       service.ScopeWrite,
+		laptopServicePath + "UploadImage":        service.ScopeUpload,
+		laptopServicePath + "UpdateLaptop":       service.ScopeWrite,
+		laptopServicePath + "DeleteLaptop":       service.ScopeWrite,
+		laptopServicePath + "BatchCreateLaptops": service.ScopeWrite,
+		laptopServicePath + "GetLaptop":          service.ScopeRead,
+		laptopServicePath + "SearchLaptop":       service.ScopeRead,
+		laptopServicePath + "RateLaptop":         service.ScopeRate,
+		laptopServicePath + "WatchLaptops":       service.ScopeRead,
+		laptopServicePath + "GetPriceHistory":    service.ScopeRead,
+		laptopServicePath + "CreatePriceAlert":   service.ScopeWrite,
+		laptopServicePath + "ListPriceAlerts":    service.ScopeRead,
+		laptopServicePath + "DeletePriceAlert":   service.ScopeWrite,
+		laptopServicePath + "WatchPriceAlerts":   service.ScopeRead,
+	}
+}
+
+// publicMethods returns the methods which can be called without an access token
+func publicMethods() map[string]bool {
+	return map[string]bool{
+		"/pcbook.AuthService/Login":                                      true,
+		"/grpc.health.v1.Health/Check":                                   true,
+		"/grpc.health.v1.Health/Watch":                                   true,
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": true,
+	}
+}
+
+func serveMetrics(registry *metrics.Registry, host string, port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	logging.Default().Info("start metrics server", "port", port)
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		log.Fatal("cannot start metrics server: ", err)
+	}
+}
+
 func main() {
-	port := flag.Int("port", 0, "the server port")
-	flag.Parse()
-	log.Printf("start server on port %d", *port)
-	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskImageStore("img")
-	ratingStore := service.NewInMemoryRatingScore()
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	printConfig := fs.Bool("print-config", false, "print the effective configuration as JSON and exit")
+	cfg, err := config.Load(fs, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *printConfig {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(cfg.Redacted())
+		if err != nil {
+			log.Fatal("cannot print config: ", err)
+		}
+		return
+	}
+
+	level, err := logging.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		log.Fatal("cannot parse log level: ", err)
+	}
+	logger := logging.New(os.Stderr, level, cfg.Log.JSON)
+	logging.SetDefault(logger)
+
+	ratingAggregator, err := service.NewRatingAggregator(
+		cfg.Rating.Aggregation,
+		cfg.Rating.PriorMean,
+		cfg.Rating.PriorWeight,
+	)
+	if err != nil {
+		log.Fatal("cannot create rating aggregator: ", err)
+	}
+	logger.Info("start server", "host", cfg.Server.Host, "port", cfg.Server.Port)
+	userStore := service.NewInMemoryUserStore()
+	jwtManager := service.NewJWTManager(cfg.Auth.SecretKey, time.Duration(cfg.Auth.TokenDuration))
+	authServer := service.NewAuthServer(userStore, jwtManager)
+	var laptopStore service.LaptopStore = service.NewInMemoryLaptopStore()
+	var fileLaptopStore *service.FileLaptopStore
+	if cfg.Store.Backend == "file" {
+		fileLaptopStore = service.NewFileLaptopStore(cfg.Store.LaptopFile)
+		laptopStore = fileLaptopStore
+	}
+	imageStore := service.NewDiskImageStore(cfg.Store.ImageFolder)
+	ratingStore := service.NewInMemoryRatingScore(time.Duration(cfg.Rating.HalfLife))
+	flaggedRatingStore := service.NewInMemoryFlaggedRatingStore()
+	apiKeyStore := service.NewInMemoryAPIKeyStore()
 	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
-	grpcServer := grpc.NewServer()
+	laptopServer.RatingAggregator = ratingAggregator
+	laptopServer.MaxImageSize = cfg.Image.MaxSize
+	if cfg.Catalog.WeightUnit != "" {
+		// the unit is checked when the configuration is loaded
+		laptopServer.WeightUnit, _ = units.ParseWeightUnit(cfg.Catalog.WeightUnit)
+	}
+	if cfg.Idempotency.TTL > 0 {
+		laptopServer.Idempotency = service.NewIdempotencyStore(time.Duration(cfg.Idempotency.TTL))
+	}
+	laptopServer.BatchChunkSize = cfg.Batch.ChunkSize
+	laptopServer.BatchMaxSize = cfg.Batch.MaxSize
+	if cfg.Batch.Writers > 0 {
+		laptopServer.BatchWriters = service.NewSemaphore(cfg.Batch.Writers)
+	}
+	var auditLog service.AuditLog = service.NewInMemoryAuditLog()
+	var fileAuditLog *service.FileAuditLog
+	if cfg.Store.AuditFile != "" {
+		fileAuditLog, err = service.OpenFileAuditLog(cfg.Store.AuditFile)
+		if err != nil {
+			log.Fatal("cannot open audit log: ", err)
+		}
+		auditLog = fileAuditLog
+	}
+	laptopServer.AuditLog = auditLog
+	exchangeRates, err := service.NewExchangeRates(nil)
+	if cfg.Catalog.ExchangeRatesFile != "" {
+		exchangeRates, err = service.LoadExchangeRates(cfg.Catalog.ExchangeRatesFile)
+	}
+	if err != nil {
+		log.Fatal("cannot load exchange rates: ", err)
+	}
+	laptopServer.ExchangeRates = exchangeRates
+	laptopServer.PriceHistoryStore = service.NewInMemoryPriceHistoryStore()
+	eventBus := service.NewLaptopEventBus(cfg.Watch.History, cfg.Watch.Buffer)
+	laptopServer.EventBus = eventBus
+	priceAlertNotifier := service.NewPriceAlertNotifier(cfg.Watch.Buffer)
+	laptopServer.PriceAlertStore = service.NewInMemoryPriceAlertStore(cfg.PriceAlert.MaxPerOwner)
+	laptopServer.PriceAlertNotifier = priceAlertNotifier
+	if cfg.Rating.UserLimit > 0 {
+		laptopServer.UserRatingLimiter = service.NewRateLimiter(cfg.Rating.UserLimit, time.Duration(cfg.Rating.LimitWindow))
+	}
+	if cfg.Rating.LaptopLimit > 0 {
+		laptopServer.LaptopRatingLimiter = service.NewRateLimiter(cfg.Rating.LaptopLimit, time.Duration(cfg.Rating.LimitWindow))
+	}
+	if cfg.Rating.BurstThreshold > 0 {
+		laptopServer.BurstDetector = service.NewBurstDetector(cfg.Rating.BurstThreshold, time.Duration(cfg.Rating.BurstWindow), 1, 10)
+		laptopServer.FlaggedRatingStore = flaggedRatingStore
+	}
+	adminServer := service.NewAdminServer(flaggedRatingStore, ratingStore)
+	adminServer.APIKeyStore = apiKeyStore
+	adminServer.AuditLog = auditLog
+	adminServer.ExchangeRates = exchangeRates
+	webhookStore := service.NewInMemoryWebhookStore()
+	webhookDispatcher := service.NewWebhookDispatcher(webhookStore, eventBus)
+	webhookDispatcher.Client = &http.Client{Timeout: time.Duration(cfg.Webhook.Timeout)}
+	webhookDispatcher.MaxAttempts = cfg.Webhook.MaxAttempts
+	webhookDispatcher.InitialBackoff = time.Duration(cfg.Webhook.InitialBackoff)
+	webhookDispatcher.MaxBackoff = time.Duration(cfg.Webhook.MaxBackoff)
+	err = webhookDispatcher.Start(cfg.Webhook.Workers)
+	if err != nil {
+		log.Fatal("cannot start webhook dispatcher: ", err)
+	}
+	adminServer.WebhookStore = webhookStore
+	adminServer.WebhookDispatcher = webhookDispatcher
+	var extractor service.IdentityExtractor
+	switch cfg.Auth.Identity {
+	case "jwt":
+		extractor = service.NewJWTIdentityExtractor(jwtManager)
+	case "header":
+		extractor = service.NewHeaderIdentityExtractor("x-user-id", "x-user-role")
+	default:
+		log.Fatalf("unknown identity source: %q", cfg.Auth.Identity)
+	}
+	roles := accessibleRoles()
+	if cfg.Auth.AccessRolesFile != "" {
+		roles, err = service.LoadAccessibleRoles(cfg.Auth.AccessRolesFile)
+		if err != nil {
+			log.Fatal("cannot load accessible roles: ", err)
+		}
+	}
+	extractor = service.NewAPIKeyIdentityExtractor(apiKeyStore, extractor)
+	interceptor := service.NewAuthInterceptor(extractor, roles, requiredScopes(), publicMethods())
+	loggingInterceptor := service.NewLoggingInterceptor(logger)
+	registry := metrics.NewRegistry()
+	metricsInterceptor := service.NewMetricsInterceptor(registry)
+	registry.MustRegister(
+		metrics.NewGaugeFunc("pcbook_laptops", "Number of laptops in the store.", func() float64 {
+			return float64(laptopStore.Count())
+		}),
+		metrics.NewGaugeFunc("pcbook_image_bytes", "Number of bytes of the saved images.", func() float64 {
+			return float64(imageStore.TotalSize())
+		}),
+		metrics.NewGaugeFunc("pcbook_ratings", "Number of ratings counted for all laptops.", func() float64 {
+			return float64(ratingStore.Count())
+		}),
+	)
+	healthServer := health.NewServer()
+	healthReporter := service.NewHealthReporter(
+		healthServer,
+		"pcbook.AuthService",
+		"pcbook.LaptopService",
+		"pcbook.AdminService",
+	)
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		loggingInterceptor.Unary(),
+		metricsInterceptor.Unary(),
+		healthReporter.Unary(),
+		interceptor.Unary(),
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		loggingInterceptor.Stream(),
+		metricsInterceptor.Stream(),
+		healthReporter.Stream(),
+		interceptor.Stream(),
+	}
+	serverOptions := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLS.CertFile != "" {
+		tlsConfig, err = cert.LoadServerTLSConfig(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			log.Fatal("cannot load TLS credentials: ", err)
+		}
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	grpcServer := grpc.NewServer(serverOptions...)
+	pb.RegisterAuthServiceServer(grpcServer, authServer)
 	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	pb.RegisterAdminServiceServer(grpcServer, adminServer)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
 	reflection.Register(grpcServer)
-	address := fmt.Sprintf("0.0.0.0:%d", *port)
+	if cfg.Server.MetricsPort > 0 {
+		go serveMetrics(registry, cfg.Server.Host, cfg.Server.MetricsPort)
+	}
+	address := net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.Port))
 	listener, err := net.Listen("tcp", address)
-	if err!=nil{
+	if err != nil {
+		log.Fatal("cannot start server: ", err)
+	}
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- grpcServer.Serve(listener)
+	}()
+
+	var httpServer *http.Server
+	if cfg.Server.HTTPPort > 0 {
+		restGateway := gateway.NewGateway(laptopServer)
+		restGateway.UnaryInterceptors = unaryInterceptors
+		restGateway.StreamInterceptors = streamInterceptors
+		httpServer = &http.Server{
+			Addr:      net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.HTTPPort)),
+			Handler:   restGateway,
+			TLSConfig: tlsConfig,
+		}
+		go func() {
+			serveErr <- serveGateway(httpServer)
+		}()
+	}
+
+	// the services report NOT_SERVING until their stores are initialized
+	if cfg.Auth.SeedDemoUsers {
+		logger.Warn("creating demo users with a well-known password")
+		err = seedDemoUsers(userStore)
+		if err != nil {
+			log.Fatal("cannot seed users: ", err)
+		}
+	}
+	// stopFlushing ends the periodic flushes, flushing is done once they are over
+	stopFlushing := make(chan struct{})
+	var flushing sync.WaitGroup
+	if fileLaptopStore != nil {
+		err = fileLaptopStore.Load()
+		if err != nil {
+			log.Fatal("cannot load laptops: ", err)
+		}
+		logger.Info("loaded laptops", "file", cfg.Store.LaptopFile, "count", fileLaptopStore.Count())
+		err = laptopServer.RecordCurrentPrices(context.Background())
+		if err != nil {
+			log.Fatal("cannot record the prices of the loaded laptops: ", err)
+		}
+		if cfg.Store.FlushInterval > 0 {
+			flushing.Add(1)
+			go func() {
+				defer flushing.Done()
+				flushPeriodically(fileLaptopStore, time.Duration(cfg.Store.FlushInterval), stopFlushing)
+			}()
+		}
+	}
+	healthReporter.SetAllServing(true)
+	logger.Info("server is serving")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-serveErr:
 		log.Fatal("cannot start server: ", err)
+	case sig := <-signals:
+		logger.Info("shutting down", "signal", sig)
+	}
+
+	healthReporter.SetAllServing(false)
+	// end the watch streams, which would never finish by themselves
+	eventBus.Close()
+	priceAlertNotifier.Close()
+	if httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+		err = httpServer.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			logger.Warn("cannot shut down REST gateway gracefully", "error", err)
+		}
+	}
+	gracefulStop(grpcServer, time.Duration(cfg.Server.ShutdownTimeout))
+	// the laptops are flushed a last time when the store is closed
+	close(stopFlushing)
+	flushing.Wait()
+
+	closers := []io.Closer{webhookDispatcher, imageStore}
+	if fileLaptopStore != nil {
+		closers = append(closers, fileLaptopStore)
 	}
-	err = grpcServer.Serve(listener)
-	if err != nil{
-		log.Fatal("cannot start grpc server: ", err)
+	if fileAuditLog != nil {
+		closers = append(closers, fileAuditLog)
+	}
+	for _, closer := range closers {
+		err = closer.Close()
+		if err != nil {
+			logger.Error("cannot close store", "error", err)
+		}
+	}
+	logger.Info("server stopped")
+}
+
+// serveGateway serves the REST gateway, and returns nil once it is shut down
+func serveGateway(httpServer *http.Server) error {
+	logging.Default().Info("start REST gateway", "address", httpServer.Addr)
+	var err error
+	if httpServer.TLSConfig != nil {
+		err = httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return fmt.Errorf("REST gateway: %w", err)
+}
+
+// gracefulStop waits for the calls in flight to finish, and cancels them after timeout
+func gracefulStop(grpcServer *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		logging.Default().Warn("calls still in flight after shutdown timeout, cancelling them", "timeout", timeout)
+		grpcServer.Stop()
+		<-stopped
+	}
+}
+
+// flushPeriodically flushes the laptops of store every interval until done is closed
+func flushPeriodically(store *service.FileLaptopStore, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := store.Flush()
+		if err != nil {
+			logging.Default().Error("cannot flush laptops", "error", err)
+		}
 	}
 }
@@ -0,0 +1,445 @@
+// Package config loads the server configuration from a JSON file,
+// environment variables and command line flags, in increasing priority
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/neepoo/pcbook/logging"
+	"github.com/neepoo/pcbook/units"
+)
+
+// EnvPrefix starts the environment variables overriding the configuration.
+// The variable of a flag is the prefix and the flag in upper case,
+// with dashes replaced by underscores, e.g. PCBOOK_TLS_CERT for -tls-cert.
+const EnvPrefix = "PCBOOK_"
+
+// MinSecretKeyLength is the min number of bytes of the key signing the access tokens,
+// which has no default so that nobody can sign the tokens of a server with a well-known key
+const MinSecretKeyLength = 32
+
+// Config is the configuration of the server
+type Config struct {
+	Server      ServerConfig      `json:"server"`
+	Store       StoreConfig       `json:"store"`
+	Image       ImageConfig       `json:"image"`
+	Catalog     CatalogConfig     `json:"catalog"`
+	Watch       WatchConfig       `json:"watch"`
+	PriceAlert  PriceAlertConfig  `json:"price_alert"`
+	Batch       BatchConfig       `json:"batch"`
+	Idempotency IdempotencyConfig `json:"idempotency"`
+	Webhook     WebhookConfig     `json:"webhook"`
+	TLS         TLSConfig         `json:"tls"`
+	Auth        AuthConfig        `json:"auth"`
+	Rating      RatingConfig      `json:"rating"`
+	Log         LogConfig         `json:"log"`
+}
+
+type ServerConfig struct {
+	Host            string   `json:"host"`
+	Port            int      `json:"port"`
+	MetricsPort     int      `json:"metrics_port"`
+	HTTPPort        int      `json:"http_port"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+type StoreConfig struct {
+	// Backend is memory, or file to persist the laptops to LaptopFile
+	Backend       string   `json:"backend"`
+	LaptopFile    string   `json:"laptop_file"`
+	FlushInterval Duration `json:"flush_interval"`
+	ImageFolder   string   `json:"image_folder"`
+	// AuditFile is the file the audit log is appended to, the audit log is kept in memory if it is empty
+	AuditFile string `json:"audit_file"`
+}
+
+type ImageConfig struct {
+	MaxSize int `json:"max_size"`
+}
+
+type CatalogConfig struct {
+	// WeightUnit is the unit the laptop weights are converted to when saved, like kg or lb,
+	// empty to keep the unit they are sent in
+	WeightUnit string `json:"weight_unit"`
+	// ExchangeRatesFile is the JSON file of the rates deriving the prices in other currencies from the USD ones,
+	// which the admins update. The rates are kept in memory if it is empty.
+	ExchangeRatesFile string `json:"exchange_rates_file"`
+}
+
+type WatchConfig struct {
+	// History is the number of events kept for the watchers resuming from a cursor
+	History int `json:"history"`
+	// Buffer is the number of events, or of price alert matches, a watcher can be late before being disconnected
+	Buffer int `json:"buffer"`
+}
+
+type PriceAlertConfig struct {
+	// MaxPerOwner is the max number of price alerts of a user, 0 for no limit
+	MaxPerOwner int `json:"max_per_owner"`
+}
+
+type BatchConfig struct {
+	// ChunkSize is the number of laptops of a batch saved at once
+	ChunkSize int `json:"chunk_size"`
+	// Writers is the number of chunks saved at once across the batches, 0 for no limit
+	Writers int `json:"writers"`
+	// MaxSize is the max number of laptops of an all-or-nothing batch, 0 for no limit
+	MaxSize int `json:"max_size"`
+}
+
+// IdempotencyConfig is the config of the idempotency keys of CreateLaptop and UploadImage
+type IdempotencyConfig struct {
+	// TTL is how long the outcome of a call is replayed for, 0 to ignore the idempotency keys
+	TTL Duration `json:"ttl"`
+}
+
+type WebhookConfig struct {
+	MaxAttempts    int      `json:"max_attempts"`
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+	Timeout        Duration `json:"timeout"`
+	Workers        int      `json:"workers"`
+}
+
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+type AuthConfig struct {
+	SecretKey       string   `json:"secret_key"`
+	TokenDuration   Duration `json:"token_duration"`
+	Identity        string   `json:"identity"`
+	AccessRolesFile string   `json:"access_roles_file"`
+	// SeedDemoUsers creates the admin1 and user1 users with the password "secret", for development only
+	SeedDemoUsers bool `json:"seed_demo_users"`
+}
+
+type RatingConfig struct {
+	Aggregation    string   `json:"aggregation"`
+	PriorMean      float64  `json:"prior_mean"`
+	PriorWeight    float64  `json:"prior_weight"`
+	HalfLife       Duration `json:"half_life"`
+	UserLimit      int      `json:"user_limit"`
+	LaptopLimit    int      `json:"laptop_limit"`
+	LimitWindow    Duration `json:"limit_window"`
+	BurstThreshold int      `json:"burst_threshold"`
+	BurstWindow    Duration `json:"burst_window"`
+}
+
+type LogConfig struct {
+	Level string `json:"level"`
+	JSON  bool   `json:"json"`
+}
+
+// Default returns the configuration used for the settings nobody sets
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Host:            "0.0.0.0",
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+		Store: StoreConfig{
+			Backend:       "memory",
+			FlushInterval: Duration(time.Minute),
+			ImageFolder:   "img",
+		},
+		Image: ImageConfig{
+			MaxSize: 1 << 20,
+		},
+		Watch: WatchConfig{
+			History: 1000,
+			Buffer:  100,
+		},
+		PriceAlert: PriceAlertConfig{
+			MaxPerOwner: 50,
+		},
+		Batch: BatchConfig{
+			ChunkSize: 100,
+			Writers:   2,
+			MaxSize:   10000,
+		},
+		Idempotency: IdempotencyConfig{
+			TTL: Duration(24 * time.Hour),
+		},
+		Webhook: WebhookConfig{
+			MaxAttempts:    6,
+			InitialBackoff: Duration(time.Second),
+			MaxBackoff:     Duration(5 * time.Minute),
+			Timeout:        Duration(10 * time.Second),
+			Workers:        4,
+		},
+		Auth: AuthConfig{
+			TokenDuration: Duration(15 * time.Minute),
+			Identity:      "jwt",
+		},
+		Rating: RatingConfig{
+			Aggregation:    "mean",
+			PriorMean:      7,
+			PriorWeight:    10,
+			HalfLife:       Duration(30 * 24 * time.Hour),
+			UserLimit:      60,
+			LaptopLimit:    600,
+			LimitWindow:    Duration(time.Minute),
+			BurstThreshold: 5,
+			BurstWindow:    Duration(time.Minute),
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+	}
+}
+
+// RegisterFlags defines a flag for every setting, defaulting to its current value
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Server.Host, "host", cfg.Server.Host, "the host the server listens on")
+	fs.IntVar(&cfg.Server.Port, "port", cfg.Server.Port, "the server port")
+	fs.IntVar(&cfg.Server.MetricsPort, "metrics-port", cfg.Server.MetricsPort, "the port serving the Prometheus metrics on /metrics, 0 to disable")
+	fs.IntVar(&cfg.Server.HTTPPort, "http-port", cfg.Server.HTTPPort, "the port serving the laptop service as a REST API, 0 to disable")
+	fs.DurationVar((*time.Duration)(&cfg.Server.ShutdownTimeout), "shutdown-timeout", time.Duration(cfg.Server.ShutdownTimeout), "how long to wait for the calls in flight on shutdown before cancelling them")
+
+	fs.StringVar(&cfg.Store.Backend, "store-backend", cfg.Store.Backend, "where the laptops are kept: memory, or file to persist them to the laptop store file")
+	fs.StringVar(&cfg.Store.LaptopFile, "laptop-store-file", cfg.Store.LaptopFile, "the file persisting the laptops of the file backend")
+	fs.DurationVar((*time.Duration)(&cfg.Store.FlushInterval), "store-flush-interval", time.Duration(cfg.Store.FlushInterval), "how often the laptops are flushed to the laptop store file, 0 to flush only on shutdown")
+	fs.StringVar(&cfg.Store.ImageFolder, "image-folder", cfg.Store.ImageFolder, "the folder the uploaded images are saved to")
+	fs.StringVar(&cfg.Store.AuditFile, "audit-log-file", cfg.Store.AuditFile, "the file the audit log of the laptop changes is appended to, empty to keep it in memory")
+
+	fs.IntVar(&cfg.Image.MaxSize, "image-max-size", cfg.Image.MaxSize, "the max size of an uploaded image in bytes")
+
+	fs.StringVar(&cfg.Catalog.WeightUnit, "weight-unit", cfg.Catalog.WeightUnit, "the unit the laptop weights are converted to when saved: kg or lb, empty to keep the unit they are sent in")
+	fs.StringVar(&cfg.Catalog.ExchangeRatesFile, "exchange-rates-file", cfg.Catalog.ExchangeRatesFile, "the JSON file of the exchange rates from USD by currency code, empty to keep them in memory")
+
+	fs.IntVar(&cfg.Watch.History, "watch-history", cfg.Watch.History, "the number of laptop events kept for the watchers resuming from a cursor")
+	fs.IntVar(&cfg.Watch.Buffer, "watch-buffer", cfg.Watch.Buffer, "the number of laptop events, or of price alert matches, a watcher can be late before being disconnected")
+	fs.IntVar(&cfg.PriceAlert.MaxPerOwner, "price-alert-max-per-owner", cfg.PriceAlert.MaxPerOwner, "the max number of price alerts of a user, 0 for no limit")
+	fs.IntVar(&cfg.Batch.ChunkSize, "batch-chunk-size", cfg.Batch.ChunkSize, "the number of laptops of a batch saved at once")
+	fs.IntVar(&cfg.Batch.Writers, "batch-writers", cfg.Batch.Writers, "the number of laptop chunks saved at once across the batches, 0 for no limit")
+	fs.DurationVar((*time.Duration)(&cfg.Idempotency.TTL), "idempotency-ttl", time.Duration(cfg.Idempotency.TTL), "how long the outcome of a call made with an idempotency key is replayed for, 0 to ignore the keys")
+	fs.IntVar(&cfg.Batch.MaxSize, "batch-max-size", cfg.Batch.MaxSize, "the max number of laptops of an all-or-nothing batch, 0 for no limit")
+
+	fs.IntVar(&cfg.Webhook.MaxAttempts, "webhook-max-attempts", cfg.Webhook.MaxAttempts, "the number of attempts at delivering an event to a webhook before it becomes a dead letter")
+	fs.DurationVar((*time.Duration)(&cfg.Webhook.InitialBackoff), "webhook-initial-backoff", time.Duration(cfg.Webhook.InitialBackoff), "the delay before retrying a failed webhook delivery, doubled after every attempt")
+	fs.DurationVar((*time.Duration)(&cfg.Webhook.MaxBackoff), "webhook-max-backoff", time.Duration(cfg.Webhook.MaxBackoff), "the max delay between two attempts at a webhook delivery")
+	fs.DurationVar((*time.Duration)(&cfg.Webhook.Timeout), "webhook-timeout", time.Duration(cfg.Webhook.Timeout), "how long to wait for a webhook to respond")
+	fs.IntVar(&cfg.Webhook.Workers, "webhook-workers", cfg.Webhook.Workers, "the number of webhook deliveries made at once")
+
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "the certificate file of the server, enables TLS")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "the private key file of the server")
+	fs.StringVar(&cfg.TLS.ClientCAFile, "tls-client-ca", cfg.TLS.ClientCAFile, "the CA file client certificates must be signed by, enables mutual TLS")
+
+	fs.StringVar(&cfg.Auth.SecretKey, "secret-key", cfg.Auth.SecretKey, fmt.Sprintf("the key signing the access tokens, at least %d bytes", MinSecretKeyLength))
+	fs.DurationVar((*time.Duration)(&cfg.Auth.TokenDuration), "token-duration", time.Duration(cfg.Auth.TokenDuration), "how long an access token is valid")
+	fs.StringVar(&cfg.Auth.Identity, "identity", cfg.Auth.Identity, "where the caller identity comes from: jwt, or header to trust the x-user-id and x-user-role metadata set by a proxy")
+	fs.StringVar(&cfg.Auth.AccessRolesFile, "access-roles", cfg.Auth.AccessRolesFile, "a JSON file mapping full method names to their allowed roles")
+	fs.BoolVar(&cfg.Auth.SeedDemoUsers, "seed-demo-users", cfg.Auth.SeedDemoUsers, "create the admin1 and user1 users with the password \"secret\", for development only")
+
+	fs.StringVar(&cfg.Rating.Aggregation, "rating-aggregation", cfg.Rating.Aggregation, "how laptop ratings are aggregated: mean, bayesian or decay")
+	fs.Float64Var(&cfg.Rating.PriorMean, "rating-prior-mean", cfg.Rating.PriorMean, "the prior mean of the bayesian rating aggregation")
+	fs.Float64Var(&cfg.Rating.PriorWeight, "rating-prior-weight", cfg.Rating.PriorWeight, "the number of prior ratings of the bayesian rating aggregation")
+	fs.DurationVar((*time.Duration)(&cfg.Rating.HalfLife), "rating-half-life", time.Duration(cfg.Rating.HalfLife), "the half life of a rating in the decay rating aggregation")
+	fs.IntVar(&cfg.Rating.UserLimit, "rating-user-limit", cfg.Rating.UserLimit, "the max number of ratings a user can send per rate window, 0 to disable")
+	fs.IntVar(&cfg.Rating.LaptopLimit, "rating-laptop-limit", cfg.Rating.LaptopLimit, "the max number of ratings a laptop can receive per rate window, 0 to disable")
+	fs.DurationVar((*time.Duration)(&cfg.Rating.LimitWindow), "rating-limit-window", time.Duration(cfg.Rating.LimitWindow), "the window of the rating rate limits")
+	fs.IntVar(&cfg.Rating.BurstThreshold, "rating-burst-threshold", cfg.Rating.BurstThreshold, "the max number of extreme scores a user can send per burst window before being flagged, 0 to disable")
+	fs.DurationVar((*time.Duration)(&cfg.Rating.BurstWindow), "rating-burst-window", time.Duration(cfg.Rating.BurstWindow), "the window of the rating burst detection")
+
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "the minimum level of the logged lines: debug, info, warn or error")
+	fs.BoolVar(&cfg.Log.JSON, "log-json", cfg.Log.JSON, "log one JSON object per line instead of logfmt text")
+}
+
+// Load returns the configuration made of the defaults, overridden by the JSON file
+// given with -config, then by the environment variables, then by the flags in args.
+// It registers the flags of all the settings and -config in fs before parsing args.
+func Load(fs *flag.FlagSet, args []string, lookupEnv func(key string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	configFile := findConfigFile(args)
+	if configFile == "" {
+		configFile, _ = lookupEnv(EnvPrefix + "CONFIG")
+	}
+	if configFile != "" {
+		err := cfg.loadFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fs.String("config", configFile, "a JSON configuration file, overridden by "+EnvPrefix+"* environment variables and flags")
+	cfg.RegisterFlags(fs)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" {
+			return
+		}
+		value, ok := lookupEnv(EnvName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, EnvName(f.Name), setErr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// EnvName returns the environment variable overriding a flag
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// findConfigFile returns the value of the -config flag in args, before they are parsed
+func findConfigFile(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func (cfg *Config) loadFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		return fmt.Errorf("cannot parse config file %s: %w", filename, err)
+	}
+	return nil
+}
+
+// Validate checks every setting and reports all the invalid ones at once
+func (cfg *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(cfg.Server.Port >= 0 && cfg.Server.Port <= 65535, "server.port must be between 0 and 65535, got %d", cfg.Server.Port)
+	check(cfg.Server.MetricsPort >= 0 && cfg.Server.MetricsPort <= 65535, "server.metrics_port must be between 0 and 65535, got %d", cfg.Server.MetricsPort)
+	check(cfg.Server.MetricsPort == 0 || cfg.Server.MetricsPort != cfg.Server.Port, "server.metrics_port must differ from server.port")
+	check(cfg.Server.HTTPPort >= 0 && cfg.Server.HTTPPort <= 65535, "server.http_port must be between 0 and 65535, got %d", cfg.Server.HTTPPort)
+	check(cfg.Server.HTTPPort == 0 || cfg.Server.HTTPPort != cfg.Server.Port && cfg.Server.HTTPPort != cfg.Server.MetricsPort, "server.http_port must differ from server.port and server.metrics_port")
+	check(cfg.Server.ShutdownTimeout >= 0, "server.shutdown_timeout must not be negative")
+
+	switch cfg.Store.Backend {
+	case "memory":
+	case "file":
+		check(cfg.Store.LaptopFile != "", "store.laptop_file is required by the file store backend")
+	default:
+		check(false, "store.backend must be memory or file, got %q", cfg.Store.Backend)
+	}
+	check(cfg.Store.FlushInterval >= 0, "store.flush_interval must not be negative")
+	check(cfg.Store.ImageFolder != "", "store.image_folder is required")
+
+	check(cfg.Image.MaxSize > 0, "image.max_size must be positive, got %d", cfg.Image.MaxSize)
+
+	if cfg.Catalog.WeightUnit != "" {
+		_, err := units.ParseWeightUnit(cfg.Catalog.WeightUnit)
+		check(err == nil, "catalog.weight_unit must be kg or lb, got %q", cfg.Catalog.WeightUnit)
+	}
+
+	check(cfg.Watch.History >= 0, "watch.history must not be negative")
+	check(cfg.Watch.Buffer > 0, "watch.buffer must be positive, got %d", cfg.Watch.Buffer)
+	check(cfg.PriceAlert.MaxPerOwner >= 0, "price_alert.max_per_owner must not be negative")
+	check(cfg.Batch.ChunkSize > 0, "batch.chunk_size must be positive, got %d", cfg.Batch.ChunkSize)
+	check(cfg.Batch.Writers >= 0, "batch.writers must not be negative")
+	check(cfg.Batch.MaxSize >= 0, "batch.max_size must not be negative")
+	check(cfg.Idempotency.TTL >= 0, "idempotency.ttl must not be negative")
+
+	check(cfg.Webhook.MaxAttempts > 0, "webhook.max_attempts must be positive, got %d", cfg.Webhook.MaxAttempts)
+	check(cfg.Webhook.InitialBackoff > 0, "webhook.initial_backoff must be positive")
+	check(cfg.Webhook.MaxBackoff >= cfg.Webhook.InitialBackoff, "webhook.max_backoff must not be less than webhook.initial_backoff")
+	check(cfg.Webhook.Timeout > 0, "webhook.timeout must be positive")
+	check(cfg.Webhook.Workers > 0, "webhook.workers must be positive, got %d", cfg.Webhook.Workers)
+
+	check(cfg.TLS.CertFile == "" || cfg.TLS.KeyFile != "", "tls.key_file is required with tls.cert_file")
+	check(cfg.TLS.KeyFile == "" || cfg.TLS.CertFile != "", "tls.cert_file is required with tls.key_file")
+	check(cfg.TLS.ClientCAFile == "" || cfg.TLS.CertFile != "", "tls.client_ca_file requires tls.cert_file and tls.key_file")
+
+	check(len(cfg.Auth.SecretKey) >= MinSecretKeyLength, "auth.secret_key must have at least %d bytes, got %d", MinSecretKeyLength, len(cfg.Auth.SecretKey))
+	check(cfg.Auth.TokenDuration > 0, "auth.token_duration must be positive")
+	check(cfg.Auth.Identity == "jwt" || cfg.Auth.Identity == "header", "auth.identity must be jwt or header, got %q", cfg.Auth.Identity)
+
+	check(cfg.Rating.Aggregation == "mean" || cfg.Rating.Aggregation == "bayesian" || cfg.Rating.Aggregation == "decay", "rating.aggregation must be mean, bayesian or decay, got %q", cfg.Rating.Aggregation)
+	check(cfg.Rating.PriorWeight >= 0, "rating.prior_weight must not be negative")
+	check(cfg.Rating.HalfLife > 0, "rating.half_life must be positive")
+	check(cfg.Rating.UserLimit >= 0, "rating.user_limit must not be negative")
+	check(cfg.Rating.LaptopLimit >= 0, "rating.laptop_limit must not be negative")
+	check(cfg.Rating.UserLimit == 0 && cfg.Rating.LaptopLimit == 0 || cfg.Rating.LimitWindow > 0, "rating.limit_window must be positive when a rating limit is set")
+	check(cfg.Rating.BurstThreshold >= 0, "rating.burst_threshold must not be negative")
+	check(cfg.Rating.BurstThreshold == 0 || cfg.Rating.BurstWindow > 0, "rating.burst_window must be positive when rating.burst_threshold is set")
+
+	_, err := logging.ParseLevel(cfg.Log.Level)
+	check(err == nil, "log.level: %v", err)
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration safe to print
+func (cfg *Config) Redacted() *Config {
+	other := *cfg
+	if other.Auth.SecretKey != "" {
+		other.Auth.SecretKey = "REDACTED"
+	}
+	return &other
+}
+
+// Duration is a time.Duration written as a string such as "1m30s" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string such as \"1m30s\": %w", err)
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
@@ -0,0 +1,144 @@
+package config_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/neepoo/pcbook/config"
+)
+
+const testSecretKey = "0123456789abcdef0123456789abcdef"
+
+// load loads a configuration with testSecretKey, unless env sets another secret key
+func load(args []string, env map[string]string) (*config.Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return config.Load(fs, args, func(key string) (string, bool) {
+		value, ok := env[key]
+		if !ok && key == "PCBOOK_SECRET_KEY" {
+			return testSecretKey, true
+		}
+		return value, ok
+	})
+}
+
+func TestLoadDefault(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := load(nil, nil)
+	require.NoError(t, err)
+	expected := config.Default()
+	expected.Auth.SecretKey = testSecretKey
+	require.Equal(t, expected, cfg)
+}
+
+func TestLoadSecretKey(t *testing.T) {
+	t.Parallel()
+
+	_, err := load(nil, map[string]string{"PCBOOK_SECRET_KEY": ""})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "auth.secret_key must have at least 32 bytes, got 0")
+
+	_, err = load(nil, map[string]string{"PCBOOK_SECRET_KEY": "secret"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "auth.secret_key must have at least 32 bytes, got 6")
+}
+
+func TestLoadPriority(t *testing.T) {
+	t.Parallel()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(configFile, []byte(`{
+		"server": {"port": 8000, "metrics_port": 8001},
+		"auth": {"token_duration": "1h"},
+		"log": {"level": "warn"}
+	}`), 0644)
+	require.NoError(t, err)
+
+	cfg, err := load(
+		[]string{"-config", configFile, "-port", "9000"},
+		map[string]string{"PCBOOK_PORT": "7000", "PCBOOK_LOG_LEVEL": "debug"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 9000, cfg.Server.Port)
+	require.Equal(t, 8001, cfg.Server.MetricsPort)
+	require.Equal(t, config.Duration(time.Hour), cfg.Auth.TokenDuration)
+	require.Equal(t, "debug", cfg.Log.Level)
+	require.Equal(t, "img", cfg.Store.ImageFolder)
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Parallel()
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(configFile, []byte(`{"store": {"image_folder": "images"}}`), 0644)
+	require.NoError(t, err)
+
+	cfg, err := load(nil, map[string]string{"PCBOOK_CONFIG": configFile})
+	require.NoError(t, err)
+	require.Equal(t, "images", cfg.Store.ImageFolder)
+}
+
+func TestLoadExample(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := load([]string{"-config=example.json"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "file", cfg.Store.Backend)
+	require.Equal(t, config.Duration(time.Minute), cfg.Store.FlushInterval)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := load([]string{"-config", "missing.json"}, nil)
+	require.Error(t, err)
+
+	_, err = load(nil, map[string]string{"PCBOOK_TOKEN_DURATION": "forever"})
+	require.EqualError(t, err, `invalid value "forever" for PCBOOK_TOKEN_DURATION: parse error`)
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err = ioutil.WriteFile(configFile, []byte(`{"auth": {"token_duration": 60}}`), 0644)
+	require.NoError(t, err)
+	_, err = load([]string{"-config", configFile}, nil)
+	require.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Default()
+	require.Error(t, cfg.Validate())
+	cfg.Auth.SecretKey = testSecretKey
+	require.NoError(t, cfg.Validate())
+
+	cfg.Store.Backend = "file"
+	cfg.TLS.ClientCAFile = "ca-cert.pem"
+	cfg.Rating.Aggregation = "median"
+	cfg.Rating.HalfLife = 0
+	cfg.Log.Level = "verbose"
+	cfg.Catalog.WeightUnit = "stone"
+	err := cfg.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "store.laptop_file is required by the file store backend")
+	require.Contains(t, err.Error(), "tls.client_ca_file requires tls.cert_file and tls.key_file")
+	require.Contains(t, err.Error(), "rating.aggregation")
+	require.Contains(t, err.Error(), "rating.half_life must be positive")
+	require.Contains(t, err.Error(), "log.level: ")
+	require.Contains(t, err.Error(), `catalog.weight_unit must be kg or lb, got "stone"`)
+}
+
+func TestRedacted(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Default()
+	cfg.Auth.SecretKey = testSecretKey
+	redacted := cfg.Redacted()
+	require.Equal(t, "REDACTED", redacted.Auth.SecretKey)
+	require.Equal(t, testSecretKey, cfg.Auth.SecretKey)
+}
@@ -0,0 +1,638 @@
+// Package gateway serves LaptopService as a REST API with JSON bodies
+package gateway
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/neepoo/pcbook/money"
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/rpcerror"
+	"github.com/neepoo/pcbook/serializer"
+	"github.com/neepoo/pcbook/units"
+)
+
+const (
+	maxBodySize     = 1 << 20
+	imageChunkSize  = 1024
+	defaultPageSize = 50
+	maxPageSize     = 1000
+
+	jsonContentType   = "application/json"
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// Gateway translates HTTP requests to calls of the laptop server methods:
+//
+//	POST   /v1/laptops                     CreateLaptop, the body is a laptop
+//	GET    /v1/laptops                     SearchLaptop, filtered by the query parameters
+//	GET    /v1/laptops/{id}                GetLaptop
+//	PUT    /v1/laptops/{id}                UpdateLaptop, the body is the laptop
+//	DELETE /v1/laptops/{id}                DeleteLaptop
+//	POST   /v1/laptops/{id}/images         UploadImage, the image is the "image" file of a multipart form
+//	POST   /v1/laptops/{id}/ratings        RateLaptop, the body is {"score": 8.5}
+//	GET    /v1/laptops/{id}/price-history  GetPriceHistory
+//
+// Search returns a page of laptops, or all of them as newline delimited JSON
+// if the request accepts application/x-ndjson. Errors are returned as a
+// google.rpc.Status with the HTTP status matching its code, and a Retry-After
+// header if the status has a RetryInfo detail.
+type Gateway struct {
+	server pb.LaptopServiceServer
+	// the interceptors are called around every method, as by the gRPC server.
+	// The incoming metadata of a call are the HTTP request headers.
+	UnaryInterceptors  []grpc.UnaryServerInterceptor
+	StreamInterceptors []grpc.StreamServerInterceptor
+	mux                *http.ServeMux
+}
+
+func NewGateway(server pb.LaptopServiceServer) *Gateway {
+	gateway := &Gateway{
+		server: server,
+		mux:    http.NewServeMux(),
+	}
+	gateway.mux.HandleFunc("/v1/laptops", gateway.handleLaptops)
+	gateway.mux.HandleFunc("/v1/laptops/", gateway.handleLaptop)
+	gateway.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, status.Errorf(codes.NotFound, "no route for %s", r.URL.Path))
+	})
+	return gateway
+}
+
+func (gateway *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gateway.mux.ServeHTTP(w, r)
+}
+
+func (gateway *Gateway) handleLaptops(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		gateway.createLaptop(w, r)
+	case http.MethodGet:
+		gateway.searchLaptop(w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (gateway *Gateway) handleLaptop(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/laptops/"), "/")
+	laptopID := parts[0]
+	if laptopID == "" || len(parts) > 2 {
+		writeError(w, status.Errorf(codes.NotFound, "no route for %s", r.URL.Path))
+		return
+	}
+
+	resource := ""
+	if len(parts) == 2 {
+		resource = parts[1]
+	}
+	switch {
+	case resource == "" && r.Method == http.MethodGet:
+		gateway.getLaptop(w, r, laptopID)
+	case resource == "" && r.Method == http.MethodPut:
+		gateway.updateLaptop(w, r, laptopID)
+	case resource == "" && r.Method == http.MethodDelete:
+		gateway.deleteLaptop(w, r, laptopID)
+	case resource == "images" && r.Method == http.MethodPost:
+		gateway.uploadImage(w, r, laptopID)
+	case resource == "ratings" && r.Method == http.MethodPost:
+		gateway.rateLaptop(w, r, laptopID)
+	case resource == "price-history" && r.Method == http.MethodGet:
+		gateway.getPriceHistory(w, r, laptopID)
+	case resource == "":
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	case resource == "images" || resource == "ratings":
+		writeMethodNotAllowed(w, http.MethodPost)
+	case resource == "price-history":
+		writeMethodNotAllowed(w, http.MethodGet)
+	default:
+		writeError(w, status.Errorf(codes.NotFound, "no route for %s", r.URL.Path))
+	}
+}
+
+func (gateway *Gateway) createLaptop(w http.ResponseWriter, r *http.Request) {
+	laptop := &pb.Laptop{}
+	err := readJSON(w, r, laptop)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx := newCallContext(w, r, "CreateLaptop")
+	res, err := gateway.callUnary(ctx, "CreateLaptop", &pb.CreateLaptopRequest{Laptop: laptop})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, res)
+}
+
+func (gateway *Gateway) getLaptop(w http.ResponseWriter, r *http.Request, laptopID string) {
+	weightUnit, err := parseWeightUnit(r.URL.Query())
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "%v", err))
+		return
+	}
+	ctx := newCallContext(w, r, "GetLaptop")
+	res, err := gateway.callUnary(ctx, "GetLaptop", &pb.GetLaptopRequest{Id: laptopID, WeightUnit: weightUnit})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (gateway *Gateway) getPriceHistory(w http.ResponseWriter, r *http.Request, laptopID string) {
+	ctx := newCallContext(w, r, "GetPriceHistory")
+	res, err := gateway.callUnary(ctx, "GetPriceHistory", &pb.GetPriceHistoryRequest{LaptopId: laptopID})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (gateway *Gateway) updateLaptop(w http.ResponseWriter, r *http.Request, laptopID string) {
+	laptop := &pb.Laptop{}
+	err := readJSON(w, r, laptop)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if laptop.GetId() != "" && laptop.GetId() != laptopID {
+		writeError(w, status.Errorf(codes.InvalidArgument, "laptop id %s doesn't match the URL", laptop.GetId()))
+		return
+	}
+	laptop.Id = laptopID
+
+	ctx := newCallContext(w, r, "UpdateLaptop")
+	res, err := gateway.callUnary(ctx, "UpdateLaptop", &pb.UpdateLaptopRequest{Laptop: laptop})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (gateway *Gateway) deleteLaptop(w http.ResponseWriter, r *http.Request, laptopID string) {
+	ctx := newCallContext(w, r, "DeleteLaptop")
+	res, err := gateway.callUnary(ctx, "DeleteLaptop", &pb.DeleteLaptopRequest{Id: laptopID})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// searchPage is a page of the laptops found by a search, sorted by id.
+// The next page starts after the laptop whose id is NextPageToken.
+type searchPage struct {
+	Laptops       []json.RawMessage `json:"laptops"`
+	NextPageToken string            `json:"next_page_token"`
+}
+
+func (gateway *Gateway) searchLaptop(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := parseFilter(query)
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "invalid filter: %v", err))
+		return
+	}
+	weightUnit, err := parseWeightUnit(query)
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "%v", err))
+		return
+	}
+	req := &pb.SearchLaptopRequest{Filter: filter, WeightUnit: weightUnit}
+	if strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+		gateway.streamSearchLaptop(w, r, req)
+		return
+	}
+
+	pageSize := defaultPageSize
+	if value := query.Get("page_size"); value != "" {
+		pageSize, err = strconv.Atoi(value)
+		if err != nil || pageSize <= 0 || pageSize > maxPageSize {
+			writeError(w, status.Errorf(codes.InvalidArgument, "page_size must be between 1 and %d", maxPageSize))
+			return
+		}
+	}
+	pageToken := query.Get("page_token")
+
+	// one more laptop than the page size tells if there is a next page
+	laptops := &laptopHeap{size: pageSize + 1}
+	stream := &serverStream{
+		ctx:  newCallContext(w, r, "SearchLaptop"),
+		recv: recvOne(req),
+		send: func(m proto.Message) error {
+			laptop := m.(*pb.SearchLaptopResponse).GetLaptop()
+			if laptop.GetId() > pageToken {
+				laptops.add(laptop)
+			}
+			return nil
+		},
+	}
+	err = gateway.callStream("SearchLaptop", stream)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	laptops.sort()
+	page := searchPage{Laptops: []json.RawMessage{}}
+	found := laptops.laptops
+	if len(found) > pageSize {
+		found = found[:pageSize]
+		page.NextPageToken = found[pageSize-1].GetId()
+	}
+	for _, laptop := range found {
+		data, err := marshalJSON(laptop)
+		if err != nil {
+			writeError(w, status.Errorf(codes.Internal, "cannot marshal laptop: %v", err))
+			return
+		}
+		page.Laptops = append(page.Laptops, data)
+	}
+
+	data, err := json.Marshal(page)
+	if err != nil {
+		writeError(w, status.Errorf(codes.Internal, "cannot marshal search page: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(data, '\n'))
+}
+
+// laptopHeap keeps the laptops with the smallest ids among the ones it is given,
+// at most size of them, in a max-heap by id
+type laptopHeap struct {
+	size    int
+	laptops []*pb.Laptop
+}
+
+func (h *laptopHeap) Len() int           { return len(h.laptops) }
+func (h *laptopHeap) Less(i, j int) bool { return h.laptops[i].GetId() > h.laptops[j].GetId() }
+func (h *laptopHeap) Swap(i, j int)      { h.laptops[i], h.laptops[j] = h.laptops[j], h.laptops[i] }
+func (h *laptopHeap) Push(x interface{}) { h.laptops = append(h.laptops, x.(*pb.Laptop)) }
+
+func (h *laptopHeap) Pop() interface{} {
+	last := h.laptops[len(h.laptops)-1]
+	h.laptops = h.laptops[:len(h.laptops)-1]
+	return last
+}
+
+// add keeps the laptop if it is among the size smallest ids so far
+func (h *laptopHeap) add(laptop *pb.Laptop) {
+	if len(h.laptops) < h.size {
+		heap.Push(h, laptop)
+		return
+	}
+	if laptop.GetId() < h.laptops[0].GetId() {
+		h.laptops[0] = laptop
+		heap.Fix(h, 0)
+	}
+}
+
+// sort orders the laptops by increasing id, they are no longer a heap
+func (h *laptopHeap) sort() {
+	sort.Slice(h.laptops, func(i, j int) bool {
+		return h.laptops[i].GetId() < h.laptops[j].GetId()
+	})
+}
+
+// streamSearchLaptop writes every search response on its own line as it is found.
+// An error after the first line is written as a last line {"error": status}.
+func (gateway *Gateway) streamSearchLaptop(w http.ResponseWriter, r *http.Request, req *pb.SearchLaptopRequest) {
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", ndjsonContentType)
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	stream := &serverStream{
+		ctx:  newCallContext(w, r, "SearchLaptop"),
+		recv: recvOne(req),
+		send: func(m proto.Message) error {
+			data, err := marshalJSON(m)
+			if err != nil {
+				return status.Errorf(codes.Internal, "cannot marshal search response: %v", err)
+			}
+			start()
+			_, err = w.Write(append(data, '\n'))
+			if err != nil {
+				return err
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			return nil
+		},
+	}
+	err := gateway.callStream("SearchLaptop", stream)
+	if err != nil && !started {
+		writeError(w, err)
+		return
+	}
+	start()
+	if err != nil {
+		data, _ := marshalJSON(status.Convert(err).Proto())
+		fmt.Fprintf(w, "{\"error\":%s}\n", data)
+	}
+}
+
+func (gateway *Gateway) uploadImage(w http.ResponseWriter, r *http.Request, laptopID string) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "request must be a multipart form: %v", err))
+		return
+	}
+	var image io.Reader
+	imageType := ""
+	for image == nil {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			writeError(w, status.Errorf(codes.InvalidArgument, "form has no image file"))
+			return
+		}
+		if err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "cannot read multipart form: %v", err))
+			return
+		}
+		if part.FormName() == "image" {
+			image = part
+			imageType = filepath.Ext(part.FileName())
+		}
+	}
+
+	infoSent := false
+	buffer := make([]byte, imageChunkSize)
+	var res proto.Message
+	stream := &serverStream{
+		ctx: newCallContext(w, r, "UploadImage"),
+		recv: func() (proto.Message, error) {
+			if !infoSent {
+				infoSent = true
+				return &pb.UploadImageRequest{
+					Data: &pb.UploadImageRequest_Info{
+						Info: &pb.ImageInfo{LaptopId: laptopID, ImageType: imageType},
+					},
+				}, nil
+			}
+			for {
+				n, err := image.Read(buffer)
+				if n > 0 {
+					chunk := make([]byte, n)
+					copy(chunk, buffer[:n])
+					return &pb.UploadImageRequest{
+						Data: &pb.UploadImageRequest_ChunkData{ChunkData: chunk},
+					}, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		},
+		send: func(m proto.Message) error {
+			res = m
+			return nil
+		},
+	}
+	err = gateway.callStream("UploadImage", stream)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, res)
+}
+
+func (gateway *Gateway) rateLaptop(w http.ResponseWriter, r *http.Request, laptopID string) {
+	req := &pb.RateLaptopRequest{}
+	err := readJSON(w, r, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req.LaptopId = laptopID
+
+	var res proto.Message
+	stream := &serverStream{
+		ctx:  newCallContext(w, r, "RateLaptop"),
+		recv: recvOne(req),
+		send: func(m proto.Message) error {
+			res = m
+			return nil
+		},
+	}
+	err = gateway.callStream("RateLaptop", stream)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// parseFilter reads a search filter from the max_price_usd, min_cpu_cores,
+// min_cpu_ghz, min_ram_value and min_ram_unit query parameters.
+// The min ram can also be a single min_ram parameter like "16GB",
+// the min_weight and max_weight parameters are like "1.5kg" or "4lb",
+// max_price is like "1200EUR" and cannot be given with max_price_usd, price_dropped_within is a duration
+// like "720h" and lowest_price_ever a boolean.
+// Without max_price_usd nor max_price, the price is not bounded.
+func parseFilter(query url.Values) (*pb.Filter, error) {
+	filter := &pb.Filter{}
+	var err error
+	if value := query.Get("max_price_usd"); value != "" {
+		filter.MaxPriceUsd, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("max_price_usd: %w", err)
+		}
+	} else if query.Get("max_price") == "" {
+		filter.MaxPriceUsd = math.Inf(1)
+	}
+	if value := query.Get("min_cpu_cores"); value != "" {
+		cores, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("min_cpu_cores: %w", err)
+		}
+		filter.MinCpuCores = uint32(cores)
+	}
+	if value := query.Get("min_cpu_ghz"); value != "" {
+		filter.MinCpuGhz, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("min_cpu_ghz: %w", err)
+		}
+	}
+	if value := query.Get("min_ram_value"); value != "" {
+		ram, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("min_ram_value: %w", err)
+		}
+		unit, ok := pb.Memory_Unit_value[strings.ToUpper(query.Get("min_ram_unit"))]
+		if !ok || unit == int32(pb.Memory_UNKNOWN) {
+			return nil, fmt.Errorf("min_ram_unit must be one of BIT, BYTE, KILOBYTE, MEGABYTE, GIGABYTE or TERABYTE")
+		}
+		filter.MinRam = &pb.Memory{Value: ram, Unit: pb.Memory_Unit(unit)}
+	}
+	if value := query.Get("min_ram"); value != "" {
+		filter.MinRam, err = units.ParseMemory(value)
+		if err != nil {
+			return nil, fmt.Errorf("min_ram: %w", err)
+		}
+	}
+	if value := query.Get("min_weight"); value != "" {
+		filter.MinWeight, err = units.ParseWeight(value)
+		if err != nil {
+			return nil, fmt.Errorf("min_weight: %w", err)
+		}
+	}
+	if value := query.Get("max_weight"); value != "" {
+		filter.MaxWeight, err = units.ParseWeight(value)
+		if err != nil {
+			return nil, fmt.Errorf("max_weight: %w", err)
+		}
+	}
+	if value := query.Get("max_price"); value != "" {
+		filter.MaxPrice, err = money.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("max_price: %w", err)
+		}
+	}
+	if value := query.Get("price_dropped_within"); value != "" {
+		within, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("price_dropped_within: %w", err)
+		}
+		filter.PriceDroppedWithin = durationpb.New(within)
+	}
+	if value := query.Get("lowest_price_ever"); value != "" {
+		filter.LowestPriceEver, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("lowest_price_ever: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+// parseWeightUnit reads the unit the weights are sent in from the weight_unit query parameter, like "kg" or "lb"
+func parseWeightUnit(query url.Values) (pb.Weight_Unit, error) {
+	value := query.Get("weight_unit")
+	if value == "" {
+		return pb.Weight_UNKNOWN, nil
+	}
+	return units.ParseWeightUnit(value)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, message proto.Message) error {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot read request body: %v", err)
+	}
+	err = serializer.JSONToProtobuf(data, message)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot parse request body: %v", err)
+	}
+	return nil
+}
+
+// marshalJSON converts a message to JSON as serializer.ProtobufToJSON, on a single line
+func marshalJSON(message proto.Message) ([]byte, error) {
+	data, err := serializer.ProtobufToJSON(message)
+	if err != nil {
+		return nil, err
+	}
+	compacted := &bytes.Buffer{}
+	err = json.Compact(compacted, data)
+	if err != nil {
+		return nil, err
+	}
+	return compacted.Bytes(), nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, message proto.Message) {
+	data, err := marshalJSON(message)
+	if err != nil {
+		writeError(w, status.Errorf(codes.Internal, "cannot marshal response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(code)
+	w.Write(append(data, '\n'))
+}
+
+// writeError writes the status of err with the HTTP status matching its code
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	data, err := marshalJSON(st.Proto())
+	if err != nil {
+		http.Error(w, st.Message(), HTTPStatusFromCode(st.Code()))
+		return
+	}
+	if delay, ok := rpcerror.RetryDelay(st.Err()); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(HTTPStatusFromCode(st.Code()))
+	w.Write(append(data, '\n'))
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	st := status.New(codes.Unimplemented, "method not allowed")
+	data, _ := marshalJSON(st.Proto())
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	w.Write(append(data, '\n'))
+}
+
+// HTTPStatusFromCode returns the HTTP status matching a gRPC status code
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// the status nginx uses for a request closed by the client
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,331 @@
+package gateway_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/neepoo/pcbook/gateway"
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/serializer"
+	"github.com/neepoo/pcbook/service"
+)
+
+func startTestGateway(t *testing.T, laptopServer *service.LaptopServer) (*httptest.Server, *gateway.Gateway) {
+	restGateway := gateway.NewGateway(laptopServer)
+	server := httptest.NewServer(restGateway)
+	t.Cleanup(server.Close)
+	return server, restGateway
+}
+
+func newTestLaptopServer(t *testing.T) (*service.LaptopServer, service.LaptopStore) {
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore := service.NewDiskImageStore(t.TempDir())
+	ratingStore := service.NewInMemoryRatingScore(0)
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+	laptopServer.PriceHistoryStore = service.NewInMemoryPriceHistoryStore()
+	return laptopServer, laptopStore
+}
+
+func requireStatus(t *testing.T, res *http.Response, code codes.Code, httpStatus int) {
+	defer res.Body.Close()
+	require.Equal(t, httpStatus, res.StatusCode)
+	data, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	var body struct {
+		Code    codes.Code `json:"code"`
+		Message string     `json:"message"`
+	}
+	require.NoError(t, json.Unmarshal(data, &body))
+	require.Equal(t, code, body.Code)
+	require.NotEmpty(t, body.Message)
+}
+
+func TestGatewayCreateAndGetLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, laptopStore := newTestLaptopServer(t)
+	server, _ := startTestGateway(t, laptopServer)
+
+	laptop := sample.NewLaptop()
+	data, err := serializer.ProtobufToJSON(laptop)
+	require.NoError(t, err)
+	res, err := http.Post(server.URL+"/v1/laptops", "application/json", bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	created := &pb.CreateLaptopResponse{}
+	readBody(t, res, created)
+	require.Equal(t, laptop.GetId(), created.GetId())
+
+	saved, err := laptopStore.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.NotNil(t, saved)
+
+	res, err = http.Get(server.URL + "/v1/laptops/" + laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	got := &pb.GetLaptopResponse{}
+	readBody(t, res, got)
+	require.Equal(t, laptop.GetId(), got.GetLaptop().GetId())
+	require.Equal(t, laptop.GetPriceUsd(), got.GetLaptop().GetPriceUsd())
+
+	res, err = http.Get(server.URL + "/v1/laptops/" + laptop.GetId() + "/price-history")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	history := &pb.GetPriceHistoryResponse{}
+	readBody(t, res, history)
+	require.Len(t, history.GetPrices(), 1)
+	require.Equal(t, laptop.GetPriceUsd(), history.GetPrices()[0].GetPriceUsd())
+
+	res, err = http.Post(server.URL+"/v1/laptops", "application/json", bytes.NewReader(data))
+	require.NoError(t, err)
+	requireStatus(t, res, codes.AlreadyExists, http.StatusConflict)
+
+	res, err = http.Get(server.URL + "/v1/laptops/unknown")
+	require.NoError(t, err)
+	requireStatus(t, res, codes.NotFound, http.StatusNotFound)
+
+	res, err = http.Post(server.URL+"/v1/laptops", "application/json", strings.NewReader("{"))
+	require.NoError(t, err)
+	requireStatus(t, res, codes.InvalidArgument, http.StatusBadRequest)
+
+	req, err := http.NewRequest(http.MethodDelete, server.URL+"/v1/laptops", nil)
+	require.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, "GET, POST", res.Header.Get("Allow"))
+	requireStatus(t, res, codes.Unimplemented, http.StatusMethodNotAllowed)
+}
+
+func TestGatewaySearchLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, laptopStore := newTestLaptopServer(t)
+	server, _ := startTestGateway(t, laptopServer)
+
+	for i := 0; i < 5; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = 1000
+		if i == 0 {
+			laptop.PriceUsd = 5000
+		}
+		require.NoError(t, laptopStore.Save(laptop))
+	}
+
+	searchPages := func(query string, pageSize int) []string {
+		var ids []string
+		pageToken := ""
+		for page := 0; page < 10; page++ {
+			res, err := http.Get(fmt.Sprintf("%s/v1/laptops?%s&page_size=%d&page_token=%s", server.URL, query, pageSize, pageToken))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			var body struct {
+				Laptops       []json.RawMessage `json:"laptops"`
+				NextPageToken string            `json:"next_page_token"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			res.Body.Close()
+			require.LessOrEqual(t, len(body.Laptops), pageSize)
+			for _, data := range body.Laptops {
+				laptop := &pb.Laptop{}
+				require.NoError(t, serializer.JSONToProtobuf(data, laptop))
+				ids = append(ids, laptop.GetId())
+			}
+			pageToken = body.NextPageToken
+			if pageToken == "" {
+				break
+			}
+		}
+		return ids
+	}
+
+	ids := searchPages("max_price_usd=2000", 3)
+	require.Len(t, ids, 4)
+	require.IsIncreasing(t, ids)
+
+	// the pages are smaller than the laptops found
+	all := searchPages("", 1)
+	require.Len(t, all, 5)
+	require.IsIncreasing(t, all)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/laptops?max_price_usd=2000", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/x-ndjson")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+	scanner := bufio.NewScanner(res.Body)
+	lines := 0
+	for scanner.Scan() {
+		found := &pb.SearchLaptopResponse{}
+		require.NoError(t, serializer.JSONToProtobuf(scanner.Bytes(), found))
+		require.Contains(t, ids, found.GetLaptop().GetId())
+		lines++
+	}
+	require.Equal(t, 4, lines)
+
+	// without query parameters, the laptops of any price are found
+	res, err = http.Get(server.URL + "/v1/laptops")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var body struct {
+		Laptops []json.RawMessage `json:"laptops"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	res.Body.Close()
+	require.Len(t, body.Laptops, 5)
+
+	res, err = http.Get(server.URL + "/v1/laptops?min_ram_value=8&min_ram_unit=parsec")
+	require.NoError(t, err)
+	requireStatus(t, res, codes.InvalidArgument, http.StatusBadRequest)
+
+	res, err = http.Get(server.URL + "/v1/laptops?min_ram=8parsec")
+	require.NoError(t, err)
+	requireStatus(t, res, codes.InvalidArgument, http.StatusBadRequest)
+}
+
+func TestGatewayUploadImage(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, laptopStore := newTestLaptopServer(t)
+	laptopServer.MaxImageSize = 4096
+	server, _ := startTestGateway(t, laptopServer)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	image := bytes.Repeat([]byte{0xff}, 3000)
+	res := postImage(t, server.URL+"/v1/laptops/"+laptop.GetId()+"/images", image)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	uploaded := &pb.UploadImageResponse{}
+	readBody(t, res, uploaded)
+	require.NotEmpty(t, uploaded.GetId())
+	require.EqualValues(t, len(image), uploaded.GetSize())
+
+	res = postImage(t, server.URL+"/v1/laptops/"+laptop.GetId()+"/images", bytes.Repeat([]byte{0xff}, 5000))
+	requireStatus(t, res, codes.InvalidArgument, http.StatusBadRequest)
+
+	res = postImage(t, server.URL+"/v1/laptops/unknown/images", image)
+	requireStatus(t, res, codes.InvalidArgument, http.StatusBadRequest)
+}
+
+func TestGatewayRateLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, laptopStore := newTestLaptopServer(t)
+	server, _ := startTestGateway(t, laptopServer)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	for i, score := range []float64{8, 6} {
+		body := fmt.Sprintf(`{"score": %v}`, score)
+		res, err := http.Post(server.URL+"/v1/laptops/"+laptop.GetId()+"/ratings", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		rated := &pb.RateLaptopResponse{}
+		readBody(t, res, rated)
+		require.Equal(t, laptop.GetId(), rated.GetLaptopId())
+		require.EqualValues(t, i+1, rated.GetRatedCount())
+	}
+}
+
+func TestGatewayInterceptors(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, laptopStore := newTestLaptopServer(t)
+	server, restGateway := startTestGateway(t, laptopServer)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	var methods []string
+	restGateway.UnaryInterceptors = []grpc.UnaryServerInterceptor{
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			methods = append(methods, info.FullMethod)
+			md, _ := metadata.FromIncomingContext(ctx)
+			if len(md["authorization"]) == 0 {
+				return nil, status.Errorf(codes.Unauthenticated, "authorization token is not provided")
+			}
+			grpc.SetHeader(ctx, metadata.Pairs("x-request-id", "42"))
+			return handler(ctx, req)
+		},
+	}
+	restGateway.StreamInterceptors = []grpc.StreamServerInterceptor{
+		func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			methods = append(methods, info.FullMethod)
+			return status.Errorf(codes.PermissionDenied, "no permission to access this RPC")
+		},
+	}
+
+	res, err := http.Get(server.URL + "/v1/laptops/" + laptop.GetId())
+	require.NoError(t, err)
+	requireStatus(t, res, codes.Unauthenticated, http.StatusUnauthorized)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/laptops/"+laptop.GetId(), nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "token")
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "42", res.Header.Get("X-Request-Id"))
+
+	res, err = http.Get(server.URL + "/v1/laptops")
+	require.NoError(t, err)
+	requireStatus(t, res, codes.PermissionDenied, http.StatusForbidden)
+
+	require.Equal(t, []string{
+		"/pcbook.LaptopService/GetLaptop",
+		"/pcbook.LaptopService/GetLaptop",
+		"/pcbook.LaptopService/SearchLaptop",
+	}, methods)
+}
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, http.StatusOK, gateway.HTTPStatusFromCode(codes.OK))
+	require.Equal(t, http.StatusTooManyRequests, gateway.HTTPStatusFromCode(codes.ResourceExhausted))
+	require.Equal(t, http.StatusServiceUnavailable, gateway.HTTPStatusFromCode(codes.Unavailable))
+	require.Equal(t, http.StatusInternalServerError, gateway.HTTPStatusFromCode(codes.DataLoss))
+}
+
+func postImage(t *testing.T, url string, image []byte) *http.Response {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("image", "laptop.jpg")
+	require.NoError(t, err)
+	_, err = part.Write(image)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	res, err := http.Post(url, writer.FormDataContentType(), body)
+	require.NoError(t, err)
+	return res
+}
+
+func readBody(t *testing.T, res *http.Response, message proto.Message) {
+	defer res.Body.Close()
+	require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	data, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, serializer.JSONToProtobuf(data, message))
+}
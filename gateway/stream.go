@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/neepoo/pcbook/pb"
+)
+
+const laptopServicePath = "/pcbook.LaptopService/"
+
+// callUnary calls a unary method of the laptop server through the unary interceptors
+func (gateway *Gateway) callUnary(ctx context.Context, method string, req proto.Message) (proto.Message, error) {
+	for _, desc := range pb.LaptopService_ServiceDesc.Methods {
+		if desc.MethodName != method {
+			continue
+		}
+		dec := func(m interface{}) error {
+			proto.Merge(m.(proto.Message), req)
+			return nil
+		}
+		res, err := desc.Handler(gateway.server, ctx, dec, chainUnaryInterceptors(gateway.UnaryInterceptors))
+		if err != nil {
+			return nil, err
+		}
+		return res.(proto.Message), nil
+	}
+	return nil, fmt.Errorf("unknown method %s", method)
+}
+
+// callStream calls a streaming method of the laptop server through the stream interceptors
+func (gateway *Gateway) callStream(method string, stream *serverStream) error {
+	for _, desc := range pb.LaptopService_ServiceDesc.Streams {
+		if desc.StreamName != method {
+			continue
+		}
+		interceptor := chainStreamInterceptors(gateway.StreamInterceptors)
+		if interceptor == nil {
+			return desc.Handler(gateway.server, stream)
+		}
+		info := &grpc.StreamServerInfo{
+			FullMethod:     laptopServicePath + method,
+			IsClientStream: desc.ClientStreams,
+			IsServerStream: desc.ServerStreams,
+		}
+		return interceptor(gateway.server, stream, info, desc.Handler)
+	}
+	return fmt.Errorf("unknown method %s", method)
+}
+
+func chainUnaryInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+func chainStreamInterceptors(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(srv interface{}, stream grpc.ServerStream) error {
+				return interceptor(srv, stream, info, next)
+			}
+		}
+		return chained(srv, stream)
+	}
+}
+
+// newCallContext returns the context of a call made for an HTTP request.
+// The request headers become the incoming metadata, and the headers
+// set by the call through grpc.SetHeader are written to w.
+func newCallContext(w http.ResponseWriter, r *http.Request, method string) context.Context {
+	md := metadata.MD{}
+	for key, values := range r.Header {
+		md.Append(strings.ToLower(key), values...)
+	}
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: httpAddr(r.RemoteAddr)})
+	return grpc.NewContextWithServerTransportStream(ctx, &transportStream{
+		method: laptopServicePath + method,
+		header: w.Header(),
+	})
+}
+
+// httpAddr is the address of an HTTP client
+type httpAddr string
+
+func (addr httpAddr) Network() string { return "tcp" }
+func (addr httpAddr) String() string  { return string(addr) }
+
+// transportStream writes the metadata set by a call to the HTTP response headers
+type transportStream struct {
+	method string
+	header http.Header
+}
+
+func (stream *transportStream) Method() string {
+	return stream.method
+}
+
+func (stream *transportStream) SetHeader(md metadata.MD) error {
+	for key, values := range md {
+		if strings.HasSuffix(key, "-bin") {
+			continue
+		}
+		for _, value := range values {
+			stream.header.Add(key, value)
+		}
+	}
+	return nil
+}
+
+func (stream *transportStream) SendHeader(md metadata.MD) error {
+	return stream.SetHeader(md)
+}
+
+func (stream *transportStream) SetTrailer(md metadata.MD) error {
+	return nil
+}
+
+// serverStream feeds the messages returned by recv to a streaming method,
+// and passes the messages the method sends to send
+type serverStream struct {
+	ctx  context.Context
+	recv func() (proto.Message, error)
+	send func(proto.Message) error
+}
+
+func (stream *serverStream) SetHeader(md metadata.MD) error {
+	return grpc.SetHeader(stream.ctx, md)
+}
+
+func (stream *serverStream) SendHeader(md metadata.MD) error {
+	return grpc.SendHeader(stream.ctx, md)
+}
+
+func (stream *serverStream) SetTrailer(md metadata.MD) {
+}
+
+func (stream *serverStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *serverStream) SendMsg(m interface{}) error {
+	return stream.send(m.(proto.Message))
+}
+
+func (stream *serverStream) RecvMsg(m interface{}) error {
+	if stream.recv == nil {
+		return io.EOF
+	}
+	req, err := stream.recv()
+	if err != nil {
+		return err
+	}
+	proto.Merge(m.(proto.Message), req)
+	return nil
+}
+
+// recvOne returns a recv function returning req once
+func recvOne(req proto.Message) func() (proto.Message, error) {
+	done := false
+	return func() (proto.Message, error) {
+		if done {
+			return nil, io.EOF
+		}
+		done = true
+		return req, nil
+	}
+}
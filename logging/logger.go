@@ -0,0 +1,218 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (level Level) String() string {
+	switch level {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// ParseLevel returns the level with the given name
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level: %q", name)
+	}
+}
+
+// Logger writes structured log lines made of a message and key/value fields,
+// either as logfmt text or as one JSON object per line
+type Logger struct {
+	out    *output
+	level  Level
+	json   bool
+	fields []interface{}
+}
+
+// output serializes the writes of the loggers sharing a writer
+type output struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func New(out io.Writer, level Level, json bool) *Logger {
+	return &Logger{
+		out:   &output{writer: out},
+		level: level,
+		json:  json,
+	}
+}
+
+// With returns a logger adding the key/value pairs to every line
+func (logger *Logger) With(keyvals ...interface{}) *Logger {
+	other := *logger
+	other.fields = append(append([]interface{}(nil), logger.fields...), keyvals...)
+	return &other
+}
+
+func (logger *Logger) Debug(msg string, keyvals ...interface{}) {
+	logger.log(LevelDebug, msg, keyvals)
+}
+
+func (logger *Logger) Info(msg string, keyvals ...interface{}) {
+	logger.log(LevelInfo, msg, keyvals)
+}
+
+func (logger *Logger) Warn(msg string, keyvals ...interface{}) {
+	logger.log(LevelWarn, msg, keyvals)
+}
+
+func (logger *Logger) Error(msg string, keyvals ...interface{}) {
+	logger.log(LevelError, msg, keyvals)
+}
+
+// Enabled reports whether lines of the level are written
+func (logger *Logger) Enabled(level Level) bool {
+	return level >= logger.level
+}
+
+func (logger *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !logger.Enabled(level) {
+		return
+	}
+
+	fields := append([]interface{}{
+		"time", time.Now().Format(time.RFC3339Nano),
+		"level", level.String(),
+		"msg", msg,
+	}, logger.fields...)
+	fields = append(fields, keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(MISSING)")
+	}
+
+	var buffer bytes.Buffer
+	if logger.json {
+		writeJSON(&buffer, fields)
+	} else {
+		writeText(&buffer, fields)
+	}
+
+	logger.out.mutex.Lock()
+	defer logger.out.mutex.Unlock()
+	logger.out.writer.Write(buffer.Bytes())
+}
+
+func writeText(buffer *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buffer.WriteByte(' ')
+		}
+		buffer.WriteString(fmt.Sprint(fields[i]))
+		buffer.WriteByte('=')
+		value := formatValue(fields[i+1])
+		if value == "" || strings.ContainsAny(value, " =\"\t\n") {
+			value = strconv.Quote(value)
+		}
+		buffer.WriteString(value)
+	}
+	buffer.WriteByte('\n')
+}
+
+func writeJSON(buffer *bytes.Buffer, fields []interface{}) {
+	buffer.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buffer.Write(key)
+		buffer.WriteByte(':')
+
+		value := fields[i+1]
+		switch value.(type) {
+		case error, fmt.Stringer, time.Duration:
+			value = formatValue(value)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprint(value))
+		}
+		buffer.Write(data)
+	}
+	buffer.WriteString("}\n")
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+var (
+	defaultMutex  sync.RWMutex
+	defaultLogger = New(os.Stderr, LevelInfo, false)
+)
+
+// Default returns the logger used when a context carries none
+func Default() *Logger {
+	defaultMutex.RLock()
+	defer defaultMutex.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the default logger
+func SetDefault(logger *Logger) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultLogger = logger
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return logger
+	}
+	return Default()
+}
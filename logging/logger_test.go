@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggerText(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	logger := New(&out, LevelInfo, false).With("method", "/pcbook.LaptopService/CreateLaptop")
+
+	logger.Debug("hidden")
+	logger.Info("saved laptop", "laptop_id", "abc", "err", errors.New("not found"), "latency", time.Second)
+
+	line := out.String()
+	require.True(t, strings.HasSuffix(line, "\n"))
+	require.NotContains(t, line, "hidden")
+	require.Contains(t, line, `level=info msg="saved laptop" method=/pcbook.LaptopService/CreateLaptop laptop_id=abc err="not found" latency=1s`)
+}
+
+func TestLoggerJSON(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	logger := New(&out, LevelDebug, true)
+	ctx := NewContext(context.Background(), logger.With("request_id", "42"))
+
+	FromContext(ctx).Warn("too many ratings", "count", 3, "odd")
+
+	fields := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &fields))
+	require.Equal(t, "warn", fields["level"])
+	require.Equal(t, "too many ratings", fields["msg"])
+	require.Equal(t, "42", fields["request_id"])
+	require.Equal(t, float64(3), fields["count"])
+	require.Equal(t, "(MISSING)", fields["odd"])
+}
+
+func TestParseLevel(t *testing.T) {
+	t.Parallel()
+
+	level, err := ParseLevel("WARN")
+	require.NoError(t, err)
+	require.Equal(t, LevelWarn, level)
+
+	_, err = ParseLevel("verbose")
+	require.Error(t, err)
+}
@@ -0,0 +1,317 @@
+// Package metrics keeps counters, gauges and histograms and writes them
+// in the Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector is a metric family that can be written by a registry
+type Collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds the collectors exposed on the metrics endpoint
+type Registry struct {
+	mutex      sync.RWMutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// MustRegister adds collectors to the registry
+func (registry *Registry) MustRegister(collectors ...Collector) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.collectors = append(registry.collectors, collectors...)
+}
+
+// WriteText writes all the metrics in the Prometheus text format
+func (registry *Registry) WriteText(w io.Writer) error {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	writer := bufio.NewWriter(w)
+	for _, collector := range registry.collectors {
+		collector.write(writer)
+	}
+	return writer.Flush()
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registry.WriteText(w)
+	})
+}
+
+// family is the name, help and labels shared by the series of a metric
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+func (f *family) key(labelValues []string) string {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// series is a value of a family with its label values
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// valueVec is a family of series holding a single value, a counter or a gauge
+type valueVec struct {
+	family
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+func newValueVec(kind, name, help string, labelNames []string) *valueVec {
+	return &valueVec{
+		family: family{name: name, help: help, kind: kind, labelNames: labelNames},
+		series: map[string]*series{},
+	}
+}
+
+func (vec *valueVec) update(labelValues []string, update func(value float64) float64) {
+	key := vec.key(labelValues)
+
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	s := vec.series[key]
+	if s == nil {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		vec.series[key] = s
+	}
+	s.value = update(s.value)
+}
+
+func (vec *valueVec) write(w *bufio.Writer) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	keys := make([]string, 0, len(vec.series))
+	for key := range vec.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	vec.writeHeader(w)
+	for _, key := range keys {
+		s := vec.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", vec.name, formatLabels(vec.labelNames, s.labelValues, "", ""), formatFloat(s.value))
+	}
+}
+
+// CounterVec is a family of values which only go up
+type CounterVec struct {
+	*valueVec
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{newValueVec("counter", name, help, labelNames)}
+}
+
+func (vec *CounterVec) Inc(labelValues ...string) {
+	vec.Add(1, labelValues...)
+}
+
+// Add increases the counter, it panics if delta is negative
+func (vec *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", vec.name))
+	}
+	vec.update(labelValues, func(value float64) float64 { return value + delta })
+}
+
+// GaugeVec is a family of values which go up and down
+type GaugeVec struct {
+	*valueVec
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{newValueVec("gauge", name, help, labelNames)}
+}
+
+func (vec *GaugeVec) Set(v float64, labelValues ...string) {
+	vec.update(labelValues, func(float64) float64 { return v })
+}
+
+func (vec *GaugeVec) Add(delta float64, labelValues ...string) {
+	vec.update(labelValues, func(value float64) float64 { return value + delta })
+}
+
+func (vec *GaugeVec) Inc(labelValues ...string) {
+	vec.Add(1, labelValues...)
+}
+
+func (vec *GaugeVec) Dec(labelValues ...string) {
+	vec.Add(-1, labelValues...)
+}
+
+// GaugeFunc is a gauge whose value is read when the metrics are written
+type GaugeFunc struct {
+	family
+	value func() float64
+}
+
+func NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	return &GaugeFunc{
+		family: family{name: name, help: help, kind: "gauge"},
+		value:  value,
+	}
+}
+
+func (gauge *GaugeFunc) write(w *bufio.Writer) {
+	gauge.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", gauge.name, formatFloat(gauge.value()))
+}
+
+// DefaultBuckets are the upper bounds of latency histograms in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramVec is a family of distributions of observed values
+type HistogramVec struct {
+	family
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{
+		family:  family{name: name, help: help, kind: "histogram", labelNames: labelNames},
+		buckets: buckets,
+		series:  map[string]*histogram{},
+	}
+}
+
+func (vec *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := vec.key(labelValues)
+
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	h := vec.series[key]
+	if h == nil {
+		h = &histogram{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(vec.buckets)),
+		}
+		vec.series[key] = h
+	}
+	for i, upperBound := range vec.buckets {
+		if v <= upperBound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (vec *HistogramVec) write(w *bufio.Writer) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	keys := make([]string, 0, len(vec.series))
+	for key := range vec.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	vec.writeHeader(w)
+	for _, key := range keys {
+		h := vec.series[key]
+		for i, upperBound := range vec.buckets {
+			labels := formatLabels(vec.labelNames, h.labelValues, "le", formatFloat(upperBound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", vec.name, labels, h.counts[i])
+		}
+		labels := formatLabels(vec.labelNames, h.labelValues, "le", "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", vec.name, labels, h.count)
+
+		labels = formatLabels(vec.labelNames, h.labelValues, "", "")
+		fmt.Fprintf(w, "%s_sum%s %s\n", vec.name, labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", vec.name, labels, h.count)
+	}
+}
+
+// formatLabels returns the label set of a series, with an extra label if extraName is not empty
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabelValue(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	t.Parallel()
+
+	counter := NewCounterVec("requests_total", "Number of requests.", "method", "code")
+	counter.Inc("/a", "OK")
+	counter.Add(2, "/a", "OK")
+	counter.Inc("/b\"", "NotFound")
+
+	gauge := NewGaugeVec("in_flight", "Streams in flight.", "method")
+	gauge.Inc("/a")
+	gauge.Inc("/a")
+	gauge.Dec("/a")
+
+	histogram := NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "method")
+	histogram.Observe(0.05, "/a")
+	histogram.Observe(0.5, "/a")
+	histogram.Observe(5, "/a")
+
+	laptops := NewGaugeFunc("laptops", "Number of laptops.", func() float64 { return 42 })
+
+	registry := NewRegistry()
+	registry.MustRegister(counter, gauge, histogram, laptops)
+
+	var out bytes.Buffer
+	require.NoError(t, registry.WriteText(&out))
+	require.Equal(t, `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{method="/a",code="OK"} 3
+requests_total{method="/b\"",code="NotFound"} 1
+# HELP in_flight Streams in flight.
+# TYPE in_flight gauge
+in_flight{method="/a"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="/a",le="0.1"} 1
+latency_seconds_bucket{method="/a",le="1"} 2
+latency_seconds_bucket{method="/a",le="+Inf"} 3
+latency_seconds_sum{method="/a"} 5.55
+latency_seconds_count{method="/a"} 3
+# HELP laptops Number of laptops.
+# TYPE laptops gauge
+laptops 42
+`, out.String())
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, recorder.Code)
+	require.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+	require.Equal(t, out.String(), recorder.Body.String())
+}
+
+func TestCounterCannotDecrease(t *testing.T) {
+	t.Parallel()
+
+	counter := NewCounterVec("requests_total", "Number of requests.")
+	require.Panics(t, func() { counter.Add(-1) })
+	require.Panics(t, func() { counter.Inc("unexpected") })
+}
@@ -0,0 +1,108 @@
+// Package money converts, compares and formats the prices of the catalog
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/neepoo/pcbook/pb"
+)
+
+// USD is the currency of the price_usd of the laptops, which the other prices are derived from
+const USD = "USD"
+
+var (
+	// ErrUnsupportedCurrency is returned for an amount in a currency the catalog doesn't sell in
+	ErrUnsupportedCurrency = errors.New("currency is not supported")
+	// ErrCurrencyMismatch is returned when amounts in different currencies are compared
+	ErrCurrencyMismatch = errors.New("currencies differ")
+)
+
+// minorDigits are the number of digits of the minor unit of the supported currencies
+var minorDigits = map[string]int{
+	USD:   2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+}
+
+// Currencies returns the codes of the supported currencies, sorted
+func Currencies() []string {
+	codes := make([]string, 0, len(minorDigits))
+	for code := range minorDigits {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// IsSupported reports whether the catalog sells in the currency of code
+func IsSupported(code string) bool {
+	_, ok := minorDigits[code]
+	return ok
+}
+
+// New returns an amount of major units of a currency, like 12.5 EUR, rounded to the minor unit
+func New(currency string, amount float64) (*pb.Money, error) {
+	digits, ok := minorDigits[currency]
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", currency, ErrUnsupportedCurrency)
+	}
+	minorUnits := math.Round(amount * math.Pow10(digits))
+	// float64(math.MaxInt64) rounds up to 2^63, which is out of range, while -2^63 is in range
+	if math.IsNaN(minorUnits) || minorUnits >= math.MaxInt64 || minorUnits < math.MinInt64 {
+		return nil, fmt.Errorf("amount %g %s cannot be represented", amount, currency)
+	}
+	return &pb.Money{CurrencyCode: currency, MinorUnits: int64(minorUnits)}, nil
+}
+
+// Amount returns money in the major units of its currency, like 12.5 for 1250 cents
+func Amount(money *pb.Money) float64 {
+	return float64(money.GetMinorUnits()) / math.Pow10(minorDigits[money.GetCurrencyCode()])
+}
+
+// Compare returns -1, 0 or 1 when a is less than, equal to or more than b, which must be in the same currency
+func Compare(a, b *pb.Money) (int, error) {
+	if a.GetCurrencyCode() != b.GetCurrencyCode() {
+		return 0, fmt.Errorf("%s and %s: %w", a.GetCurrencyCode(), b.GetCurrencyCode(), ErrCurrencyMismatch)
+	}
+	switch {
+	case a.GetMinorUnits() < b.GetMinorUnits():
+		return -1, nil
+	case a.GetMinorUnits() > b.GetMinorUnits():
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Format returns money with all the digits of its minor unit, like "1299.00 EUR" or "150000 JPY"
+func Format(money *pb.Money) string {
+	digits, ok := minorDigits[money.GetCurrencyCode()]
+	if !ok {
+		return fmt.Sprintf("%d minor units of %q", money.GetMinorUnits(), money.GetCurrencyCode())
+	}
+	return strconv.FormatFloat(Amount(money), 'f', digits, 64) + " " + money.GetCurrencyCode()
+}
+
+// Parse parses an amount followed by a currency code, like "1299.99 EUR" or "150000JPY".
+// The code is case-insensitive.
+func Parse(s string) (*pb.Money, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i == 0 {
+		return nil, fmt.Errorf("money %q must start with a number", s)
+	}
+	if i < 0 {
+		return nil, fmt.Errorf("money %q has no currency", s)
+	}
+	amount, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return nil, fmt.Errorf("money %q: %w", s, err)
+	}
+	return New(strings.ToUpper(strings.TrimSpace(s[i:])), amount)
+}
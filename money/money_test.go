@@ -0,0 +1,72 @@
+package money_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/neepoo/pcbook/money"
+	"github.com/neepoo/pcbook/pb"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	price, err := money.New("EUR", 1299.996)
+	require.NoError(t, err)
+	require.Equal(t, int64(130000), price.GetMinorUnits())
+	require.Equal(t, "1300.00 EUR", money.Format(price))
+
+	price, err = money.New("JPY", 150000.4)
+	require.NoError(t, err)
+	require.Equal(t, int64(150000), price.GetMinorUnits())
+	require.Equal(t, "150000 JPY", money.Format(price))
+	require.Equal(t, 150000.0, money.Amount(price))
+
+	_, err = money.New("XXX", 1)
+	require.ErrorIs(t, err, money.ErrUnsupportedCurrency)
+	_, err = money.New("USD", 1e30)
+	require.Error(t, err)
+	_, err = money.New("JPY", math.Exp2(63))
+	require.Error(t, err)
+	price, err = money.New("JPY", -math.Exp2(63))
+	require.NoError(t, err)
+	require.Equal(t, int64(math.MinInt64), price.GetMinorUnits())
+
+	require.Equal(t, []string{"EUR", "GBP", "JPY", "USD"}, money.Currencies())
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	cmp, err := money.Compare(&pb.Money{CurrencyCode: "GBP", MinorUnits: 100}, &pb.Money{CurrencyCode: "GBP", MinorUnits: 99})
+	require.NoError(t, err)
+	require.Equal(t, 1, cmp)
+	cmp, err = money.Compare(&pb.Money{CurrencyCode: "GBP", MinorUnits: 99}, &pb.Money{CurrencyCode: "GBP", MinorUnits: 99})
+	require.NoError(t, err)
+	require.Zero(t, cmp)
+
+	_, err = money.Compare(&pb.Money{CurrencyCode: "GBP", MinorUnits: 1}, &pb.Money{CurrencyCode: "EUR", MinorUnits: 1})
+	require.ErrorIs(t, err, money.ErrCurrencyMismatch)
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]*pb.Money{
+		"1299.99 EUR": {CurrencyCode: "EUR", MinorUnits: 129999},
+		"150000jpy":   {CurrencyCode: "JPY", MinorUnits: 150000},
+		" 0.1 gbp ":   {CurrencyCode: "GBP", MinorUnits: 10},
+	}
+	for s, expected := range testCases {
+		price, err := money.Parse(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, price, s)
+	}
+
+	for _, s := range []string{"", "EUR", "12", "-12 EUR", "12 XXX"} {
+		_, err := money.Parse(s)
+		require.Error(t, err, s)
+	}
+}
@@ -19,9 +19,19 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LaptopServiceClient interface {
 	CreateLaptop(ctx context.Context, in *CreateLaptopRequest, opts ...grpc.CallOption) (*CreateLaptopResponse, error)
+	GetLaptop(ctx context.Context, in *GetLaptopRequest, opts ...grpc.CallOption) (*GetLaptopResponse, error)
+	UpdateLaptop(ctx context.Context, in *UpdateLaptopRequest, opts ...grpc.CallOption) (*UpdateLaptopResponse, error)
+	DeleteLaptop(ctx context.Context, in *DeleteLaptopRequest, opts ...grpc.CallOption) (*DeleteLaptopResponse, error)
+	BatchCreateLaptops(ctx context.Context, opts ...grpc.CallOption) (LaptopService_BatchCreateLaptopsClient, error)
 	SearchLaptop(ctx context.Context, in *SearchLaptopRequest, opts ...grpc.CallOption) (LaptopService_SearchLaptopClient, error)
 	UploadImage(ctx context.Context, opts ...grpc.CallOption) (LaptopService_UploadImageClient, error)
 	RateLaptop(ctx context.Context, opts ...grpc.CallOption) (LaptopService_RateLaptopClient, error)
+	WatchLaptops(ctx context.Context, in *WatchLaptopsRequest, opts ...grpc.CallOption) (LaptopService_WatchLaptopsClient, error)
+	GetPriceHistory(ctx context.Context, in *GetPriceHistoryRequest, opts ...grpc.CallOption) (*GetPriceHistoryResponse, error)
+	CreatePriceAlert(ctx context.Context, in *CreatePriceAlertRequest, opts ...grpc.CallOption) (*CreatePriceAlertResponse, error)
+	ListPriceAlerts(ctx context.Context, in *ListPriceAlertsRequest, opts ...grpc.CallOption) (*ListPriceAlertsResponse, error)
+	DeletePriceAlert(ctx context.Context, in *DeletePriceAlertRequest, opts ...grpc.CallOption) (*DeletePriceAlertResponse, error)
+	WatchPriceAlerts(ctx context.Context, in *WatchPriceAlertsRequest, opts ...grpc.CallOption) (LaptopService_WatchPriceAlertsClient, error)
 }
 
 type laptopServiceClient struct {
@@ -41,8 +51,66 @@ func (c *laptopServiceClient) CreateLaptop(ctx context.Context, in *CreateLaptop
 	return out, nil
 }
 
+func (c *laptopServiceClient) GetLaptop(ctx context.Context, in *GetLaptopRequest, opts ...grpc.CallOption) (*GetLaptopResponse, error) {
+	out := new(GetLaptopResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/GetLaptop", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) UpdateLaptop(ctx context.Context, in *UpdateLaptopRequest, opts ...grpc.CallOption) (*UpdateLaptopResponse, error) {
+	out := new(UpdateLaptopResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/UpdateLaptop", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) DeleteLaptop(ctx context.Context, in *DeleteLaptopRequest, opts ...grpc.CallOption) (*DeleteLaptopResponse, error) {
+	out := new(DeleteLaptopResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/DeleteLaptop", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) BatchCreateLaptops(ctx context.Context, opts ...grpc.CallOption) (LaptopService_BatchCreateLaptopsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[0], "/pcbook.LaptopService/BatchCreateLaptops", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &laptopServiceBatchCreateLaptopsClient{stream}
+	return x, nil
+}
+
+type LaptopService_BatchCreateLaptopsClient interface {
+	Send(*BatchCreateLaptopsRequest) error
+	Recv() (*BatchCreateLaptopsResponse, error)
+	grpc.ClientStream
+}
+
+type laptopServiceBatchCreateLaptopsClient struct {
+	grpc.ClientStream
+}
+
+func (x *laptopServiceBatchCreateLaptopsClient) Send(m *BatchCreateLaptopsRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *laptopServiceBatchCreateLaptopsClient) Recv() (*BatchCreateLaptopsResponse, error) {
+	m := new(BatchCreateLaptopsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *laptopServiceClient) SearchLaptop(ctx context.Context, in *SearchLaptopRequest, opts ...grpc.CallOption) (LaptopService_SearchLaptopClient, error) {
-	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[0], "/pcbook.LaptopService/SearchLaptop", opts...)
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[1], "/pcbook.LaptopService/SearchLaptop", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -74,7 +142,7 @@ func (x *laptopServiceSearchLaptopClient) Recv() (*SearchLaptopResponse, error)
 }
 
 func (c *laptopServiceClient) UploadImage(ctx context.Context, opts ...grpc.CallOption) (LaptopService_UploadImageClient, error) {
-	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[1], "/pcbook.LaptopService/UploadImage", opts...)
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[2], "/pcbook.LaptopService/UploadImage", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -108,7 +176,7 @@ func (x *laptopServiceUploadImageClient) CloseAndRecv() (*UploadImageResponse, e
 }
 
 func (c *laptopServiceClient) RateLaptop(ctx context.Context, opts ...grpc.CallOption) (LaptopService_RateLaptopClient, error) {
-	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[2], "/pcbook.LaptopService/RateLaptop", opts...)
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[3], "/pcbook.LaptopService/RateLaptop", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -138,14 +206,124 @@ func (x *laptopServiceRateLaptopClient) Recv() (*RateLaptopResponse, error) {
 	return m, nil
 }
 
+func (c *laptopServiceClient) WatchLaptops(ctx context.Context, in *WatchLaptopsRequest, opts ...grpc.CallOption) (LaptopService_WatchLaptopsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[4], "/pcbook.LaptopService/WatchLaptops", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &laptopServiceWatchLaptopsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LaptopService_WatchLaptopsClient interface {
+	Recv() (*WatchLaptopsResponse, error)
+	grpc.ClientStream
+}
+
+type laptopServiceWatchLaptopsClient struct {
+	grpc.ClientStream
+}
+
+func (x *laptopServiceWatchLaptopsClient) Recv() (*WatchLaptopsResponse, error) {
+	m := new(WatchLaptopsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *laptopServiceClient) GetPriceHistory(ctx context.Context, in *GetPriceHistoryRequest, opts ...grpc.CallOption) (*GetPriceHistoryResponse, error) {
+	out := new(GetPriceHistoryResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/GetPriceHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) CreatePriceAlert(ctx context.Context, in *CreatePriceAlertRequest, opts ...grpc.CallOption) (*CreatePriceAlertResponse, error) {
+	out := new(CreatePriceAlertResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/CreatePriceAlert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) ListPriceAlerts(ctx context.Context, in *ListPriceAlertsRequest, opts ...grpc.CallOption) (*ListPriceAlertsResponse, error) {
+	out := new(ListPriceAlertsResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/ListPriceAlerts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) DeletePriceAlert(ctx context.Context, in *DeletePriceAlertRequest, opts ...grpc.CallOption) (*DeletePriceAlertResponse, error) {
+	out := new(DeletePriceAlertResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/DeletePriceAlert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) WatchPriceAlerts(ctx context.Context, in *WatchPriceAlertsRequest, opts ...grpc.CallOption) (LaptopService_WatchPriceAlertsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[5], "/pcbook.LaptopService/WatchPriceAlerts", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &laptopServiceWatchPriceAlertsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LaptopService_WatchPriceAlertsClient interface {
+	Recv() (*WatchPriceAlertsResponse, error)
+	grpc.ClientStream
+}
+
+type laptopServiceWatchPriceAlertsClient struct {
+	grpc.ClientStream
+}
+
+func (x *laptopServiceWatchPriceAlertsClient) Recv() (*WatchPriceAlertsResponse, error) {
+	m := new(WatchPriceAlertsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // LaptopServiceServer is the server API for LaptopService service.
 // All implementations must embed UnimplementedLaptopServiceServer
 // for forward compatibility
 type LaptopServiceServer interface {
 	CreateLaptop(context.Context, *CreateLaptopRequest) (*CreateLaptopResponse, error)
+	GetLaptop(context.Context, *GetLaptopRequest) (*GetLaptopResponse, error)
+	UpdateLaptop(context.Context, *UpdateLaptopRequest) (*UpdateLaptopResponse, error)
+	DeleteLaptop(context.Context, *DeleteLaptopRequest) (*DeleteLaptopResponse, error)
+	BatchCreateLaptops(LaptopService_BatchCreateLaptopsServer) error
 	SearchLaptop(*SearchLaptopRequest, LaptopService_SearchLaptopServer) error
 	UploadImage(LaptopService_UploadImageServer) error
 	RateLaptop(LaptopService_RateLaptopServer) error
+	WatchLaptops(*WatchLaptopsRequest, LaptopService_WatchLaptopsServer) error
+	GetPriceHistory(context.Context, *GetPriceHistoryRequest) (*GetPriceHistoryResponse, error)
+	CreatePriceAlert(context.Context, *CreatePriceAlertRequest) (*CreatePriceAlertResponse, error)
+	ListPriceAlerts(context.Context, *ListPriceAlertsRequest) (*ListPriceAlertsResponse, error)
+	DeletePriceAlert(context.Context, *DeletePriceAlertRequest) (*DeletePriceAlertResponse, error)
+	WatchPriceAlerts(*WatchPriceAlertsRequest, LaptopService_WatchPriceAlertsServer) error
 	mustEmbedUnimplementedLaptopServiceServer()
 }
 
@@ -156,6 +334,18 @@ type UnimplementedLaptopServiceServer struct {
 func (UnimplementedLaptopServiceServer) CreateLaptop(context.Context, *CreateLaptopRequest) (*CreateLaptopResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateLaptop not implemented")
 }
+func (UnimplementedLaptopServiceServer) GetLaptop(context.Context, *GetLaptopRequest) (*GetLaptopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLaptop not implemented")
+}
+func (UnimplementedLaptopServiceServer) UpdateLaptop(context.Context, *UpdateLaptopRequest) (*UpdateLaptopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateLaptop not implemented")
+}
+func (UnimplementedLaptopServiceServer) DeleteLaptop(context.Context, *DeleteLaptopRequest) (*DeleteLaptopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteLaptop not implemented")
+}
+func (UnimplementedLaptopServiceServer) BatchCreateLaptops(LaptopService_BatchCreateLaptopsServer) error {
+	return status.Errorf(codes.Unimplemented, "method BatchCreateLaptops not implemented")
+}
 func (UnimplementedLaptopServiceServer) SearchLaptop(*SearchLaptopRequest, LaptopService_SearchLaptopServer) error {
 	return status.Errorf(codes.Unimplemented, "method SearchLaptop not implemented")
 }
@@ -165,6 +355,24 @@ func (UnimplementedLaptopServiceServer) UploadImage(LaptopService_UploadImageSer
 func (UnimplementedLaptopServiceServer) RateLaptop(LaptopService_RateLaptopServer) error {
 	return status.Errorf(codes.Unimplemented, "method RateLaptop not implemented")
 }
+func (UnimplementedLaptopServiceServer) WatchLaptops(*WatchLaptopsRequest, LaptopService_WatchLaptopsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchLaptops not implemented")
+}
+func (UnimplementedLaptopServiceServer) GetPriceHistory(context.Context, *GetPriceHistoryRequest) (*GetPriceHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPriceHistory not implemented")
+}
+func (UnimplementedLaptopServiceServer) CreatePriceAlert(context.Context, *CreatePriceAlertRequest) (*CreatePriceAlertResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreatePriceAlert not implemented")
+}
+func (UnimplementedLaptopServiceServer) ListPriceAlerts(context.Context, *ListPriceAlertsRequest) (*ListPriceAlertsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPriceAlerts not implemented")
+}
+func (UnimplementedLaptopServiceServer) DeletePriceAlert(context.Context, *DeletePriceAlertRequest) (*DeletePriceAlertResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeletePriceAlert not implemented")
+}
+func (UnimplementedLaptopServiceServer) WatchPriceAlerts(*WatchPriceAlertsRequest, LaptopService_WatchPriceAlertsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchPriceAlerts not implemented")
+}
 func (UnimplementedLaptopServiceServer) mustEmbedUnimplementedLaptopServiceServer() {}
 
 // UnsafeLaptopServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -196,6 +404,86 @@ func _LaptopService_CreateLaptop_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LaptopService_GetLaptop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLaptopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).GetLaptop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/GetLaptop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).GetLaptop(ctx, req.(*GetLaptopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_UpdateLaptop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateLaptopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).UpdateLaptop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/UpdateLaptop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).UpdateLaptop(ctx, req.(*UpdateLaptopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_DeleteLaptop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteLaptopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).DeleteLaptop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/DeleteLaptop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).DeleteLaptop(ctx, req.(*DeleteLaptopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_BatchCreateLaptops_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LaptopServiceServer).BatchCreateLaptops(&laptopServiceBatchCreateLaptopsServer{stream})
+}
+
+type LaptopService_BatchCreateLaptopsServer interface {
+	Send(*BatchCreateLaptopsResponse) error
+	Recv() (*BatchCreateLaptopsRequest, error)
+	grpc.ServerStream
+}
+
+type laptopServiceBatchCreateLaptopsServer struct {
+	grpc.ServerStream
+}
+
+func (x *laptopServiceBatchCreateLaptopsServer) Send(m *BatchCreateLaptopsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *laptopServiceBatchCreateLaptopsServer) Recv() (*BatchCreateLaptopsRequest, error) {
+	m := new(BatchCreateLaptopsRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _LaptopService_SearchLaptop_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(SearchLaptopRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -269,6 +557,120 @@ func (x *laptopServiceRateLaptopServer) Recv() (*RateLaptopRequest, error) {
 	return m, nil
 }
 
+func _LaptopService_WatchLaptops_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchLaptopsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LaptopServiceServer).WatchLaptops(m, &laptopServiceWatchLaptopsServer{stream})
+}
+
+type LaptopService_WatchLaptopsServer interface {
+	Send(*WatchLaptopsResponse) error
+	grpc.ServerStream
+}
+
+type laptopServiceWatchLaptopsServer struct {
+	grpc.ServerStream
+}
+
+func (x *laptopServiceWatchLaptopsServer) Send(m *WatchLaptopsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _LaptopService_GetPriceHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPriceHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).GetPriceHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/GetPriceHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).GetPriceHistory(ctx, req.(*GetPriceHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_CreatePriceAlert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreatePriceAlertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).CreatePriceAlert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/CreatePriceAlert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).CreatePriceAlert(ctx, req.(*CreatePriceAlertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_ListPriceAlerts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPriceAlertsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).ListPriceAlerts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/ListPriceAlerts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).ListPriceAlerts(ctx, req.(*ListPriceAlertsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_DeletePriceAlert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeletePriceAlertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).DeletePriceAlert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/DeletePriceAlert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).DeletePriceAlert(ctx, req.(*DeletePriceAlertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_WatchPriceAlerts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchPriceAlertsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LaptopServiceServer).WatchPriceAlerts(m, &laptopServiceWatchPriceAlertsServer{stream})
+}
+
+type LaptopService_WatchPriceAlertsServer interface {
+	Send(*WatchPriceAlertsResponse) error
+	grpc.ServerStream
+}
+
+type laptopServiceWatchPriceAlertsServer struct {
+	grpc.ServerStream
+}
+
+func (x *laptopServiceWatchPriceAlertsServer) Send(m *WatchPriceAlertsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // LaptopService_ServiceDesc is the grpc.ServiceDesc for LaptopService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -280,8 +682,42 @@ var LaptopService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CreateLaptop",
 			Handler:    _LaptopService_CreateLaptop_Handler,
 		},
+		{
+			MethodName: "GetLaptop",
+			Handler:    _LaptopService_GetLaptop_Handler,
+		},
+		{
+			MethodName: "UpdateLaptop",
+			Handler:    _LaptopService_UpdateLaptop_Handler,
+		},
+		{
+			MethodName: "DeleteLaptop",
+			Handler:    _LaptopService_DeleteLaptop_Handler,
+		},
+		{
+			MethodName: "GetPriceHistory",
+			Handler:    _LaptopService_GetPriceHistory_Handler,
+		},
+		{
+			MethodName: "CreatePriceAlert",
+			Handler:    _LaptopService_CreatePriceAlert_Handler,
+		},
+		{
+			MethodName: "ListPriceAlerts",
+			Handler:    _LaptopService_ListPriceAlerts_Handler,
+		},
+		{
+			MethodName: "DeletePriceAlert",
+			Handler:    _LaptopService_DeletePriceAlert_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BatchCreateLaptops",
+			Handler:       _LaptopService_BatchCreateLaptops_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 		{
 			StreamName:    "SearchLaptop",
 			Handler:       _LaptopService_SearchLaptop_Handler,
@@ -298,6 +734,16 @@ var LaptopService_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "WatchLaptops",
+			Handler:       _LaptopService_WatchLaptops_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchPriceAlerts",
+			Handler:       _LaptopService_WatchPriceAlerts_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "laptop_service.proto",
 }
@@ -0,0 +1,208 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.17.3
+// source: weight_message.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Weight_Unit int32
+
+const (
+	Weight_UNKNOWN  Weight_Unit = 0
+	Weight_KILOGRAM Weight_Unit = 1
+	Weight_POUND    Weight_Unit = 2
+)
+
+// Enum value maps for Weight_Unit.
+var (
+	Weight_Unit_name = map[int32]string{
+		0: "UNKNOWN",
+		1: "KILOGRAM",
+		2: "POUND",
+	}
+	Weight_Unit_value = map[string]int32{
+		"UNKNOWN":  0,
+		"KILOGRAM": 1,
+		"POUND":    2,
+	}
+)
+
+func (x Weight_Unit) Enum() *Weight_Unit {
+	p := new(Weight_Unit)
+	*p = x
+	return p
+}
+
+func (x Weight_Unit) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Weight_Unit) Descriptor() protoreflect.EnumDescriptor {
+	return file_weight_message_proto_enumTypes[0].Descriptor()
+}
+
+func (Weight_Unit) Type() protoreflect.EnumType {
+	return &file_weight_message_proto_enumTypes[0]
+}
+
+func (x Weight_Unit) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Weight_Unit.Descriptor instead.
+func (Weight_Unit) EnumDescriptor() ([]byte, []int) {
+	return file_weight_message_proto_rawDescGZIP(), []int{0, 0}
+}
+
+type Weight struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Value float64     `protobuf:"fixed64,1,opt,name=value,proto3" json:"value,omitempty"`
+	Unit  Weight_Unit `protobuf:"varint,2,opt,name=unit,proto3,enum=pcbook.Weight_Unit" json:"unit,omitempty"`
+}
+
+func (x *Weight) Reset() {
+	*x = Weight{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_weight_message_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Weight) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Weight) ProtoMessage() {}
+
+func (x *Weight) ProtoReflect() protoreflect.Message {
+	mi := &file_weight_message_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Weight.ProtoReflect.Descriptor instead.
+func (*Weight) Descriptor() ([]byte, []int) {
+	return file_weight_message_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Weight) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *Weight) GetUnit() Weight_Unit {
+	if x != nil {
+		return x.Unit
+	}
+	return Weight_UNKNOWN
+}
+
+var File_weight_message_proto protoreflect.FileDescriptor
+
+var file_weight_message_proto_rawDesc = []byte{
+	0x0a, 0x14, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x5f, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x22, 0x75,
+	0x0a, 0x06, 0x57, 0x65, 0x69, 0x67, 0x68, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x27,
+	0x0a, 0x04, 0x75, 0x6e, 0x69, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x13, 0x2e, 0x70,
+	0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x57, 0x65, 0x69, 0x67, 0x68, 0x74, 0x2e, 0x55, 0x6e, 0x69,
+	0x74, 0x52, 0x04, 0x75, 0x6e, 0x69, 0x74, 0x22, 0x2c, 0x0a, 0x04, 0x55, 0x6e, 0x69, 0x74, 0x12,
+	0x0b, 0x0a, 0x07, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08,
+	0x4b, 0x49, 0x4c, 0x4f, 0x47, 0x52, 0x41, 0x4d, 0x10, 0x01, 0x12, 0x09, 0x0a, 0x05, 0x50, 0x4f,
+	0x55, 0x4e, 0x44, 0x10, 0x02, 0x42, 0x05, 0x5a, 0x03, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_weight_message_proto_rawDescOnce sync.Once
+	file_weight_message_proto_rawDescData = file_weight_message_proto_rawDesc
+)
+
+func file_weight_message_proto_rawDescGZIP() []byte {
+	file_weight_message_proto_rawDescOnce.Do(func() {
+		file_weight_message_proto_rawDescData = protoimpl.X.CompressGZIP(file_weight_message_proto_rawDescData)
+	})
+	return file_weight_message_proto_rawDescData
+}
+
+var file_weight_message_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_weight_message_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_weight_message_proto_goTypes = []interface{}{
+	(Weight_Unit)(0), // 0: pcbook.Weight.Unit
+	(*Weight)(nil),   // 1: pcbook.Weight
+}
+var file_weight_message_proto_depIdxs = []int32{
+	0, // 0: pcbook.Weight.unit:type_name -> pcbook.Weight.Unit
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_weight_message_proto_init() }
+func file_weight_message_proto_init() {
+	if File_weight_message_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_weight_message_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Weight); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_weight_message_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_weight_message_proto_goTypes,
+		DependencyIndexes: file_weight_message_proto_depIdxs,
+		EnumInfos:         file_weight_message_proto_enumTypes,
+		MessageInfos:      file_weight_message_proto_msgTypes,
+	}.Build()
+	File_weight_message_proto = out.File
+	file_weight_message_proto_rawDesc = nil
+	file_weight_message_proto_goTypes = nil
+	file_weight_message_proto_depIdxs = nil
+}
@@ -0,0 +1,165 @@
+// Package rpcerror builds the status errors of the services with structured details,
+// and decodes them for the clients
+package rpcerror
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Domain is the domain of the ErrorInfo details of the errors
+const Domain = "pcbook.neepoo.github.com"
+
+// the reasons of the ErrorInfo details, which the clients can rely on unlike the messages
+const (
+	ReasonLaptopNotFound       = "LAPTOP_NOT_FOUND"
+	ReasonLaptopAlreadyExists  = "LAPTOP_ALREADY_EXISTS"
+	ReasonInvalidLaptopID      = "INVALID_LAPTOP_ID"
+	ReasonValidationFailed     = "VALIDATION_FAILED"
+	ReasonImageTooLarge        = "IMAGE_TOO_LARGE"
+	ReasonRateLimited          = "RATE_LIMITED"
+	ReasonCursorExpired        = "CURSOR_EXPIRED"
+	ReasonInvalidCursor        = "INVALID_CURSOR"
+	ReasonWatcherTooSlow       = "WATCHER_TOO_SLOW"
+	ReasonIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+	ReasonBatchAborted         = "BATCH_ABORTED"
+	ReasonTooManyPriceAlerts   = "TOO_MANY_PRICE_ALERTS"
+)
+
+// ResourceLaptop is the resource type of the ResourceInfo details about a laptop
+const ResourceLaptop = "pcbook.Laptop"
+
+// Error is a status error with an ErrorInfo detail, to which other details are added
+type Error struct {
+	code    codes.Code
+	message string
+	info    *errdetails.ErrorInfo
+	details []protoiface.MessageV1
+}
+
+// New returns an error of code whose ErrorInfo detail has reason
+func New(code codes.Code, reason string, format string, args ...interface{}) *Error {
+	return &Error{
+		code:    code,
+		message: fmt.Sprintf(format, args...),
+		info:    &errdetails.ErrorInfo{Reason: reason, Domain: Domain},
+	}
+}
+
+// WithMetadata adds a key to the metadata of the ErrorInfo detail
+func (e *Error) WithMetadata(key, value string) *Error {
+	if e.info.Metadata == nil {
+		e.info.Metadata = map[string]string{}
+	}
+	e.info.Metadata[key] = value
+	return e
+}
+
+// WithResource adds a ResourceInfo detail about the resource the error is about
+func (e *Error) WithResource(resourceType, name, description string) *Error {
+	e.details = append(e.details, &errdetails.ResourceInfo{
+		ResourceType: resourceType,
+		ResourceName: name,
+		Description:  description,
+	})
+	return e
+}
+
+// WithRetryDelay adds a RetryInfo detail telling the client how long to wait before retrying
+func (e *Error) WithRetryDelay(delay time.Duration) *Error {
+	e.details = append(e.details, &errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+	return e
+}
+
+// WithBadRequest adds a BadRequest detail of the fields which are invalid
+func (e *Error) WithBadRequest(badRequest *errdetails.BadRequest) *Error {
+	e.details = append(e.details, badRequest)
+	return e
+}
+
+// Err returns the status error, without its details if they cannot be encoded
+func (e *Error) Err() error {
+	st := status.New(e.code, e.message)
+	detailed, err := st.WithDetails(append([]protoiface.MessageV1{e.info}, e.details...)...)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// statusOf returns the status of err, which may wrap a status error
+func statusOf(err error) *status.Status {
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		return grpcErr.GRPCStatus()
+	}
+	return status.Convert(err)
+}
+
+// Reason returns the reason of the ErrorInfo detail of err, empty if it has none
+func Reason(err error) string {
+	for _, detail := range statusOf(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
+
+// RetryDelay returns the delay of the RetryInfo detail of err, and whether it has one
+func RetryDelay(err error) (time.Duration, bool) {
+	for _, detail := range statusOf(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// Describe returns the code, message and details of a status error on several lines.
+// The message of a wrapped status error is the one of the status, not of the wrapping.
+func Describe(err error) string {
+	st := statusOf(err)
+	lines := []string{fmt.Sprintf("%s: %s", st.Code(), st.Message())}
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			line := fmt.Sprintf("  reason: %s", detail.GetReason())
+			if len(detail.GetMetadata()) > 0 {
+				keys := make([]string, 0, len(detail.GetMetadata()))
+				for key := range detail.GetMetadata() {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				pairs := make([]string, len(keys))
+				for i, key := range keys {
+					pairs[i] = key + "=" + detail.GetMetadata()[key]
+				}
+				line += " (" + strings.Join(pairs, ", ") + ")"
+			}
+			lines = append(lines, line)
+		case *errdetails.ResourceInfo:
+			lines = append(lines, fmt.Sprintf("  resource: %s %s: %s", detail.GetResourceType(), detail.GetResourceName(), detail.GetDescription()))
+		case *errdetails.RetryInfo:
+			lines = append(lines, fmt.Sprintf("  retry after: %s", detail.GetRetryDelay().AsDuration()))
+		case *errdetails.BadRequest:
+			for _, violation := range detail.GetFieldViolations() {
+				lines = append(lines, fmt.Sprintf("  invalid field %s: %s", violation.GetField(), violation.GetDescription()))
+			}
+		case error:
+			lines = append(lines, fmt.Sprintf("  undecodable detail: %v", detail))
+		default:
+			lines = append(lines, fmt.Sprintf("  detail: %v", detail))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
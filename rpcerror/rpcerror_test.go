@@ -0,0 +1,61 @@
+package rpcerror_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/rpcerror"
+)
+
+func TestError(t *testing.T) {
+	t.Parallel()
+
+	err := rpcerror.New(codes.ResourceExhausted, rpcerror.ReasonRateLimited, "too many ratings from %s", "user1").
+		WithMetadata("limit", "2").
+		WithResource(rpcerror.ResourceLaptop, "1", "laptop is rated too often").
+		WithRetryDelay(3 * time.Second).
+		WithBadRequest(&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "score", Description: "score is too high"},
+		}}).
+		Err()
+
+	st := status.Convert(err)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Equal(t, "too many ratings from user1", st.Message())
+	require.Len(t, st.Details(), 4)
+	info := st.Details()[0].(*errdetails.ErrorInfo)
+	require.Equal(t, rpcerror.Domain, info.GetDomain())
+	require.Equal(t, map[string]string{"limit": "2"}, info.GetMetadata())
+
+	require.Equal(t, rpcerror.ReasonRateLimited, rpcerror.Reason(err))
+	delay, ok := rpcerror.RetryDelay(err)
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, delay)
+	require.Equal(t, `ResourceExhausted: too many ratings from user1
+  reason: RATE_LIMITED (limit=2)
+  resource: pcbook.Laptop 1: laptop is rated too often
+  retry after: 3s
+  invalid field score: score is too high`, rpcerror.Describe(err))
+
+	// the details of a wrapped status error are decoded too
+	wrapped := fmt.Errorf("cannot rate laptop: %w", err)
+	require.Equal(t, rpcerror.ReasonRateLimited, rpcerror.Reason(wrapped))
+	_, ok = rpcerror.RetryDelay(wrapped)
+	require.True(t, ok)
+}
+
+func TestPlainError(t *testing.T) {
+	t.Parallel()
+
+	err := status.Errorf(codes.Internal, "cannot save laptop")
+	require.Empty(t, rpcerror.Reason(err))
+	_, ok := rpcerror.RetryDelay(err)
+	require.False(t, ok)
+	require.Equal(t, "Internal: cannot save laptop", rpcerror.Describe(err))
+}
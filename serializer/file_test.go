@@ -1,6 +1,7 @@
 package serializer
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -13,8 +14,9 @@ import (
 func TestFileSerializer(t *testing.T) {
 	t.Parallel()
 
-	binaryFile := "../tmp/laptop.bin"
-	jsonFile := "../tmp/laptop.json"
+	dir := t.TempDir()
+	binaryFile := filepath.Join(dir, "laptop.bin")
+	jsonFile := filepath.Join(dir, "laptop.json")
 
 	laptop1 := sample.NewLaptop()
 
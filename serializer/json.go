@@ -14,3 +14,8 @@ func ProtobufToJSON(message proto.Message) ([]byte, error) {
 	}
 	return m.Marshal(message)
 }
+
+// JSONToProtobuf converts JSON, with either the proto or the JSON field names, to a protocol buffer message
+func JSONToProtobuf(data []byte, message proto.Message) error {
+	return protojson.Unmarshal(data, message)
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/neepoo/pcbook/logging"
+	"github.com/neepoo/pcbook/pb"
+)
+
+// AdminServer serves the administration RPCs
+type AdminServer struct {
+	FlaggedRatingStore FlaggedRatingStore
+	RatingStore        RatingStore
+	APIKeyStore        APIKeyStore
+	WebhookStore       WebhookStore
+	WebhookDispatcher  *WebhookDispatcher
+	AuditLog           AuditLog
+	ExchangeRates      *ExchangeRates
+	pb.UnimplementedAdminServiceServer
+}
+
+func NewAdminServer(flaggedRatingStore FlaggedRatingStore, ratingStore RatingStore) *AdminServer {
+	return &AdminServer{
+		FlaggedRatingStore: flaggedRatingStore,
+		RatingStore:        ratingStore,
+	}
+}
+
+func (server *AdminServer) ListFlaggedRatings(
+	ctx context.Context,
+	req *pb.ListFlaggedRatingsRequest,
+) (*pb.ListFlaggedRatingsResponse, error) {
+	ratings, err := server.FlaggedRatingStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list flagged ratings: %v", err)
+	}
+	res := &pb.ListFlaggedRatingsResponse{}
+	for _, rating := range ratings {
+		res.Ratings = append(res.Ratings, toPbFlaggedRating(rating))
+	}
+	return res, nil
+}
+
+// ReviewFlaggedRating removes a rating from the review queue and counts it if it is approved
+func (server *AdminServer) ReviewFlaggedRating(
+	ctx context.Context,
+	req *pb.ReviewFlaggedRatingRequest,
+) (*pb.ReviewFlaggedRatingResponse, error) {
+	rating, err := server.FlaggedRatingStore.Remove(req.GetId())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "flagged rating %s doesn't exist", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot remove flagged rating: %v", err)
+	}
+
+	if req.GetApprove() {
+		_, err = server.RatingStore.Add(rating.LaptopID, rating.Score)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot add rating to the store: %v", err)
+		}
+	}
+	logging.FromContext(ctx).Info(
+		"reviewed flagged rating",
+		"rating_id", rating.ID, "laptop_id", rating.LaptopID, "approved", req.GetApprove(),
+	)
+
+	return &pb.ReviewFlaggedRatingResponse{
+		Rating:   toPbFlaggedRating(rating),
+		Approved: req.GetApprove(),
+	}, nil
+}
+
+// CreateAPIKey creates an API key and returns it, the key cannot be recovered later
+func (server *AdminServer) CreateAPIKey(
+	ctx context.Context,
+	req *pb.CreateAPIKeyRequest,
+) (*pb.CreateAPIKeyResponse, error) {
+	if server.APIKeyStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "API keys are not enabled")
+	}
+
+	apiKey, key, err := NewAPIKey(req.GetName(), req.GetScopes())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot create API key: %v", err)
+	}
+	err = server.APIKeyStore.Save(apiKey)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save API key: %v", err)
+	}
+	logging.FromContext(ctx).Info("created API key", "api_key_id", apiKey.ID, "scopes", apiKey.Scopes)
+
+	return &pb.CreateAPIKeyResponse{
+		ApiKey: toPbAPIKey(apiKey),
+		Key:    key,
+	}, nil
+}
+
+func (server *AdminServer) ListAPIKeys(
+	ctx context.Context,
+	req *pb.ListAPIKeysRequest,
+) (*pb.ListAPIKeysResponse, error) {
+	if server.APIKeyStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "API keys are not enabled")
+	}
+
+	apiKeys, err := server.APIKeyStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list API keys: %v", err)
+	}
+	res := &pb.ListAPIKeysResponse{}
+	for _, apiKey := range apiKeys {
+		res.ApiKeys = append(res.ApiKeys, toPbAPIKey(apiKey))
+	}
+	return res, nil
+}
+
+func (server *AdminServer) RevokeAPIKey(
+	ctx context.Context,
+	req *pb.RevokeAPIKeyRequest,
+) (*pb.RevokeAPIKeyResponse, error) {
+	if server.APIKeyStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "API keys are not enabled")
+	}
+
+	apiKey, err := server.APIKeyStore.Revoke(req.GetId())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "API key %s doesn't exist", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke API key: %v", err)
+	}
+	logging.FromContext(ctx).Info("revoked API key", "api_key_id", apiKey.ID)
+
+	return &pb.RevokeAPIKeyResponse{ApiKey: toPbAPIKey(apiKey)}, nil
+}
+
+// RegisterWebhook registers a webhook and returns its secret, which cannot be recovered later
+func (server *AdminServer) RegisterWebhook(
+	ctx context.Context,
+	req *pb.RegisterWebhookRequest,
+) (*pb.RegisterWebhookResponse, error) {
+	if server.WebhookStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "webhooks are not enabled")
+	}
+
+	webhook, err := NewWebhook(req.GetUrl(), req.GetEventTypes(), req.GetSecret())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot create webhook: %v", err)
+	}
+	err = server.WebhookStore.Save(webhook)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save webhook: %v", err)
+	}
+	logging.FromContext(ctx).Info("registered webhook", "webhook_id", webhook.ID, "url", webhook.URL)
+
+	return &pb.RegisterWebhookResponse{
+		Webhook: toPbWebhook(webhook),
+		Secret:  webhook.Secret,
+	}, nil
+}
+
+func (server *AdminServer) ListWebhooks(
+	ctx context.Context,
+	req *pb.ListWebhooksRequest,
+) (*pb.ListWebhooksResponse, error) {
+	if server.WebhookStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "webhooks are not enabled")
+	}
+
+	webhooks, err := server.WebhookStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list webhooks: %v", err)
+	}
+	res := &pb.ListWebhooksResponse{}
+	for _, webhook := range webhooks {
+		res.Webhooks = append(res.Webhooks, toPbWebhook(webhook))
+	}
+	return res, nil
+}
+
+func (server *AdminServer) DeleteWebhook(
+	ctx context.Context,
+	req *pb.DeleteWebhookRequest,
+) (*pb.DeleteWebhookResponse, error) {
+	if server.WebhookStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "webhooks are not enabled")
+	}
+
+	webhook, err := server.WebhookStore.Delete(req.GetId())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "webhook %s doesn't exist", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete webhook: %v", err)
+	}
+	logging.FromContext(ctx).Info("deleted webhook", "webhook_id", webhook.ID)
+
+	return &pb.DeleteWebhookResponse{Webhook: toPbWebhook(webhook)}, nil
+}
+
+func (server *AdminServer) ListWebhookDeliveries(
+	ctx context.Context,
+	req *pb.ListWebhookDeliveriesRequest,
+) (*pb.ListWebhookDeliveriesResponse, error) {
+	if server.WebhookDispatcher == nil {
+		return nil, status.Errorf(codes.Unimplemented, "webhooks are not enabled")
+	}
+
+	res := &pb.ListWebhookDeliveriesResponse{}
+	for _, delivery := range server.WebhookDispatcher.List(req.GetWebhookId(), req.GetStatus()) {
+		res.Deliveries = append(res.Deliveries, toPbWebhookDelivery(delivery))
+	}
+	return res, nil
+}
+
+// RetryWebhookDelivery delivers a dead letter again
+func (server *AdminServer) RetryWebhookDelivery(
+	ctx context.Context,
+	req *pb.RetryWebhookDeliveryRequest,
+) (*pb.RetryWebhookDeliveryResponse, error) {
+	if server.WebhookDispatcher == nil {
+		return nil, status.Errorf(codes.Unimplemented, "webhooks are not enabled")
+	}
+
+	delivery, err := server.WebhookDispatcher.Retry(req.GetId())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "webhook delivery %s doesn't exist", req.GetId())
+	}
+	if errors.Is(err, ErrNotDeadLetter) {
+		return nil, status.Errorf(codes.FailedPrecondition, "webhook delivery %s didn't fail", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot retry webhook delivery: %v", err)
+	}
+	logging.FromContext(ctx).Info("retrying webhook delivery", "delivery_id", delivery.ID, "webhook_id", delivery.WebhookID)
+
+	return &pb.RetryWebhookDeliveryResponse{Delivery: toPbWebhookDelivery(delivery)}, nil
+}
+
+// QueryAuditLog returns the audited changes matching the request, newest first
+func (server *AdminServer) QueryAuditLog(
+	ctx context.Context,
+	req *pb.QueryAuditLogRequest,
+) (*pb.QueryAuditLogResponse, error) {
+	if server.AuditLog == nil {
+		return nil, status.Errorf(codes.Unimplemented, "audit log is not enabled")
+	}
+
+	query := &AuditQuery{
+		Actor:    req.GetActor(),
+		TargetID: req.GetTargetId(),
+		Limit:    int(req.GetLimit()),
+	}
+	if req.GetSince() != nil {
+		query.Since = req.GetSince().AsTime()
+	}
+	if req.GetUntil() != nil {
+		query.Until = req.GetUntil().AsTime()
+	}
+	if !query.Since.IsZero() && !query.Until.IsZero() && !query.Since.Before(query.Until) {
+		return nil, status.Errorf(codes.InvalidArgument, "since must be before until")
+	}
+
+	entries, err := server.AuditLog.Query(query)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot query audit log: %v", err)
+	}
+	res := &pb.QueryAuditLogResponse{}
+	for _, entry := range entries {
+		res.Entries = append(res.Entries, toPbAuditEntry(entry))
+	}
+	return res, nil
+}
+
+// UpdateExchangeRates changes the rates of some currencies, and returns the whole table
+func (server *AdminServer) UpdateExchangeRates(
+	ctx context.Context,
+	req *pb.UpdateExchangeRatesRequest,
+) (*pb.UpdateExchangeRatesResponse, error) {
+	if server.ExchangeRates == nil {
+		return nil, status.Errorf(codes.Unimplemented, "exchange rates are not enabled")
+	}
+
+	err := server.ExchangeRates.Update(req.GetRates())
+	if errors.Is(err, ErrInvalidExchangeRate) {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot update exchange rates: %v", err)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot update exchange rates: %v", err)
+	}
+	logging.FromContext(ctx).Info("updated exchange rates", "rates", req.GetRates())
+	return &pb.UpdateExchangeRatesResponse{Rates: server.ExchangeRates.Rates()}, nil
+}
+
+func toPbAuditEntry(entry *AuditEntry) *pb.AuditEntry {
+	return &pb.AuditEntry{
+		Id:            entry.ID,
+		Time:          timestamppb.New(entry.Time),
+		Actor:         entry.Actor,
+		Method:        entry.Method,
+		TargetId:      entry.TargetID,
+		Before:        entry.Before,
+		After:         entry.After,
+		ChangedFields: entry.ChangedFields,
+	}
+}
+
+func toPbWebhook(webhook *Webhook) *pb.Webhook {
+	return &pb.Webhook{
+		Id:         webhook.ID,
+		Url:        webhook.URL,
+		EventTypes: webhook.EventTypes,
+		CreatedAt:  timestamppb.New(webhook.CreatedAt),
+	}
+}
+
+func toPbWebhookDelivery(delivery *WebhookDelivery) *pb.WebhookDelivery {
+	res := &pb.WebhookDelivery{
+		Id:             delivery.ID,
+		WebhookId:      delivery.WebhookID,
+		EventType:      delivery.EventType,
+		EventCursor:    delivery.EventCursor,
+		Status:         delivery.Status,
+		Attempts:       uint32(delivery.Attempts),
+		LastStatusCode: int32(delivery.LastStatusCode),
+		LastError:      delivery.LastError,
+	}
+	if !delivery.LastAttemptAt.IsZero() {
+		res.LastAttemptAt = timestamppb.New(delivery.LastAttemptAt)
+	}
+	if !delivery.NextAttemptAt.IsZero() {
+		res.NextAttemptAt = timestamppb.New(delivery.NextAttemptAt)
+	}
+	return res
+}
+
+func toPbAPIKey(apiKey *APIKey) *pb.APIKey {
+	return &pb.APIKey{
+		Id:        apiKey.ID,
+		Name:      apiKey.Name,
+		Scopes:    apiKey.Scopes,
+		CreatedAt: timestamppb.New(apiKey.CreatedAt),
+		Revoked:   apiKey.Revoked,
+	}
+}
+
+func toPbFlaggedRating(rating *FlaggedRating) *pb.FlaggedRating {
+	return &pb.FlaggedRating{
+		Id:        rating.ID,
+		LaptopId:  rating.LaptopID,
+		Source:    rating.Source,
+		Score:     rating.Score,
+		Reason:    rating.Reason,
+		FlaggedAt: timestamppb.New(rating.Time),
+	}
+}
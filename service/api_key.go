@@ -0,0 +1,79 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeRate   = "rate"
+	ScopeUpload = "upload"
+)
+
+var apiKeyScopes = map[string]bool{
+	ScopeRead:   true,
+	ScopeWrite:  true,
+	ScopeRate:   true,
+	ScopeUpload: true,
+}
+
+// APIKey lets a machine client call the server with a set of scopes.
+// Only the hash of the key is kept.
+type APIKey struct {
+	ID        string
+	Name      string
+	HashedKey string
+	Scopes    []string
+	CreatedAt time.Time
+	Revoked   bool
+}
+
+// NewAPIKey returns a new API key and the key itself, which cannot be recovered later
+func NewAPIKey(name string, scopes []string) (*APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("API key must have at least one scope")
+	}
+	for _, scope := range scopes {
+		if !apiKeyScopes[scope] {
+			return nil, "", fmt.Errorf("unknown API key scope: %q", scope)
+		}
+	}
+
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot generate API key: %w", err)
+	}
+	key := "pcb_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	apiKey := &APIKey{
+		ID:        uuid.NewString(),
+		Name:      name,
+		HashedKey: HashAPIKey(key),
+		Scopes:    append([]string(nil), scopes...),
+		CreatedAt: time.Now(),
+	}
+	return apiKey, key, nil
+}
+
+// HashAPIKey returns the hash an API key is stored under.
+// API keys are long random strings, so a fast hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Clone returns a copy of the API key
+func (apiKey *APIKey) Clone() *APIKey {
+	other := *apiKey
+	other.Scopes = append([]string(nil), apiKey.Scopes...)
+	return &other
+}
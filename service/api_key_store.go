@@ -0,0 +1,77 @@
+package service
+
+import (
+	"sort"
+	"sync"
+)
+
+type APIKeyStore interface {
+	Save(apiKey *APIKey) error
+	FindByHash(hashedKey string) (*APIKey, error)
+	List() ([]*APIKey, error)
+	Revoke(id string) (*APIKey, error)
+}
+
+type InMemoryAPIKeyStore struct {
+	mutex   sync.RWMutex
+	apiKeys map[string]*APIKey
+	hashes  map[string]string
+}
+
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		apiKeys: map[string]*APIKey{},
+		hashes:  map[string]string{},
+	}
+}
+
+func (store *InMemoryAPIKeyStore) Save(apiKey *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.apiKeys[apiKey.ID] != nil || store.hashes[apiKey.HashedKey] != "" {
+		return ErrAlreadyExists
+	}
+	store.apiKeys[apiKey.ID] = apiKey.Clone()
+	store.hashes[apiKey.HashedKey] = apiKey.ID
+	return nil
+}
+
+// FindByHash returns the API key with the given hash, or nil if there is none
+func (store *InMemoryAPIKeyStore) FindByHash(hashedKey string) (*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	apiKey := store.apiKeys[store.hashes[hashedKey]]
+	if apiKey == nil {
+		return nil, nil
+	}
+	return apiKey.Clone(), nil
+}
+
+// List returns the API keys, oldest first
+func (store *InMemoryAPIKeyStore) List() ([]*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	apiKeys := make([]*APIKey, 0, len(store.apiKeys))
+	for _, apiKey := range store.apiKeys {
+		apiKeys = append(apiKeys, apiKey.Clone())
+	}
+	sort.Slice(apiKeys, func(i, j int) bool {
+		return apiKeys[i].CreatedAt.Before(apiKeys[j].CreatedAt)
+	})
+	return apiKeys, nil
+}
+
+func (store *InMemoryAPIKeyStore) Revoke(id string) (*APIKey, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	apiKey := store.apiKeys[id]
+	if apiKey == nil {
+		return nil, ErrNotFound
+	}
+	apiKey.Revoked = true
+	return apiKey.Clone(), nil
+}
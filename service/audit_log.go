@@ -0,0 +1,241 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// AuditEntry records a change made by a call
+type AuditEntry struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Method   string    `json:"method"`
+	TargetID string    `json:"target_id"`
+	// Before and After are the changed message in protojson, empty if it didn't exist
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// ChangedFields are the paths of the fields which differ between Before and After
+	ChangedFields []string `json:"changed_fields,omitempty"`
+}
+
+// AuditQuery selects audit entries, the zero value of a field matches all the entries
+type AuditQuery struct {
+	Actor    string
+	TargetID string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func (query *AuditQuery) matches(entry *AuditEntry) bool {
+	return (query.Actor == "" || entry.Actor == query.Actor) &&
+		(query.TargetID == "" || entry.TargetID == query.TargetID) &&
+		(query.Since.IsZero() || !entry.Time.Before(query.Since)) &&
+		(query.Until.IsZero() || entry.Time.Before(query.Until))
+}
+
+// AuditLog is an append-only log of audit entries
+type AuditLog interface {
+	Append(entry *AuditEntry) error
+	// Query returns the entries matching query, newest first
+	Query(query *AuditQuery) ([]*AuditEntry, error)
+}
+
+// NewAuditEntry returns the entry of a change from before to after, either can be nil
+func NewAuditEntry(actor, method, targetID string, before, after proto.Message) (*AuditEntry, error) {
+	entry := &AuditEntry{
+		ID:       uuid.NewString(),
+		Time:     time.Now(),
+		Actor:    actor,
+		Method:   method,
+		TargetID: targetID,
+	}
+
+	var err error
+	entry.Before, err = marshalAudit(before)
+	if err != nil {
+		return nil, err
+	}
+	entry.After, err = marshalAudit(after)
+	if err != nil {
+		return nil, err
+	}
+	entry.ChangedFields = changedFields(before, after)
+	return entry, nil
+}
+
+func marshalAudit(message proto.Message) (string, error) {
+	if message == nil || !message.ProtoReflect().IsValid() {
+		return "", nil
+	}
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(message)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal audited message: %w", err)
+	}
+	return string(data), nil
+}
+
+// changedFields returns the paths of the populated fields which differ between two messages,
+// down to the fields of the nested messages
+func changedFields(before, after proto.Message) []string {
+	var b, a protoreflect.Message
+	if before != nil && before.ProtoReflect().IsValid() {
+		b = before.ProtoReflect()
+	}
+	if after != nil && after.ProtoReflect().IsValid() {
+		a = after.ProtoReflect()
+	}
+	if b == nil && a == nil {
+		return nil
+	}
+	if b == nil {
+		b = a.Type().Zero()
+	}
+	if a == nil {
+		a = b.Type().Zero()
+	}
+	return appendChangedFields(nil, "", b, a)
+}
+
+func appendChangedFields(paths []string, prefix string, before, after protoreflect.Message) []string {
+	fields := before.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if !before.Has(field) && !after.Has(field) {
+			continue
+		}
+		path := prefix + string(field.Name())
+		if field.Kind() == protoreflect.MessageKind && field.Cardinality() != protoreflect.Repeated &&
+			before.Has(field) && after.Has(field) {
+			paths = appendChangedFields(paths, path+".", before.Get(field).Message(), after.Get(field).Message())
+			continue
+		}
+		if !before.Has(field) || !after.Has(field) || !fieldEqual(field, before, after) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// fieldEqual reports whether a field has the same value in two messages
+func fieldEqual(field protoreflect.FieldDescriptor, before, after protoreflect.Message) bool {
+	b := before.Type().New()
+	b.Set(field, before.Get(field))
+	a := after.Type().New()
+	a.Set(field, after.Get(field))
+	return proto.Equal(b.Interface(), a.Interface())
+}
+
+type InMemoryAuditLog struct {
+	mutex   sync.RWMutex
+	entries []*AuditEntry
+}
+
+func NewInMemoryAuditLog() *InMemoryAuditLog {
+	return &InMemoryAuditLog{}
+}
+
+func (log *InMemoryAuditLog) Append(entry *AuditEntry) error {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	other := *entry
+	log.entries = append(log.entries, &other)
+	return nil
+}
+
+func (log *InMemoryAuditLog) Query(query *AuditQuery) ([]*AuditEntry, error) {
+	log.mutex.RLock()
+	defer log.mutex.RUnlock()
+
+	var entries []*AuditEntry
+	for i := len(log.entries) - 1; i >= 0; i-- {
+		if query.Limit > 0 && len(entries) == query.Limit {
+			break
+		}
+		if query.matches(log.entries[i]) {
+			other := *log.entries[i]
+			entries = append(entries, &other)
+		}
+	}
+	return entries, nil
+}
+
+// FileAuditLog is an in-memory audit log appending its entries to a file, one JSON object per line
+type FileAuditLog struct {
+	*InMemoryAuditLog
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// OpenFileAuditLog reads the entries of a file and appends the next ones to it
+func OpenFileAuditLog(filename string) (*FileAuditLog, error) {
+	log := &FileAuditLog{InMemoryAuditLog: NewInMemoryAuditLog()}
+
+	file, err := os.Open(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cannot open audit log: %w", err)
+	}
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 16<<20)
+		for scanner.Scan() {
+			entry := &AuditEntry{}
+			err = json.Unmarshal(scanner.Bytes(), entry)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse audit entry: %w", err)
+			}
+			log.InMemoryAuditLog.Append(entry)
+		}
+		if scanner.Err() != nil {
+			return nil, fmt.Errorf("cannot read audit log: %w", scanner.Err())
+		}
+	}
+
+	log.file, err = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %w", err)
+	}
+	return log, nil
+}
+
+func (log *FileAuditLog) Append(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("cannot marshal audit entry: %w", err)
+	}
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	_, err = log.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("cannot write audit entry: %w", err)
+	}
+	return log.InMemoryAuditLog.Append(entry)
+}
+
+// Close syncs the file to the disk and closes it
+func (log *FileAuditLog) Close() error {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	err := log.file.Sync()
+	if err != nil {
+		log.file.Close()
+		return fmt.Errorf("cannot sync audit log: %w", err)
+	}
+	return log.file.Close()
+}
@@ -0,0 +1,186 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestServerAuditLaptopChanges(t *testing.T) {
+	t.Parallel()
+
+	auditLog := service.NewInMemoryAuditLog()
+	server := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+	server.AuditLog = auditLog
+	ctx := service.ContextWithIdentity(context.Background(), &service.Identity{Subject: "alice"})
+
+	laptop := sample.NewLaptop()
+	_, err := server.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	updated := proto.Clone(laptop).(*pb.Laptop)
+	updated.PriceUsd++
+	// removing a core keeps the threads at least as many as the cores
+	updated.Cpu.NumberCores--
+	_, err = server.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: updated})
+	require.NoError(t, err)
+
+	_, err = server.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{Id: laptop.Id})
+	require.NoError(t, err)
+
+	// failed calls change nothing, so they are not audited
+	_, err = server.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{Id: laptop.Id})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	entries, err := auditLog.Query(&service.AuditQuery{TargetID: laptop.Id})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	deleted, update, created := entries[0], entries[1], entries[2]
+	for _, entry := range entries {
+		require.Equal(t, "alice", entry.Actor)
+		require.Equal(t, laptop.Id, entry.TargetID)
+	}
+
+	require.Equal(t, "CreateLaptop", created.Method)
+	require.Empty(t, created.Before)
+	requireAuditedLaptop(t, laptop, created.After)
+
+	require.Equal(t, "UpdateLaptop", update.Method)
+	requireAuditedLaptop(t, laptop, update.Before)
+	requireAuditedLaptop(t, updated, update.After)
+	require.Equal(t, []string{"cpu.number_cores", "price_usd"}, update.ChangedFields)
+
+	require.Equal(t, "DeleteLaptop", deleted.Method)
+	requireAuditedLaptop(t, updated, deleted.Before)
+	require.Empty(t, deleted.After)
+}
+
+func requireAuditedLaptop(t *testing.T, expected *pb.Laptop, audited string) {
+	laptop := &pb.Laptop{}
+	require.NoError(t, protojson.Unmarshal([]byte(audited), laptop))
+	requireSameLaptop(t, expected, laptop)
+}
+
+func TestAuditLogQuery(t *testing.T) {
+	t.Parallel()
+
+	auditLog := service.NewInMemoryAuditLog()
+	start := time.Now()
+	appendEntry := func(actor, targetID string, at time.Time) {
+		entry, err := service.NewAuditEntry(actor, "CreateLaptop", targetID, nil, nil)
+		require.NoError(t, err)
+		entry.Time = at
+		require.NoError(t, auditLog.Append(entry))
+	}
+	appendEntry("alice", "1", start)
+	appendEntry("bob", "1", start.Add(time.Minute))
+	appendEntry("alice", "2", start.Add(2*time.Minute))
+
+	testCases := []struct {
+		name    string
+		query   *service.AuditQuery
+		targets []string
+	}{
+		{"all", &service.AuditQuery{}, []string{"2", "1", "1"}},
+		{"actor", &service.AuditQuery{Actor: "alice"}, []string{"2", "1"}},
+		{"target", &service.AuditQuery{TargetID: "1"}, []string{"1", "1"}},
+		{"since", &service.AuditQuery{Since: start.Add(time.Minute)}, []string{"2", "1"}},
+		{"until", &service.AuditQuery{Until: start.Add(time.Minute)}, []string{"1"}},
+		{"limit", &service.AuditQuery{Limit: 1}, []string{"2"}},
+		{"no_match", &service.AuditQuery{Actor: "bob", TargetID: "2"}, nil},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			entries, err := auditLog.Query(tc.query)
+			require.NoError(t, err)
+			var targets []string
+			for _, entry := range entries {
+				targets = append(targets, entry.TargetID)
+			}
+			require.Equal(t, tc.targets, targets)
+		})
+	}
+}
+
+func TestFileAuditLog(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := service.OpenFileAuditLog(filename)
+	require.NoError(t, err)
+
+	laptop := sample.NewLaptop()
+	entry, err := service.NewAuditEntry("alice", "CreateLaptop", laptop.Id, nil, laptop)
+	require.NoError(t, err)
+	require.NoError(t, auditLog.Append(entry))
+	require.NoError(t, auditLog.Close())
+
+	reopened, err := service.OpenFileAuditLog(filename)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	entries, err := reopened.Query(&service.AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, entry.ID, entries[0].ID)
+	require.True(t, entry.Time.Equal(entries[0].Time))
+	require.Equal(t, entry.After, entries[0].After)
+	requireAuditedLaptop(t, laptop, entries[0].After)
+}
+
+func TestAdminServerQueryAuditLog(t *testing.T) {
+	t.Parallel()
+
+	auditLog := service.NewInMemoryAuditLog()
+	adminServer := service.NewAdminServer(service.NewInMemoryFlaggedRatingStore(), service.NewInMemoryRatingScore(0))
+	adminServer.AuditLog = auditLog
+	serverAddr := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterAdminServiceServer(grpcServer, adminServer)
+	})
+	conn, err := grpc.Dial(serverAddr, grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client := pb.NewAdminServiceClient(conn)
+
+	laptop := sample.NewLaptop()
+	entry, err := service.NewAuditEntry("alice", "CreateLaptop", laptop.Id, nil, laptop)
+	require.NoError(t, err)
+	require.NoError(t, auditLog.Append(entry))
+
+	res, err := client.QueryAuditLog(context.Background(), &pb.QueryAuditLogRequest{
+		Actor: "alice",
+		Since: timestamppb.New(entry.Time.Add(-time.Second)),
+	})
+	require.NoError(t, err)
+	require.Len(t, res.GetEntries(), 1)
+	require.Equal(t, entry.ID, res.GetEntries()[0].GetId())
+	require.Equal(t, laptop.Id, res.GetEntries()[0].GetTargetId())
+	require.Equal(t, entry.After, res.GetEntries()[0].GetAfter())
+
+	res, err = client.QueryAuditLog(context.Background(), &pb.QueryAuditLogRequest{Actor: "bob"})
+	require.NoError(t, err)
+	require.Empty(t, res.GetEntries())
+
+	_, err = client.QueryAuditLog(context.Background(), &pb.QueryAuditLogRequest{
+		Since: timestamppb.New(entry.Time),
+		Until: timestamppb.New(entry.Time.Add(-time.Second)),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
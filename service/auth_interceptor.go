@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AuthInterceptor authenticates the caller of every method except the public ones,
+// and checks its role against the roles allowed to call the method.
+// Methods missing from accessibleRoles cannot be called by any role.
+// Callers using an API key need the scope requiredScopes maps the method to instead.
+type AuthInterceptor struct {
+	extractor       IdentityExtractor
+	accessibleRoles map[string][]string
+	requiredScopes  map[string]string
+	publicMethods   map[string]bool
+}
+
+func NewAuthInterceptor(
+	extractor IdentityExtractor,
+	accessibleRoles map[string][]string,
+	requiredScopes map[string]string,
+	publicMethods map[string]bool,
+) *AuthInterceptor {
+	return &AuthInterceptor{
+		extractor:       extractor,
+		accessibleRoles: accessibleRoles,
+		requiredScopes:  requiredScopes,
+		publicMethods:   publicMethods,
+	}
+}
+
+func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, err := interceptor.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := interceptor.authorize(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextServerStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authorize checks the caller may call the method and returns a context carrying its identity
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) (context.Context, error) {
+	if interceptor.publicMethods[method] {
+		return ctx, nil
+	}
+
+	identity, err := interceptor.extractor.Extract(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.APIKeyID != "" {
+		scope, ok := interceptor.requiredScopes[method]
+		if !ok || !containsString(identity.Scopes, scope) {
+			return nil, status.Errorf(
+				codes.PermissionDenied,
+				"%s with scopes %v is not allowed to call %s, required scope: %q",
+				identity.DisplayName(), identity.Scopes, method, scope,
+			)
+		}
+		return ContextWithIdentity(ctx, identity), nil
+	}
+
+	// a method missing from the accessible roles is denied to every role
+	roles, ok := interceptor.accessibleRoles[method]
+	if !ok || !containsString(roles, identity.Role) {
+		return nil, status.Errorf(
+			codes.PermissionDenied,
+			"%s with role %q is not allowed to call %s, allowed roles: %v",
+			identity.DisplayName(), identity.Role, method, roles,
+		)
+	}
+	return ContextWithIdentity(ctx, identity), nil
+}
+
+// LoadAccessibleRoles reads a JSON object mapping full method names to their allowed roles
+func LoadAccessibleRoles(filename string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read accessible roles file: %w", err)
+	}
+
+	accessibleRoles := map[string][]string{}
+	err = json.Unmarshal(data, &accessibleRoles)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse accessible roles file: %w", err)
+	}
+	return accessibleRoles, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// contextServerStream is a server stream with a replaced context
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *contextServerStream) Context() context.Context {
+	return stream.ctx
+}
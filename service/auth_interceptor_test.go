@@ -0,0 +1,218 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+func startTestAuthServer(t *testing.T, jwtManager *service.JWTManager, apiKeyStore service.APIKeyStore) string {
+	userStore := service.NewInMemoryUserStore()
+	for _, role := range []string{"admin", "user"} {
+		user, err := service.NewUser(role+"1", "secret", role)
+		require.NoError(t, err)
+		require.NoError(t, userStore.Save(user))
+	}
+
+	authServer := service.NewAuthServer(userStore, jwtManager)
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+	adminServer := service.NewAdminServer(service.NewInMemoryFlaggedRatingStore(), service.NewInMemoryRatingScore(0))
+	adminServer.APIKeyStore = apiKeyStore
+	interceptor := service.NewAuthInterceptor(
+		service.NewAPIKeyIdentityExtractor(apiKeyStore, service.NewJWTIdentityExtractor(jwtManager)),
+		map[string][]string{
+			"/pcbook.LaptopService/CreateLaptop": {"admin"},
+			"/pcbook.LaptopService/SearchLaptop": {"admin", "user"},
+			"/pcbook.AdminService/CreateAPIKey":  {"admin"},
+			"/pcbook.AdminService/ListAPIKeys":   {"admin"},
+			"/pcbook.AdminService/RevokeAPIKey":  {"admin"},
+		},
+		map[string]string{
+			"/pcbook.LaptopService/CreateLaptop": service.ScopeWrite,
+			"/pcbook.LaptopService/SearchLaptop": service.ScopeRead,
+		},
+		map[string]bool{"/pcbook.AuthService/Login": true},
+	)
+
+	return startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterAuthServiceServer(grpcServer, authServer)
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+		pb.RegisterAdminServiceServer(grpcServer, adminServer)
+	}, grpc.UnaryInterceptor(interceptor.Unary()), grpc.StreamInterceptor(interceptor.Stream()))
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := service.NewJWTManager("secret", time.Minute)
+	serverAddress := startTestAuthServer(t, jwtManager, service.NewInMemoryAPIKeyStore())
+	conn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+	authClient := pb.NewAuthServiceClient(conn)
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	_, err = authClient.Login(context.Background(), &pb.LoginRequest{Username: "admin1", Password: "wrong"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	login, err := authClient.Login(context.Background(), &pb.LoginRequest{Username: "admin1", Password: "secret"})
+	require.NoError(t, err)
+	require.NotEmpty(t, login.GetAccessToken())
+	require.True(t, login.GetExpiresAt().AsTime().After(time.Now()))
+
+	req := &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()}
+	_, err = laptopClient.CreateLaptop(context.Background(), req)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	otherManager := service.NewJWTManager("other", time.Minute)
+	forged, _, err := otherManager.Generate(&service.User{Username: "admin1", Role: "admin"})
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", forged)
+	_, err = laptopClient.CreateLaptop(ctx, req)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", login.GetAccessToken())
+	res, err := laptopClient.CreateLaptop(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, req.GetLaptop().GetId(), res.GetId())
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestJWTManagerExpiredToken(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := service.NewJWTManager("secret", -time.Minute)
+	token, _, err := jwtManager.Generate(&service.User{Username: "user1", Role: "user"})
+	require.NoError(t, err)
+
+	_, err = jwtManager.Verify(token)
+	require.Error(t, err)
+}
+
+func TestAuthInterceptorRoles(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := service.NewJWTManager("secret", time.Minute)
+	serverAddress := startTestAuthServer(t, jwtManager, service.NewInMemoryAPIKeyStore())
+	conn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+	authClient := pb.NewAuthServiceClient(conn)
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	login, err := authClient.Login(context.Background(), &pb.LoginRequest{Username: "user1", Password: "secret"})
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", login.GetAccessToken())
+
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "/pcbook.LaptopService/CreateLaptop")
+
+	stream, err := laptopClient.SearchLaptop(ctx, &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	// a method without accessible roles is denied to every role
+	_, err = laptopClient.GetLaptop(ctx, &pb.GetLaptopRequest{Id: "1"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestHeaderIdentityExtractor(t *testing.T) {
+	t.Parallel()
+
+	extractor := service.NewHeaderIdentityExtractor("x-user-id", "x-user-role")
+
+	_, err := extractor.Extract(context.Background())
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	md := metadata.Pairs("x-user-id", "user1")
+	_, err = extractor.Extract(metadata.NewIncomingContext(context.Background(), md))
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	md = metadata.Pairs("x-user-id", "user1", "x-user-role", "user")
+	identity, err := extractor.Extract(metadata.NewIncomingContext(context.Background(), md))
+	require.NoError(t, err)
+	require.Equal(t, &service.Identity{Subject: "user1", Role: "user"}, identity)
+}
+
+func TestAuthInterceptorAPIKey(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := service.NewJWTManager("secret", time.Minute)
+	apiKeyStore := service.NewInMemoryAPIKeyStore()
+	serverAddress := startTestAuthServer(t, jwtManager, apiKeyStore)
+	conn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+	authClient := pb.NewAuthServiceClient(conn)
+	laptopClient := pb.NewLaptopServiceClient(conn)
+	adminClient := pb.NewAdminServiceClient(conn)
+
+	login, err := authClient.Login(context.Background(), &pb.LoginRequest{Username: "admin1", Password: "secret"})
+	require.NoError(t, err)
+	adminCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", login.GetAccessToken())
+
+	_, err = adminClient.CreateAPIKey(adminCtx, &pb.CreateAPIKeyRequest{Name: "importer", Scopes: []string{"delete"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	created, err := adminClient.CreateAPIKey(adminCtx, &pb.CreateAPIKeyRequest{
+		Name:   "importer",
+		Scopes: []string{service.ScopeRead},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, created.GetKey())
+
+	stored, err := apiKeyStore.FindByHash(service.HashAPIKey(created.GetKey()))
+	require.NoError(t, err)
+	require.NotEqual(t, created.GetKey(), stored.HashedKey)
+
+	list, err := adminClient.ListAPIKeys(adminCtx, &pb.ListAPIKeysRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetApiKeys(), 1)
+	require.Equal(t, created.GetApiKey().GetId(), list.GetApiKeys()[0].GetId())
+
+	// API keys are identified by their id, their names need not be unique
+	extractor := service.NewAPIKeyIdentityExtractor(apiKeyStore, nil)
+	identity, err := extractor.Extract(metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", created.GetKey())))
+	require.NoError(t, err)
+	require.Equal(t, "api-key/"+created.GetApiKey().GetId(), identity.Subject)
+	require.Equal(t, "api-key/importer", identity.DisplayName())
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", created.GetKey())
+	stream, err := laptopClient.SearchLaptop(ctx, &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = adminClient.ListAPIKeys(ctx, &pb.ListAPIKeysRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	revoked, err := adminClient.RevokeAPIKey(adminCtx, &pb.RevokeAPIKeyRequest{Id: created.GetApiKey().GetId()})
+	require.NoError(t, err)
+	require.True(t, revoked.GetApiKey().GetRevoked())
+
+	stream, err = laptopClient.SearchLaptop(ctx, &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "pcb_unknown")
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/neepoo/pcbook/pb"
+)
+
+// AuthServer issues access tokens to users
+type AuthServer struct {
+	UserStore  UserStore
+	JWTManager *JWTManager
+	pb.UnimplementedAuthServiceServer
+}
+
+func NewAuthServer(userStore UserStore, jwtManager *JWTManager) *AuthServer {
+	return &AuthServer{
+		UserStore:  userStore,
+		JWTManager: jwtManager,
+	}
+}
+
+func (server *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	user, err := server.UserStore.Find(req.GetUsername())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+
+	if user == nil || !user.IsCorrectPassword(req.GetPassword()) {
+		return nil, status.Errorf(codes.Unauthenticated, "incorrect username/password")
+	}
+
+	token, expiresAt, err := server.JWTManager.Generate(user)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate access token")
+	}
+
+	res := &pb.LoginResponse{
+		AccessToken: token,
+		ExpiresAt:   timestamppb.New(expiresAt),
+	}
+	return res, nil
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/neepoo/pcbook/money"
+	"github.com/neepoo/pcbook/pb"
+)
+
+var (
+	// ErrNoExchangeRate is returned when a USD price cannot be converted to a currency without rate
+	ErrNoExchangeRate = errors.New("no exchange rate")
+	// ErrInvalidExchangeRate is returned for a rate of an unsupported currency, of USD, or which is negative
+	ErrInvalidExchangeRate = errors.New("invalid exchange rate")
+)
+
+// ExchangeRates is the table of the units of each currency one US dollar buys.
+// It is written back to the file it is loaded from when it is updated.
+type ExchangeRates struct {
+	mutex    sync.RWMutex
+	filename string
+	rates    map[string]float64
+}
+
+// NewExchangeRates returns a table of rates kept in memory
+func NewExchangeRates(rates map[string]float64) (*ExchangeRates, error) {
+	table := &ExchangeRates{rates: make(map[string]float64)}
+	err := table.merge(rates)
+	if err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// LoadExchangeRates reads a table of rates from a JSON object of rates by currency code,
+// like {"EUR": 0.92, "JPY": 147.5}. A missing file is an empty table.
+func LoadExchangeRates(filename string) (*ExchangeRates, error) {
+	table := &ExchangeRates{filename: filename, rates: make(map[string]float64)}
+	data, err := ioutil.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return table, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read exchange rates file: %w", err)
+	}
+
+	var rates map[string]float64
+	err = json.Unmarshal(data, &rates)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse exchange rates file %s: %w", filename, err)
+	}
+	err = table.merge(rates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exchange rates file %s: %w", filename, err)
+	}
+	return table, nil
+}
+
+// Rates returns a copy of the table, with the rate of USD which is always 1
+func (table *ExchangeRates) Rates() map[string]float64 {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+
+	rates := map[string]float64{money.USD: 1}
+	for currency, rate := range table.rates {
+		rates[currency] = rate
+	}
+	return rates
+}
+
+// Update sets the rates of the currencies of rates, and removes the ones whose rate is 0.
+// The table is unchanged if a rate is invalid or the file cannot be written.
+func (table *ExchangeRates) Update(rates map[string]float64) error {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	previous := table.rates
+	table.rates = make(map[string]float64, len(previous))
+	for currency, rate := range previous {
+		table.rates[currency] = rate
+	}
+	err := table.merge(rates)
+	if err == nil && table.filename != "" {
+		err = table.save()
+	}
+	if err != nil {
+		table.rates = previous
+		return err
+	}
+	return nil
+}
+
+// merge checks rates and sets them in the table
+func (table *ExchangeRates) merge(rates map[string]float64) error {
+	for currency, rate := range rates {
+		if !money.IsSupported(currency) {
+			return fmt.Errorf("%w: currency %q is not supported", ErrInvalidExchangeRate, currency)
+		}
+		if currency == money.USD {
+			return fmt.Errorf("%w: the rate of %s is always 1", ErrInvalidExchangeRate, money.USD)
+		}
+		if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+			return fmt.Errorf("%w: the rate of %s must be a positive number, got %g", ErrInvalidExchangeRate, currency, rate)
+		}
+	}
+	for currency, rate := range rates {
+		if rate == 0 {
+			delete(table.rates, currency)
+		} else {
+			table.rates[currency] = rate
+		}
+	}
+	return nil
+}
+
+// save replaces the file of the table atomically
+func (table *ExchangeRates) save() error {
+	data, err := json.MarshalIndent(table.rates, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal exchange rates: %w", err)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(table.filename), filepath.Base(table.filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create exchange rates file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write exchange rates file: %w", err)
+	}
+
+	err = os.Rename(file.Name(), table.filename)
+	if err != nil {
+		return fmt.Errorf("cannot replace exchange rates file: %w", err)
+	}
+	return nil
+}
+
+// Price returns the price of a laptop in currency, the one the laptop has in it,
+// or else its price_usd converted with the rate of the currency. A nil table has no rates.
+func (table *ExchangeRates) Price(laptop *pb.Laptop, currency string) (*pb.Money, error) {
+	for _, price := range laptop.GetPrices() {
+		if price.GetCurrencyCode() == currency {
+			return price, nil
+		}
+	}
+	if currency == money.USD {
+		return money.New(money.USD, laptop.GetPriceUsd())
+	}
+
+	var rate float64
+	if table != nil {
+		table.mutex.RLock()
+		rate = table.rates[currency]
+		table.mutex.RUnlock()
+	}
+	if rate == 0 {
+		return nil, fmt.Errorf("%s: %w", currency, ErrNoExchangeRate)
+	}
+	return money.New(currency, laptop.GetPriceUsd()*rate)
+}
@@ -0,0 +1,144 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestExchangeRates(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "rates.json")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"EUR": 0.5}`), 0644))
+	rates, err := service.LoadExchangeRates(filename)
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"USD": 1, "EUR": 0.5}, rates.Rates())
+
+	laptop := sample.NewLaptop()
+	laptop.PriceUsd = 1000
+	laptop.Prices = []*pb.Money{{CurrencyCode: "GBP", MinorUnits: 70000}}
+	price, err := rates.Price(laptop, "EUR")
+	require.NoError(t, err)
+	require.Equal(t, int64(50000), price.GetMinorUnits())
+	price, err = rates.Price(laptop, "GBP")
+	require.NoError(t, err)
+	require.Equal(t, int64(70000), price.GetMinorUnits())
+	price, err = rates.Price(laptop, "USD")
+	require.NoError(t, err)
+	require.Equal(t, int64(100000), price.GetMinorUnits())
+	_, err = rates.Price(laptop, "JPY")
+	require.ErrorIs(t, err, service.ErrNoExchangeRate)
+
+	require.NoError(t, rates.Update(map[string]float64{"JPY": 150, "EUR": 0}))
+	require.Equal(t, map[string]float64{"USD": 1, "JPY": 150}, rates.Rates())
+	err = rates.Update(map[string]float64{"GBP": 0.8, "USD": 2})
+	require.ErrorIs(t, err, service.ErrInvalidExchangeRate)
+	err = rates.Update(map[string]float64{"XXX": 2})
+	require.ErrorIs(t, err, service.ErrInvalidExchangeRate)
+	err = rates.Update(map[string]float64{"GBP": -1})
+	require.ErrorIs(t, err, service.ErrInvalidExchangeRate)
+
+	// the table is unchanged by a failed update and saved by the others
+	reloaded, err := service.LoadExchangeRates(filename)
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"USD": 1, "JPY": 150}, reloaded.Rates())
+
+	missing, err := service.LoadExchangeRates(filepath.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"USD": 1}, missing.Rates())
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"USD": 2}`), 0644))
+	_, err = service.LoadExchangeRates(filename)
+	require.ErrorIs(t, err, service.ErrInvalidExchangeRate)
+}
+
+func TestClientSearchLaptopByPrice(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	rates, err := service.NewExchangeRates(map[string]float64{"EUR": 0.5})
+	require.NoError(t, err)
+	laptopServer := service.NewLaptopServer(store, nil, nil)
+	laptopServer.ExchangeRates = rates
+	adminServer := service.NewAdminServer(service.NewInMemoryFlaggedRatingStore(), service.NewInMemoryRatingScore(0))
+	adminServer.ExchangeRates = rates
+	serverAddr := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+		pb.RegisterAdminServiceServer(grpcServer, adminServer)
+	})
+	conn, err := grpc.Dial(serverAddr, grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	laptopClient := pb.NewLaptopServiceClient(conn)
+	adminClient := pb.NewAdminServiceClient(conn)
+
+	laptops := make([]*pb.Laptop, 3)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		laptops[i].PriceUsd = 3000
+	}
+	laptops[0].PriceUsd = 1000
+	laptops[1].Prices = []*pb.Money{{CurrencyCode: "EUR", MinorUnits: 90000}}
+	for _, laptop := range laptops {
+		_, err := laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+		require.NoError(t, err)
+	}
+
+	search := func(maxPrice *pb.Money) ([]string, error) {
+		stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+			Filter: &pb.Filter{MaxPrice: maxPrice},
+		})
+		require.NoError(t, err)
+		var ids []string
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return ids, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, res.GetLaptop().GetId())
+		}
+	}
+
+	ids, err := search(&pb.Money{CurrencyCode: "EUR", MinorUnits: 100000})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{laptops[0].GetId(), laptops[1].GetId()}, ids)
+	// there is no rate for JPY
+	ids, err = search(&pb.Money{CurrencyCode: "JPY", MinorUnits: 1000000})
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	res, err := adminClient.UpdateExchangeRates(context.Background(), &pb.UpdateExchangeRatesRequest{
+		Rates: map[string]float64{"EUR": 0.2, "JPY": 150},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"USD": 1, "EUR": 0.2, "JPY": 150}, res.GetRates())
+
+	ids, err = search(&pb.Money{CurrencyCode: "EUR", MinorUnits: 100000})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{laptops[0].GetId(), laptops[1].GetId(), laptops[2].GetId()}, ids)
+	ids, err = search(&pb.Money{CurrencyCode: "JPY", MinorUnits: 150000})
+	require.NoError(t, err)
+	require.Equal(t, []string{laptops[0].GetId()}, ids)
+
+	_, err = search(&pb.Money{CurrencyCode: "XXX", MinorUnits: 100})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = adminClient.UpdateExchangeRates(context.Background(), &pb.UpdateExchangeRatesRequest{
+		Rates: map[string]float64{"USD": 2},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/neepoo/pcbook/pb"
+)
+
+// FileLaptopStore is an in-memory laptop store persisted to a snapshot file.
+// The snapshot is a sequence of length-prefixed binary laptops,
+// written on Flush and Close and read back by Load.
+type FileLaptopStore struct {
+	*InMemoryLaptopStore
+	filename string
+
+	mutex sync.Mutex
+	dirty bool
+}
+
+func NewFileLaptopStore(filename string) *FileLaptopStore {
+	return &FileLaptopStore{
+		InMemoryLaptopStore: NewInMemoryLaptopStore(),
+		filename:            filename,
+	}
+}
+
+func (store *FileLaptopStore) Save(laptop *pb.Laptop) error {
+	err := store.InMemoryLaptopStore.Save(laptop)
+	if err != nil {
+		return err
+	}
+	store.markDirty()
+	return nil
+}
+
+func (store *FileLaptopStore) SaveAll(laptops []*pb.Laptop) error {
+	err := store.InMemoryLaptopStore.SaveAll(laptops)
+	if err != nil {
+		return err
+	}
+	store.markDirty()
+	return nil
+}
+
+func (store *FileLaptopStore) Update(laptop *pb.Laptop) (*pb.Laptop, error) {
+	previous, err := store.InMemoryLaptopStore.Update(laptop)
+	if err != nil {
+		return nil, err
+	}
+	store.markDirty()
+	return previous, nil
+}
+
+func (store *FileLaptopStore) Delete(id string) (*pb.Laptop, error) {
+	laptop, err := store.InMemoryLaptopStore.Delete(id)
+	if err != nil {
+		return nil, err
+	}
+	store.markDirty()
+	return laptop, nil
+}
+
+func (store *FileLaptopStore) markDirty() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.dirty = true
+}
+
+// Load reads the laptops of the snapshot file into the store, a missing file is an empty store
+func (store *FileLaptopStore) Load() error {
+	file, err := os.Open(store.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open laptop store file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		size, err := binary.ReadUvarint(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read laptop size: %w", err)
+		}
+
+		data := make([]byte, size)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return fmt.Errorf("cannot read laptop: %w", err)
+		}
+
+		laptop := &pb.Laptop{}
+		err = proto.Unmarshal(data, laptop)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal laptop: %w", err)
+		}
+		err = store.InMemoryLaptopStore.Save(laptop)
+		if err != nil {
+			return fmt.Errorf("cannot load laptop %s: %w", laptop.GetId(), err)
+		}
+	}
+}
+
+// Flush writes the laptops to the snapshot file if they changed since the last flush.
+// The file is replaced atomically, so a crash never leaves a partial snapshot.
+func (store *FileLaptopStore) Flush() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if !store.dirty {
+		return nil
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(store.filename), filepath.Base(store.filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create laptop store file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	err = store.writeLaptops(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write laptop store file: %w", err)
+	}
+
+	err = os.Rename(file.Name(), store.filename)
+	if err != nil {
+		return fmt.Errorf("cannot replace laptop store file: %w", err)
+	}
+	store.dirty = false
+	return nil
+}
+
+func (store *FileLaptopStore) writeLaptops(writer io.Writer) error {
+	store.InMemoryLaptopStore.mutex.RLock()
+	defer store.InMemoryLaptopStore.mutex.RUnlock()
+
+	sizeBuffer := make([]byte, binary.MaxVarintLen64)
+	for _, laptop := range store.data {
+		data, err := proto.Marshal(laptop)
+		if err != nil {
+			return err
+		}
+		n := binary.PutUvarint(sizeBuffer, uint64(len(data)))
+		_, err = writer.Write(sizeBuffer[:n])
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the laptops to the snapshot file
+func (store *FileLaptopStore) Close() error {
+	return store.Flush()
+}
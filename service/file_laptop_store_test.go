@@ -0,0 +1,117 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestFileLaptopStore(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "laptops.bin")
+	store := service.NewFileLaptopStore(filename)
+	require.NoError(t, store.Load())
+	require.Equal(t, 0, store.Count())
+
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	for _, laptop := range laptops {
+		require.NoError(t, store.Save(laptop))
+	}
+	require.ErrorIs(t, store.Save(laptops[0]), service.ErrAlreadyExists)
+	require.NoError(t, store.Close())
+
+	other := service.NewFileLaptopStore(filename)
+	require.NoError(t, other.Load())
+	require.Equal(t, len(laptops), other.Count())
+	for _, laptop := range laptops {
+		found, err := other.Find(laptop.GetId())
+		require.NoError(t, err)
+		requireSameLaptop(t, laptop, found)
+	}
+
+	count := 0
+	err := other.Search(context.Background(), &pb.Filter{MaxPriceUsd: 1e6}, func(laptop *pb.Laptop) error {
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(laptops), count)
+
+	var ids []string
+	err = other.ForEach(context.Background(), func(laptop *pb.Laptop) error {
+		ids = append(ids, laptop.GetId())
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{laptops[0].GetId(), laptops[1].GetId(), laptops[2].GetId()}, ids)
+	errStop := errors.New("stop")
+	count = 0
+	err = other.ForEach(context.Background(), func(laptop *pb.Laptop) error {
+		count++
+		return errStop
+	})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, 1, count)
+
+	updated := proto.Clone(laptops[1]).(*pb.Laptop)
+	updated.PriceUsd++
+	previous, err := other.Update(updated)
+	require.NoError(t, err)
+	require.Equal(t, laptops[1].GetPriceUsd(), previous.GetPriceUsd())
+	deleted, err := other.Delete(laptops[2].GetId())
+	require.NoError(t, err)
+	require.Equal(t, laptops[2].GetId(), deleted.GetId())
+	_, err = other.Delete(laptops[2].GetId())
+	require.ErrorIs(t, err, service.ErrNotFound)
+	_, err = other.Update(laptops[2])
+	require.ErrorIs(t, err, service.ErrNotFound)
+
+	added := sample.NewLaptop()
+	err = other.SaveAll([]*pb.Laptop{added, laptops[0], added})
+	var batchErr *service.BatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Len(t, batchErr.Errors, 2)
+	require.ErrorIs(t, batchErr.Errors[1], service.ErrAlreadyExists)
+	require.ErrorIs(t, batchErr.Errors[2], service.ErrAlreadyExists)
+	require.Equal(t, 2, other.Count())
+	require.NoError(t, other.SaveAll([]*pb.Laptop{added}))
+	require.NoError(t, other.Close())
+
+	reloaded := service.NewFileLaptopStore(filename)
+	require.NoError(t, reloaded.Load())
+	require.Equal(t, 3, reloaded.Count())
+	found, err := reloaded.Find(updated.GetId())
+	require.NoError(t, err)
+	require.Equal(t, updated.GetPriceUsd(), found.GetPriceUsd())
+}
+
+func TestFileLaptopStoreCorrupted(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "laptops.bin")
+	require.NoError(t, ioutil.WriteFile(filename, []byte{0x10, 0x01}, 0644))
+
+	store := service.NewFileLaptopStore(filename)
+	require.Error(t, store.Load())
+}
+
+func TestDiskImageStoreClose(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, store.Close())
+
+	_, err := store.Save("laptop", ".jpg", *bytes.NewBufferString("image"))
+	require.ErrorIs(t, err, service.ErrClosed)
+}
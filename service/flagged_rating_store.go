@@ -0,0 +1,80 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNotFound = errors.New("record not found")
+
+// FlaggedRating is a rating held back for review instead of being counted
+type FlaggedRating struct {
+	ID       string
+	LaptopID string
+	Source   string
+	Score    float64
+	Reason   string
+	Time     time.Time
+}
+
+type FlaggedRatingStore interface {
+	Save(rating *FlaggedRating) error
+	List() ([]*FlaggedRating, error)
+	Remove(id string) (*FlaggedRating, error)
+}
+
+type InMemoryFlaggedRatingStore struct {
+	mutex   sync.RWMutex
+	ratings map[string]*FlaggedRating
+}
+
+func NewInMemoryFlaggedRatingStore() *InMemoryFlaggedRatingStore {
+	return &InMemoryFlaggedRatingStore{ratings: map[string]*FlaggedRating{}}
+}
+
+func (store *InMemoryFlaggedRatingStore) Save(rating *FlaggedRating) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if rating.ID == "" {
+		rating.ID = uuid.NewString()
+	}
+	if store.ratings[rating.ID] != nil {
+		return ErrAlreadyExists
+	}
+	other := *rating
+	store.ratings[rating.ID] = &other
+	return nil
+}
+
+// List returns the flagged ratings, oldest first
+func (store *InMemoryFlaggedRatingStore) List() ([]*FlaggedRating, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	ratings := make([]*FlaggedRating, 0, len(store.ratings))
+	for _, rating := range store.ratings {
+		other := *rating
+		ratings = append(ratings, &other)
+	}
+	sort.Slice(ratings, func(i, j int) bool {
+		return ratings[i].Time.Before(ratings[j].Time)
+	})
+	return ratings, nil
+}
+
+func (store *InMemoryFlaggedRatingStore) Remove(id string) (*FlaggedRating, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	rating := store.ratings[id]
+	if rating == nil {
+		return nil, ErrNotFound
+	}
+	delete(store.ratings, id)
+	return rating, nil
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HealthReporter reports the serving status of each service to the standard health service.
+// The server as a whole, the empty service name, is serving only when all its services are.
+// Its interceptors reject the calls to a service which is not serving.
+type HealthReporter struct {
+	server  *health.Server
+	mutex   sync.RWMutex
+	serving map[string]bool
+}
+
+// NewHealthReporter returns a health reporter with all the services not serving
+func NewHealthReporter(server *health.Server, services ...string) *HealthReporter {
+	reporter := &HealthReporter{
+		server:  server,
+		serving: map[string]bool{},
+	}
+	for _, service := range services {
+		reporter.serving[service] = false
+		server.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return reporter
+}
+
+// SetServing updates the status of a service and of the whole server
+func (reporter *HealthReporter) SetServing(service string, serving bool) {
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+
+	reporter.serving[service] = serving
+	reporter.server.SetServingStatus(service, servingStatus(serving))
+
+	all := true
+	for _, s := range reporter.serving {
+		all = all && s
+	}
+	reporter.server.SetServingStatus("", servingStatus(all))
+}
+
+// SetAllServing updates the status of all the services
+func (reporter *HealthReporter) SetAllServing(serving bool) {
+	reporter.mutex.RLock()
+	services := make([]string, 0, len(reporter.serving))
+	for service := range reporter.serving {
+		services = append(services, service)
+	}
+	reporter.mutex.RUnlock()
+
+	for _, service := range services {
+		reporter.SetServing(service, serving)
+	}
+}
+
+// IsServing reports whether a service is serving, services not tracked by the reporter always are
+func (reporter *HealthReporter) IsServing(service string) bool {
+	reporter.mutex.RLock()
+	defer reporter.mutex.RUnlock()
+
+	serving, ok := reporter.serving[service]
+	return serving || !ok
+}
+
+func (reporter *HealthReporter) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		err := reporter.checkServing(info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (reporter *HealthReporter) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		err := reporter.checkServing(info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func (reporter *HealthReporter) checkServing(method string) error {
+	service := serviceName(method)
+	if !reporter.IsServing(service) {
+		return status.Errorf(codes.Unavailable, "%s is not serving", service)
+	}
+	return nil
+}
+
+// serviceName returns the service of a full method name such as /pcbook.LaptopService/CreateLaptop
+func serviceName(method string) string {
+	method = strings.TrimPrefix(method, "/")
+	if i := strings.LastIndex(method, "/"); i >= 0 {
+		return method[:i]
+	}
+	return method
+}
+
+func servingStatus(serving bool) healthpb.HealthCheckResponse_ServingStatus {
+	if serving {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
@@ -0,0 +1,62 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestHealthReporter(t *testing.T) {
+	t.Parallel()
+
+	healthServer := health.NewServer()
+	reporter := service.NewHealthReporter(healthServer, "pcbook.LaptopService", "pcbook.AdminService")
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+	serverAddress := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+		healthpb.RegisterHealthServer(grpcServer, healthServer)
+	}, grpc.UnaryInterceptor(reporter.Unary()), grpc.StreamInterceptor(reporter.Stream()))
+
+	conn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+	healthClient := healthpb.NewHealthClient(conn)
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	requireStatus := func(service string, expected healthpb.HealthCheckResponse_ServingStatus) {
+		res, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		require.Equal(t, expected, res.GetStatus())
+	}
+
+	requireStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus("pcbook.LaptopService", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	req := &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()}
+	_, err = laptopClient.CreateLaptop(context.Background(), req)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	reporter.SetServing("pcbook.LaptopService", true)
+	requireStatus("pcbook.LaptopService", healthpb.HealthCheckResponse_SERVING)
+	requireStatus("pcbook.AdminService", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	_, err = laptopClient.CreateLaptop(context.Background(), req)
+	require.NoError(t, err)
+
+	reporter.SetAllServing(true)
+	requireStatus("", healthpb.HealthCheckResponse_SERVING)
+
+	reporter.SetAllServing(false)
+	requireStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus("pcbook.LaptopService", healthpb.HealthCheckResponse_NOT_SERVING)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// IdempotencyKeyHeader is the metadata key of the idempotency key of a call
+	IdempotencyKeyHeader = "idempotency-key"
+	// IdempotentReplayHeader is set to true in the headers of a call whose outcome is replayed
+	IdempotentReplayHeader = "idempotent-replay"
+	// MaxIdempotencyKeyLength is the max length of an idempotency key
+	MaxIdempotencyKeyLength = 255
+)
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is used again for a different request
+var ErrIdempotencyKeyReused = errors.New("idempotency key is already used for a different request")
+
+// idempotentCall is the outcome of the first call made with an idempotency key
+type idempotentCall struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	response    proto.Message
+	err         error
+	expiresAt   time.Time
+}
+
+// IdempotencyStore remembers the outcome of the calls made with an idempotency key
+// to return it to the calls repeated with the same key
+type IdempotencyStore struct {
+	mutex sync.Mutex
+	ttl   time.Duration
+	calls map[string]*idempotentCall
+	// queue is in the order of the calls, which is the order they expire in
+	queue []queuedCall
+}
+
+type queuedCall struct {
+	key  string
+	call *idempotentCall
+}
+
+// NewIdempotencyStore returns a store remembering the outcome of a call for ttl after it is made
+func NewIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		ttl:   ttl,
+		calls: map[string]*idempotentCall{},
+	}
+}
+
+// Do runs call for the first request made with key, and returns its outcome to the next requests
+// made with key until it expires. A request repeated while call runs waits for its outcome.
+// The outcome of a failure worth retrying is forgotten so that the next request runs call again,
+// including the requests waiting for it.
+// replayed reports whether the outcome is the one of a previous request.
+func (store *IdempotencyStore) Do(
+	ctx context.Context,
+	key string,
+	fingerprint [sha256.Size]byte,
+	call func() (proto.Message, error),
+) (response proto.Message, replayed bool, err error) {
+	for {
+		store.mutex.Lock()
+		store.removeExpired(time.Now())
+		previous := store.calls[key]
+		if previous == nil {
+			break
+		}
+		store.mutex.Unlock()
+		if previous.fingerprint != fingerprint {
+			return nil, false, ErrIdempotencyKeyReused
+		}
+		select {
+		case <-previous.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		// the outcome is forgotten, e.g. the previous request is canceled,
+		// so this request runs call unless another one claims the key first
+		if !rememberOutcome(previous.err) {
+			continue
+		}
+		if previous.response != nil {
+			response = proto.Clone(previous.response)
+		}
+		return response, true, previous.err
+	}
+
+	current := &idempotentCall{
+		fingerprint: fingerprint,
+		done:        make(chan struct{}),
+		expiresAt:   time.Now().Add(store.ttl),
+	}
+	store.calls[key] = current
+	store.queue = append(store.queue, queuedCall{key: key, call: current})
+	store.mutex.Unlock()
+
+	response, err = call()
+
+	store.mutex.Lock()
+	if response != nil {
+		current.response = proto.Clone(response)
+	}
+	current.err = err
+	if !rememberOutcome(err) {
+		delete(store.calls, key)
+	}
+	store.mutex.Unlock()
+	close(current.done)
+	return response, false, err
+}
+
+// removeExpired must be called with the mutex locked
+func (store *IdempotencyStore) removeExpired(now time.Time) {
+	i := 0
+	for ; i < len(store.queue) && !now.Before(store.queue[i].call.expiresAt); i++ {
+		queued := store.queue[i]
+		// the call may be forgotten, and the key used again since
+		if store.calls[queued.key] == queued.call {
+			delete(store.calls, queued.key)
+		}
+	}
+	store.queue = store.queue[i:]
+}
+
+// rememberOutcome reports whether the outcome of a call is the same if it is repeated
+func rememberOutcome(err error) bool {
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Unknown, codes.Internal,
+		codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,142 @@
+package service_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewIdempotencyStore(time.Hour)
+	ctx := context.Background()
+	fingerprint := sha256.Sum256([]byte("request"))
+	calls := 0
+	call := func(code codes.Code) func() (proto.Message, error) {
+		return func() (proto.Message, error) {
+			calls++
+			if code != codes.OK {
+				return nil, status.Errorf(code, "failed")
+			}
+			return &pb.CreateLaptopResponse{Id: "1"}, nil
+		}
+	}
+
+	res, replayed, err := store.Do(ctx, "created", fingerprint, call(codes.OK))
+	require.NoError(t, err)
+	require.False(t, replayed)
+	res, replayed, err = store.Do(ctx, "created", fingerprint, call(codes.OK))
+	require.NoError(t, err)
+	require.True(t, replayed)
+	require.Equal(t, "1", res.(*pb.CreateLaptopResponse).GetId())
+	require.Equal(t, 1, calls)
+
+	_, _, err = store.Do(ctx, "created", sha256.Sum256([]byte("other")), call(codes.OK))
+	require.ErrorIs(t, err, service.ErrIdempotencyKeyReused)
+	require.Equal(t, 1, calls)
+
+	// a failure is replayed unless it is worth retrying
+	_, _, err = store.Do(ctx, "invalid", fingerprint, call(codes.InvalidArgument))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, replayed, err = store.Do(ctx, "invalid", fingerprint, call(codes.OK))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.True(t, replayed)
+	require.Equal(t, 2, calls)
+
+	_, _, err = store.Do(ctx, "unavailable", fingerprint, call(codes.Unavailable))
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	_, replayed, err = store.Do(ctx, "unavailable", fingerprint, call(codes.OK))
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.Equal(t, 4, calls)
+}
+
+func TestIdempotencyStoreWaitsForCall(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewIdempotencyStore(time.Hour)
+	fingerprint := sha256.Sum256([]byte("request"))
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	go store.Do(context.Background(), "key", fingerprint, func() (proto.Message, error) {
+		close(started)
+		<-finish
+		return &pb.CreateLaptopResponse{Id: "1"}, nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err := store.Do(ctx, "key", fingerprint, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(finish)
+	res, replayed, err := store.Do(context.Background(), "key", fingerprint, nil)
+	require.NoError(t, err)
+	require.True(t, replayed)
+	require.Equal(t, "1", res.(*pb.CreateLaptopResponse).GetId())
+}
+
+func TestIdempotencyStoreWaiterRunsForgottenCall(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewIdempotencyStore(time.Hour)
+	fingerprint := sha256.Sum256([]byte("request"))
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	go store.Do(context.Background(), "key", fingerprint, func() (proto.Message, error) {
+		close(started)
+		<-finish
+		return nil, status.Error(codes.Canceled, "first caller is gone")
+	})
+	<-started
+
+	type outcome struct {
+		res      proto.Message
+		replayed bool
+		err      error
+	}
+	waited := make(chan outcome)
+	go func() {
+		res, replayed, err := store.Do(context.Background(), "key", fingerprint, func() (proto.Message, error) {
+			return &pb.CreateLaptopResponse{Id: "2"}, nil
+		})
+		waited <- outcome{res, replayed, err}
+	}()
+	// let the second request wait for the first one
+	time.Sleep(10 * time.Millisecond)
+	close(finish)
+
+	// the canceled outcome is not remembered, so the waiting request runs its own call
+	result := <-waited
+	require.NoError(t, result.err)
+	require.False(t, result.replayed)
+	require.Equal(t, "2", result.res.(*pb.CreateLaptopResponse).GetId())
+}
+
+func TestIdempotencyStoreExpires(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewIdempotencyStore(time.Millisecond)
+	fingerprint := sha256.Sum256([]byte("request"))
+	call := func() (proto.Message, error) {
+		return &pb.CreateLaptopResponse{}, nil
+	}
+
+	_, _, err := store.Do(context.Background(), "key", fingerprint, call)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, replayed, err := store.Do(context.Background(), "key", fingerprint, call)
+	require.NoError(t, err)
+	require.False(t, replayed)
+}
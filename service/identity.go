@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Identity is the authenticated caller of an RPC.
+// Callers using an API key have an APIKeyID and are limited by their scopes instead of a role.
+type Identity struct {
+	// Subject uniquely identifies the caller, such as the owner of its ratings, changes and alerts
+	Subject string
+	// Name is how the caller is displayed if it differs from Subject, like the name of an API key
+	Name     string
+	Role     string
+	APIKeyID string
+	Scopes   []string
+}
+
+// DisplayName returns the name of the caller, or its subject if it has none
+func (identity *Identity) DisplayName() string {
+	if identity.Name != "" {
+		return identity.Name
+	}
+	return identity.Subject
+}
+
+// IdentityExtractor finds the identity of the caller in the context of an RPC
+type IdentityExtractor interface {
+	Extract(ctx context.Context) (*Identity, error)
+}
+
+// JWTIdentityExtractor reads the identity from the access token in the authorization metadata
+type JWTIdentityExtractor struct {
+	jwtManager *JWTManager
+}
+
+func NewJWTIdentityExtractor(jwtManager *JWTManager) *JWTIdentityExtractor {
+	return &JWTIdentityExtractor{jwtManager: jwtManager}
+}
+
+func (extractor *JWTIdentityExtractor) Extract(ctx context.Context) (*Identity, error) {
+	accessToken, err := metadataValue(ctx, "authorization")
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := extractor.jwtManager.Verify(accessToken)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
+	}
+	return &Identity{Subject: claims.Username, Role: claims.Role}, nil
+}
+
+// HeaderIdentityExtractor trusts the identity set in the metadata by a proxy in front of the server.
+// Only use it when clients cannot reach the server directly.
+type HeaderIdentityExtractor struct {
+	SubjectHeader string
+	RoleHeader    string
+}
+
+func NewHeaderIdentityExtractor(subjectHeader, roleHeader string) *HeaderIdentityExtractor {
+	return &HeaderIdentityExtractor{SubjectHeader: subjectHeader, RoleHeader: roleHeader}
+}
+
+func (extractor *HeaderIdentityExtractor) Extract(ctx context.Context) (*Identity, error) {
+	subject, err := metadataValue(ctx, extractor.SubjectHeader)
+	if err != nil {
+		return nil, err
+	}
+	role, err := metadataValue(ctx, extractor.RoleHeader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Subject: subject, Role: role}, nil
+}
+
+// APIKeyIdentityExtractor reads the identity from the API key in the x-api-key metadata,
+// and falls back to next when the call has no API key
+type APIKeyIdentityExtractor struct {
+	apiKeyStore APIKeyStore
+	next        IdentityExtractor
+}
+
+func NewAPIKeyIdentityExtractor(apiKeyStore APIKeyStore, next IdentityExtractor) *APIKeyIdentityExtractor {
+	return &APIKeyIdentityExtractor{apiKeyStore: apiKeyStore, next: next}
+}
+
+func (extractor *APIKeyIdentityExtractor) Extract(ctx context.Context) (*Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md["x-api-key"]) == 0 {
+		return extractor.next.Extract(ctx)
+	}
+
+	apiKey, err := extractor.apiKeyStore.FindByHash(HashAPIKey(md["x-api-key"][0]))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find API key: %v", err)
+	}
+	if apiKey == nil || apiKey.Revoked {
+		return nil, status.Errorf(codes.Unauthenticated, "API key is invalid")
+	}
+	return &Identity{
+		Subject:  "api-key/" + apiKey.ID,
+		Name:     "api-key/" + apiKey.Name,
+		APIKeyID: apiKey.ID,
+		Scopes:   apiKey.Scopes,
+	}, nil
+}
+
+func metadataValue(ctx context.Context, key string) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", status.Errorf(codes.Unauthenticated, "metadata is not provided")
+	}
+
+	values := md[key]
+	if len(values) == 0 || values[0] == "" {
+		return "", status.Errorf(codes.Unauthenticated, "%s is not provided", key)
+	}
+	return values[0], nil
+}
+
+type identityKey struct{}
+
+// ContextWithIdentity returns a copy of ctx carrying the identity of the caller
+func ContextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity of the caller, or nil if the call is not authenticated
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
@@ -2,9 +2,10 @@ package service
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"log"
+	"github.com/neepoo/pcbook/logging"
 	"os"
 	"path/filepath"
 	"sync"
@@ -14,28 +15,46 @@ type ImageStore interface {
 	Save(laptopID string, imageType string, imageData bytes.Buffer) (string, error)
 }
 
+var ErrClosed = errors.New("store is closed")
+
 type DiskImageStore struct {
 	mutex       sync.RWMutex
 	imageFolder string
 	images      map[string]*ImageInfo
+	totalSize   int64
+	closed      bool
+	saving      sync.WaitGroup
 }
 func (d *DiskImageStore) Save(laptopID string, imageType string, imageData bytes.Buffer) (string, error) {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		return "", ErrClosed
+	}
+	d.saving.Add(1)
+	d.mutex.Unlock()
+	defer d.saving.Done()
+
 	imageID, err := uuid.NewRandom()
 	if err != nil {
 		return "", fmt.Errorf("cannot genearate uuid %v", err)
 	}
 	imagePath := filepath.Join(d.imageFolder, imageID.String()+imageType)
 	//imagePath := fmt.Sprintf("%s/%s%s", d.imageFolder, imageID, imageType)
-	log.Println(d.imageFolder, imageID, imageType, imagePath)
+	logging.Default().Debug("saving image", "laptop_id", laptopID, "image_id", imageID, "path", imagePath)
 	file, err := os.Create(imagePath)
 	if err != nil {
 		return "", fmt.Errorf("cannot create image file")
 	}
 	defer file.Close()
-	_, err = imageData.WriteTo(file)
+	size, err := imageData.WriteTo(file)
 	if err != nil{
 		return "", fmt.Errorf("cannot write image to file :%v", err)
 	}
+	err = file.Sync()
+	if err != nil {
+		return "", fmt.Errorf("cannot sync image file: %v", err)
+	}
 
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
@@ -44,10 +63,29 @@ func (d *DiskImageStore) Save(laptopID string, imageType string, imageData bytes
 		LaptopID: laptopID,
 		Type:     imageType,
 		Path:     imagePath,
+		Size:     size,
 	}
+	d.totalSize += size
 	return imageID.String(), nil
 }
 
+// Close waits for the images being saved and rejects new ones
+func (d *DiskImageStore) Close() error {
+	d.mutex.Lock()
+	d.closed = true
+	d.mutex.Unlock()
+
+	d.saving.Wait()
+	return nil
+}
+
+// TotalSize returns the number of bytes of all saved images
+func (d *DiskImageStore) TotalSize() int64 {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.totalSize
+}
+
 func NewDiskImageStore(imageFolder string) *DiskImageStore {
 	return &DiskImageStore{
 		imageFolder: imageFolder,
@@ -59,4 +97,5 @@ type ImageInfo struct {
 	LaptopID string
 	Type     string
 	Path     string
+	Size     int64
 }
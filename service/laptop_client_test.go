@@ -8,15 +8,24 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 
 	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/rpcerror"
 	"github.com/neepoo/pcbook/sample"
 	"github.com/neepoo/pcbook/service"
+	"github.com/neepoo/pcbook/units"
 )
 
 func requireSameLaptop(t *testing.T, l1, l2 *pb.Laptop) {
@@ -53,6 +62,240 @@ func TestClientCreateLaptop(t *testing.T) {
 
 }
 
+func TestClientCreateLaptopIdempotent(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopServer := service.NewLaptopServer(laptopStore, nil, nil)
+	laptopServer.Idempotency = service.NewIdempotencyStore(time.Hour)
+	serverAddr := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	})
+	laptopClient := newLaptopClient(t, serverAddr)
+
+	// the server generates the id of the laptop, so only the key prevents duplicates
+	laptop := sample.NewLaptop()
+	laptop.Id = ""
+	req := &pb.CreateLaptopRequest{Laptop: laptop}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), service.IdempotencyKeyHeader, "create-1")
+
+	var header metadata.MD
+	first, err := laptopClient.CreateLaptop(ctx, req, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Empty(t, header.Get(service.IdempotentReplayHeader))
+
+	second, err := laptopClient.CreateLaptop(ctx, req, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Equal(t, first.GetId(), second.GetId())
+	require.Equal(t, []string{"true"}, header.Get(service.IdempotentReplayHeader))
+	require.Equal(t, 1, laptopStore.Count())
+
+	other := sample.NewLaptop()
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: other})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = laptopClient.CreateLaptop(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, 2, laptopStore.Count())
+}
+
+func TestClientGetLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddr := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newLaptopClient(t, serverAddr)
+
+	res, err := laptopClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptop.GetId()})
+	require.NoError(t, err)
+	requireSameLaptop(t, laptop, res.GetLaptop())
+
+	_, err = laptopClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Equal(t, rpcerror.ReasonLaptopNotFound, rpcerror.Reason(err))
+	details := status.Convert(err).Details()
+	require.Len(t, details, 2)
+	resource, ok := details[1].(*errdetails.ResourceInfo)
+	require.True(t, ok)
+	require.Equal(t, rpcerror.ResourceLaptop, resource.GetResourceType())
+	require.Equal(t, "unknown", resource.GetResourceName())
+}
+
+func TestClientBatchCreateLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	existing := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(existing))
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, nil)
+	laptopServer.BatchChunkSize = 2
+	laptopServer.BatchWriters = service.NewSemaphore(1)
+	serverAddr := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	})
+	laptopClient := newLaptopClient(t, serverAddr)
+
+	invalid := sample.NewLaptop()
+	invalid.Id = "xyz"
+	noID := sample.NewLaptop()
+	noID.Id = ""
+	valid := sample.NewLaptop()
+
+	batch := func(mode pb.BatchCreateLaptopsRequest_Mode, laptops ...*pb.Laptop) ([]*pb.BatchCreateLaptopsResponse, error) {
+		stream, err := laptopClient.BatchCreateLaptops(context.Background())
+		require.NoError(t, err)
+		for _, laptop := range laptops {
+			require.NoError(t, stream.Send(&pb.BatchCreateLaptopsRequest{Mode: mode, Laptop: laptop}))
+		}
+		require.NoError(t, stream.CloseSend())
+
+		var results []*pb.BatchCreateLaptopsResponse
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return results, nil
+			}
+			if err != nil {
+				return results, err
+			}
+			results = append(results, res)
+		}
+	}
+	requireCodes := func(results []*pb.BatchCreateLaptopsResponse, expected ...codes.Code) {
+		require.Len(t, results, len(expected))
+		for i, res := range results {
+			require.Equal(t, uint32(i), res.GetIndex())
+			require.Equal(t, expected[i], codes.Code(res.GetCode()), res.GetError())
+			if expected[i] == codes.OK {
+				require.NotEmpty(t, res.GetId())
+			}
+		}
+	}
+
+	results, err := batch(pb.BatchCreateLaptopsRequest_ALL_OR_NOTHING, valid, invalid, existing)
+	require.Equal(t, codes.Aborted, status.Code(err))
+	requireCodes(results, codes.Aborted, codes.InvalidArgument, codes.Aborted)
+	require.Equal(t, 1, laptopStore.Count())
+
+	results, err = batch(pb.BatchCreateLaptopsRequest_ALL_OR_NOTHING, valid, existing)
+	require.Equal(t, codes.Aborted, status.Code(err))
+	requireCodes(results, codes.Aborted, codes.AlreadyExists)
+	require.Equal(t, 1, laptopStore.Count())
+
+	results, err = batch(pb.BatchCreateLaptopsRequest_BEST_EFFORT, valid, invalid, existing, noID, nil)
+	require.NoError(t, err)
+	requireCodes(results, codes.OK, codes.InvalidArgument, codes.AlreadyExists, codes.OK, codes.InvalidArgument)
+	require.Equal(t, valid.GetId(), results[0].GetId())
+	require.Equal(t, 3, laptopStore.Count())
+
+	others := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	results, err = batch(pb.BatchCreateLaptopsRequest_ALL_OR_NOTHING, others...)
+	require.NoError(t, err)
+	requireCodes(results, codes.OK, codes.OK, codes.OK)
+	require.Equal(t, 6, laptopStore.Count())
+}
+
+func TestClientBatchCreateLaptopsClientGone(t *testing.T) {
+	t.Parallel()
+
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+	laptopServer.EventBus = service.NewLaptopEventBus(0, 10)
+	subscription, err := laptopServer.EventBus.Subscribe("")
+	require.NoError(t, err)
+	serverAddr := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	})
+	laptopClient := newLaptopClient(t, serverAddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := laptopClient.BatchCreateLaptops(ctx)
+	require.NoError(t, err)
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	for _, laptop := range laptops {
+		require.NoError(t, stream.Send(&pb.BatchCreateLaptopsRequest{Mode: pb.BatchCreateLaptopsRequest_ALL_OR_NOTHING, Laptop: laptop}))
+	}
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	cancel()
+
+	// every saved laptop is published even though the client does not read its result
+	for _, laptop := range laptops {
+		event := (<-subscription.Items()).(*service.LaptopEvent)
+		require.Equal(t, pb.LaptopEvent_CREATED, event.Type)
+		require.Equal(t, laptop.GetId(), event.Laptop.GetId())
+	}
+}
+
+func TestClientWatchLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopServer := service.NewLaptopServer(laptopStore, nil, nil)
+	laptopServer.EventBus = service.NewLaptopEventBus(10, 10)
+	serverAddr := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	})
+	laptopClient := newLaptopClient(t, serverAddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	filter := &pb.Filter{MaxPriceUsd: 2000, MinRam: &pb.Memory{}}
+	stream, err := laptopClient.WatchLaptops(ctx, &pb.WatchLaptopsRequest{Filter: filter})
+	require.NoError(t, err)
+	// wait for the subscription before making changes
+	_, err = stream.Header()
+	require.NoError(t, err)
+
+	cheap := sample.NewLaptop()
+	cheap.PriceUsd = 1500
+	expensive := sample.NewLaptop()
+	expensive.PriceUsd = 2500
+	for _, laptop := range []*pb.Laptop{cheap, expensive} {
+		_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+		require.NoError(t, err)
+	}
+	cheap.PriceUsd = 2100
+	_, err = laptopClient.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: cheap})
+	require.NoError(t, err)
+	_, err = laptopClient.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{Id: expensive.GetId()})
+	require.NoError(t, err)
+	_, err = laptopClient.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{Id: expensive.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, pb.LaptopEvent_CREATED, res.GetEvent().GetType())
+	require.Equal(t, cheap.GetId(), res.GetEvent().GetLaptop().GetId())
+	created := res.GetEvent().GetCursor()
+
+	// the cheap laptop leaves the filter, the expensive one never matched it
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, pb.LaptopEvent_UPDATED, res.GetEvent().GetType())
+	require.Equal(t, 2100.0, res.GetEvent().GetLaptop().GetPriceUsd())
+
+	// resume after the creation without a filter
+	resumed, err := laptopClient.WatchLaptops(ctx, &pb.WatchLaptopsRequest{Cursor: created})
+	require.NoError(t, err)
+	for _, eventType := range []pb.LaptopEvent_Type{pb.LaptopEvent_CREATED, pb.LaptopEvent_UPDATED, pb.LaptopEvent_DELETED} {
+		res, err = resumed.Recv()
+		require.NoError(t, err)
+		require.Equal(t, eventType, res.GetEvent().GetType())
+	}
+
+	expired, err := laptopClient.WatchLaptops(ctx, &pb.WatchLaptopsRequest{Cursor: "00000000-1"})
+	require.NoError(t, err)
+	_, err = expired.Recv()
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
 func TestClientSearchLaptop(t *testing.T) {
 	t.Parallel()
 	filter := &pb.Filter{
@@ -114,6 +357,58 @@ func TestClientSearchLaptop(t *testing.T) {
 	require.Equal(t, found, len(expectedIDs))
 }
 
+func TestClientSearchLaptopByWeight(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	weights := []*pb.Weight{
+		{Value: 1, Unit: pb.Weight_KILOGRAM},
+		{Value: 3.3, Unit: pb.Weight_POUND},
+		{Value: 2.5, Unit: pb.Weight_KILOGRAM},
+		nil,
+	}
+	laptops := make([]*pb.Laptop, len(weights))
+	for i, weight := range weights {
+		laptops[i] = sample.NewLaptop()
+		laptops[i].Weight = nil
+		if weight != nil {
+			require.NoError(t, units.SetLaptopWeight(laptops[i], weight))
+		}
+		require.NoError(t, store.Save(laptops[i]))
+	}
+
+	serverAddr := startTestLaptopServer(t, store, nil, nil)
+	laptopClient := newLaptopClient(t, serverAddr)
+	req := &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{
+			MaxPriceUsd: 1e6,
+			MinWeight:   &pb.Weight{Value: 1.2, Unit: pb.Weight_KILOGRAM},
+			MaxWeight:   &pb.Weight{Value: 5, Unit: pb.Weight_POUND},
+		},
+		WeightUnit: pb.Weight_KILOGRAM,
+	}
+	stream, err := laptopClient.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, laptops[1].GetId(), res.GetLaptop().GetId())
+	require.InDelta(t, 3.3*0.45359237, res.GetLaptop().GetWeightKg(), 1e-9)
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	// the laptop is converted for the response only
+	stored, err := store.Find(laptops[1].GetId())
+	require.NoError(t, err)
+	require.Equal(t, 3.3, stored.GetWeightLb())
+
+	getRes, err := laptopClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptops[0].GetId(), WeightUnit: pb.Weight_POUND})
+	require.NoError(t, err)
+	require.InDelta(t, 2.20462, getRes.GetLaptop().GetWeightLb(), 1e-5)
+
+	_, err = laptopClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptops[0].GetId(), WeightUnit: 9})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 func newLaptopClient(t *testing.T, addr string) pb.LaptopServiceClient {
 	conn, err := grpc.Dial(addr, grpc.WithInsecure())
 	require.NoError(t, err)
@@ -126,15 +421,21 @@ func startTestLaptopServer(
 	imageStore service.ImageStore,
 	ratingStore service.RatingStore) string {
 	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+	return startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	})
+}
 
-	grpcServer := grpc.NewServer()
+func startTestServer(t *testing.T, register func(grpcServer *grpc.Server), opts ...grpc.ServerOption) string {
+	grpcServer := grpc.NewServer(opts...)
+	register(grpcServer)
 
-	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
 	listener, err := net.Listen("tcp", ":0")
 	require.NoError(t, err)
 
 	// 开始监听grpc请求
 	go grpcServer.Serve(listener) // block call
+	t.Cleanup(grpcServer.Stop)
 
 	return listener.Addr().String()
 }
@@ -203,7 +504,7 @@ func TestClientRateLaptop(t *testing.T) {
 	t.Parallel()
 
 	laptopStore := service.NewInMemoryLaptopStore()
-	ratingStore := service.NewInMemoryRatingScore()
+	ratingStore := service.NewInMemoryRatingScore(0)
 
 	laptop := sample.NewLaptop()
 	err := laptopStore.Save(laptop)
@@ -243,5 +544,178 @@ func TestClientRateLaptop(t *testing.T) {
 		require.Equal(t, laptop.GetId(), res.GetLaptopId())
 		require.Equal(t, uint32(idx+1), res.GetRatedCount())
 		require.Equal(t, averages[idx], res.GetAverageScore())
+		require.Equal(t, averages[idx], res.GetScore())
+		require.Equal(t, service.AggregationMean, res.GetAggregation())
+	}
+}
+func TestClientRateLaptopFlagged(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingScore(0)
+	flaggedRatingStore := service.NewInMemoryFlaggedRatingStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, ratingStore)
+	laptopServer.BurstDetector = service.NewBurstDetector(1, time.Minute, 1, 10)
+	laptopServer.FlaggedRatingStore = flaggedRatingStore
+	adminServer := service.NewAdminServer(flaggedRatingStore, ratingStore)
+	serverAddress := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+		pb.RegisterAdminServiceServer(grpcServer, adminServer)
+	})
+	conn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+	laptopClient := pb.NewLaptopServiceClient(conn)
+	adminClient := pb.NewAdminServiceClient(conn)
+
+	stream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+
+	flagged := []bool{false, true, true}
+	for i := range flagged {
+		err := stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 10})
+		require.NoError(t, err)
+
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, flagged[i], res.GetFlagged())
+		require.Equal(t, uint32(1), res.GetRatedCount())
 	}
-}
\ No newline at end of file
+	require.NoError(t, stream.CloseSend())
+
+	list, err := adminClient.ListFlaggedRatings(context.Background(), &pb.ListFlaggedRatingsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetRatings(), 2)
+
+	for i, rating := range list.GetRatings() {
+		require.Equal(t, laptop.GetId(), rating.GetLaptopId())
+		_, err := adminClient.ReviewFlaggedRating(context.Background(), &pb.ReviewFlaggedRatingRequest{
+			Id:      rating.GetId(),
+			Approve: i == 0,
+		})
+		require.NoError(t, err)
+	}
+
+	rating, err := ratingStore.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.Count)
+
+	_, err = adminClient.ReviewFlaggedRating(context.Background(), &pb.ReviewFlaggedRatingRequest{Id: "xyz"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestClientRateLaptopLimited(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingScore(0))
+	laptopServer.UserRatingLimiter = service.NewRateLimiter(2, time.Minute)
+	serverAddress := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	})
+	laptopClient := newLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		err := stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 5})
+		require.NoError(t, err)
+	}
+	require.NoError(t, stream.CloseSend())
+
+	for i := 0; i < 2; i++ {
+		_, err := stream.Recv()
+		require.NoError(t, err)
+	}
+	_, err = stream.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, rpcerror.ReasonRateLimited, rpcerror.Reason(err))
+	delay, ok := rpcerror.RetryDelay(err)
+	require.True(t, ok)
+	require.True(t, delay > 0 && delay <= time.Minute)
+}
+
+func TestClientRateLaptopRefusedRatingKeepsUserQuota(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop1))
+	require.NoError(t, laptopStore.Save(laptop2))
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingScore(0))
+	laptopServer.UserRatingLimiter = service.NewRateLimiter(2, time.Minute)
+	laptopServer.LaptopRatingLimiter = service.NewRateLimiter(1, time.Minute)
+	serverAddress := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	})
+	laptopClient := newLaptopClient(t, serverAddress)
+
+	rate := func(laptopID string) error {
+		stream, err := laptopClient.RateLaptop(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: laptopID, Score: 5}))
+		require.NoError(t, stream.CloseSend())
+		_, err = stream.Recv()
+		return err
+	}
+	require.NoError(t, rate(laptop1.GetId()))
+	// refused by the laptop limiter, so it doesn't count for the user one
+	require.Equal(t, codes.ResourceExhausted, status.Code(rate(laptop1.GetId())))
+	require.NoError(t, rate(laptop2.GetId()))
+}
+
+func TestClientRateLaptopConcurrentLimits(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop1))
+	require.NoError(t, laptopStore.Save(laptop2))
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingScore(0))
+	laptopServer.UserRatingLimiter = service.NewRateLimiter(5, time.Minute)
+	laptopServer.LaptopRatingLimiter = service.NewRateLimiter(3, time.Minute)
+	serverAddress := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	})
+	laptopClient := newLaptopClient(t, serverAddress)
+
+	// rateInParallel sends n ratings of a laptop at once and returns how many are accepted
+	rateInParallel := func(laptopID string, n int) int32 {
+		var accepted int32
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stream, err := laptopClient.RateLaptop(context.Background())
+				require.NoError(t, err)
+				require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: laptopID, Score: 5}))
+				require.NoError(t, stream.CloseSend())
+				_, err = stream.Recv()
+				if err == nil {
+					atomic.AddInt32(&accepted, 1)
+					return
+				}
+				require.Equal(t, codes.ResourceExhausted, status.Code(err))
+			}()
+		}
+		wg.Wait()
+		return accepted
+	}
+
+	require.Equal(t, int32(3), rateInParallel(laptop1.GetId(), 20))
+	// the ratings refused for the laptop don't count for the user, who has 2 ratings left
+	require.Equal(t, int32(2), rateInParallel(laptop2.GetId(), 20))
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/neepoo/pcbook/pb"
+)
+
+var (
+	// ErrCursorExpired is returned when the events after a cursor are not kept anymore
+	ErrCursorExpired = errors.New("cursor expired")
+	// ErrSubscriberTooSlow ends a subscription whose buffer is full
+	ErrSubscriberTooSlow = errors.New("subscriber is too slow")
+)
+
+// LaptopEvent is a change of the laptop catalog
+type LaptopEvent struct {
+	Sequence uint64
+	Type     pb.LaptopEvent_Type
+	// Laptop is the laptop after the change, or before it for a deletion
+	Laptop *pb.Laptop
+	// Previous is the laptop before an update
+	Previous *pb.Laptop
+	ImageID  string
+	Time     time.Time
+}
+
+// LaptopEventBus publishes the laptop events to the subscriptions without ever waiting for them.
+// It keeps the last events so that a subscriber can resume after the cursor of an event it received.
+type LaptopEventBus struct {
+	mutex         sync.Mutex
+	epoch         string
+	sequence      uint64
+	history       []*LaptopEvent
+	next          int
+	bufferSize    int
+	subscriptions map[*Subscription]bool
+	closed        bool
+}
+
+// NewLaptopEventBus returns a bus keeping historySize events,
+// whose subscriptions are ended when bufferSize events are waiting for them
+func NewLaptopEventBus(historySize, bufferSize int) *LaptopEventBus {
+	return &LaptopEventBus{
+		epoch:         uuid.NewString()[:8],
+		history:       make([]*LaptopEvent, 0, historySize),
+		bufferSize:    bufferSize,
+		subscriptions: map[*Subscription]bool{},
+	}
+}
+
+// Publish sends an event to the subscriptions and returns it
+func (bus *LaptopEventBus) Publish(eventType pb.LaptopEvent_Type, laptop, previous *pb.Laptop, imageID string) *LaptopEvent {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.sequence++
+	event := &LaptopEvent{
+		Sequence: bus.sequence,
+		Type:     eventType,
+		Laptop:   laptop,
+		Previous: previous,
+		ImageID:  imageID,
+		Time:     time.Now(),
+	}
+
+	if cap(bus.history) > 0 {
+		if len(bus.history) < cap(bus.history) {
+			bus.history = append(bus.history, event)
+		} else {
+			bus.history[bus.next] = event
+			bus.next = (bus.next + 1) % len(bus.history)
+		}
+	}
+
+	for subscription := range bus.subscriptions {
+		subscription.send(event)
+	}
+	return event
+}
+
+// Subscribe returns a subscription to the events published after the one of cursor,
+// or to the next events if cursor is empty. Its items are *LaptopEvent.
+func (bus *LaptopEventBus) Subscribe(cursor string) (*Subscription, error) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if bus.closed {
+		return nil, ErrClosed
+	}
+
+	var missed []*LaptopEvent
+	if cursor != "" {
+		after, err := bus.parseCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		missed, err = bus.eventsAfter(after)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var subscription *Subscription
+	subscription = newSubscription(&bus.mutex, bus.bufferSize+len(missed), func() {
+		delete(bus.subscriptions, subscription)
+	})
+	for _, event := range missed {
+		subscription.items <- event
+	}
+	bus.subscriptions[subscription] = true
+	return subscription, nil
+}
+
+// eventsAfter returns the kept events published after sequence
+func (bus *LaptopEventBus) eventsAfter(sequence uint64) ([]*LaptopEvent, error) {
+	if sequence > bus.sequence {
+		return nil, fmt.Errorf("%w: cursor is ahead of the last event", ErrCursorExpired)
+	}
+	missed := bus.sequence - sequence
+	if missed > uint64(len(bus.history)) {
+		return nil, fmt.Errorf("%w: %d events were missed, only %d are kept", ErrCursorExpired, missed, len(bus.history))
+	}
+
+	events := make([]*LaptopEvent, 0, missed)
+	for i := 0; i < len(bus.history); i++ {
+		event := bus.history[(bus.next+i)%len(bus.history)]
+		if event.Sequence > sequence {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Cursor returns the cursor to resume after an event
+func (bus *LaptopEventBus) Cursor(event *LaptopEvent) string {
+	return bus.epoch + "-" + strconv.FormatUint(event.Sequence, 10)
+}
+
+// parseCursor returns the sequence of the event of cursor.
+// The cursors of another bus, such as the one of a restarted server, are expired.
+func (bus *LaptopEventBus) parseCursor(cursor string) (uint64, error) {
+	parts := strings.SplitN(cursor, "-", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	sequence, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	if parts[0] != bus.epoch {
+		return 0, fmt.Errorf("%w: cursor is from another server run", ErrCursorExpired)
+	}
+	return sequence, nil
+}
+
+// Close ends all subscriptions with ErrClosed and rejects new ones
+func (bus *LaptopEventBus) Close() error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.closed = true
+	for subscription := range bus.subscriptions {
+		subscription.end(ErrClosed)
+	}
+	return nil
+}
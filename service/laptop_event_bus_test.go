@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestLaptopEventBus(t *testing.T) {
+	t.Parallel()
+
+	bus := service.NewLaptopEventBus(3, 10)
+	subscription, err := bus.Subscribe("")
+	require.NoError(t, err)
+
+	laptop := sample.NewLaptop()
+	first := bus.Publish(pb.LaptopEvent_CREATED, laptop, nil, "")
+	bus.Publish(pb.LaptopEvent_IMAGE_ADDED, laptop, nil, "image")
+	bus.Publish(pb.LaptopEvent_DELETED, laptop, nil, "")
+
+	for _, eventType := range []pb.LaptopEvent_Type{pb.LaptopEvent_CREATED, pb.LaptopEvent_IMAGE_ADDED, pb.LaptopEvent_DELETED} {
+		event := (<-subscription.Items()).(*service.LaptopEvent)
+		require.Equal(t, eventType, event.Type)
+		require.Equal(t, laptop.GetId(), event.Laptop.GetId())
+	}
+	subscription.Close()
+
+	// resume after the first event
+	resumed, err := bus.Subscribe(bus.Cursor(first))
+	require.NoError(t, err)
+	require.Len(t, resumed.Items(), 2)
+	require.Equal(t, "image", (<-resumed.Items()).(*service.LaptopEvent).ImageID)
+	resumed.Close()
+
+	// the first event is dropped from the history
+	bus.Publish(pb.LaptopEvent_CREATED, sample.NewLaptop(), nil, "")
+	bus.Publish(pb.LaptopEvent_CREATED, sample.NewLaptop(), nil, "")
+	_, err = bus.Subscribe(bus.Cursor(first))
+	require.ErrorIs(t, err, service.ErrCursorExpired)
+
+	_, err = service.NewLaptopEventBus(3, 10).Subscribe(bus.Cursor(first))
+	require.ErrorIs(t, err, service.ErrCursorExpired)
+
+	_, err = bus.Subscribe("cursor")
+	require.Error(t, err)
+}
+
+func TestLaptopEventBusSlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	bus := service.NewLaptopEventBus(0, 2)
+	slow, err := bus.Subscribe("")
+	require.NoError(t, err)
+	fast, err := bus.Subscribe("")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		bus.Publish(pb.LaptopEvent_CREATED, sample.NewLaptop(), nil, "")
+		if i < 2 {
+			<-fast.Items()
+		}
+	}
+
+	<-slow.Done()
+	require.ErrorIs(t, slow.Err(), service.ErrSubscriberTooSlow)
+	require.Len(t, slow.Items(), 2)
+	require.Len(t, fast.Items(), 1)
+
+	require.NoError(t, bus.Close())
+	<-fast.Done()
+	require.ErrorIs(t, fast.Err(), service.ErrClosed)
+	_, err = bus.Subscribe("")
+	require.ErrorIs(t, err, service.ErrClosed)
+}
@@ -5,10 +5,12 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/rpcerror"
 	"github.com/neepoo/pcbook/sample"
 	"github.com/neepoo/pcbook/service"
 )
@@ -22,6 +24,9 @@ func TestServerCreateLaptop(t *testing.T) {
 	laptopInvalidId := sample.NewLaptop()
 	laptopInvalidId.Id = "xyz"
 
+	laptopInvalid := sample.NewLaptop()
+	laptopInvalid.PriceUsd = 0
+
 	laptopDuplicatedId := sample.NewLaptop()
 	storeDuplicatedId := service.NewInMemoryLaptopStore()
 	err := storeDuplicatedId.Save(laptopDuplicatedId)
@@ -51,6 +56,12 @@ func TestServerCreateLaptop(t *testing.T) {
 			store:  service.NewInMemoryLaptopStore(),
 			code:   codes.InvalidArgument,
 		},
+		{
+			name:   "failure_invalid_laptop",
+			laptop: laptopInvalid,
+			store:  service.NewInMemoryLaptopStore(),
+			code:   codes.InvalidArgument,
+		},
 		{
 			name:   "duplicated_uuid",
 			laptop: laptopDuplicatedId,
@@ -89,3 +100,41 @@ func TestServerCreateLaptop(t *testing.T) {
 		})
 	}
 }
+
+func TestServerUpdateLaptopInvalid(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop))
+	server := service.NewLaptopServer(store, nil, nil)
+
+	laptop.Cpu.MinGhz = laptop.Cpu.MaxGhz + 1
+	laptop.Storages = nil
+	_, err := server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: laptop})
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Equal(t, rpcerror.ReasonValidationFailed, rpcerror.Reason(err))
+	require.Len(t, st.Details(), 2)
+	badRequest := st.Details()[1].(*errdetails.BadRequest)
+	require.Len(t, badRequest.GetFieldViolations(), 2)
+	require.Equal(t, "laptop.cpu.min_ghz", badRequest.GetFieldViolations()[0].GetField())
+	require.Equal(t, "laptop.storages", badRequest.GetFieldViolations()[1].GetField())
+}
+
+func TestServerCreateLaptopNormalizesWeight(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	server := service.NewLaptopServer(store, nil, nil)
+	server.WeightUnit = pb.Weight_KILOGRAM
+
+	laptop := sample.NewLaptop()
+	laptop.Weight = &pb.Laptop_WeightLb{WeightLb: 2}
+	res, err := server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	saved, err := store.Find(res.GetId())
+	require.NoError(t, err)
+	require.InDelta(t, 0.90718474, saved.GetWeightKg(), 1e-12)
+}
@@ -3,38 +3,88 @@ package service
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"io"
-	"log"
+	"net"
+	"strconv"
+	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
+	"github.com/neepoo/pcbook/logging"
+	"github.com/neepoo/pcbook/money"
 	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/rpcerror"
+	"github.com/neepoo/pcbook/units"
+	"github.com/neepoo/pcbook/validation"
 )
 
 type LaptopServer struct {
-	LaptopStore LaptopStore
-	ImageStore  ImageStore
-	RatingStore RatingStore
+	LaptopStore      LaptopStore
+	ImageStore       ImageStore
+	RatingStore      RatingStore
+	RatingAggregator RatingAggregator
+	// UserRatingLimiter and LaptopRatingLimiter throttle ratings per source and per laptop
+	UserRatingLimiter   *RateLimiter
+	LaptopRatingLimiter *RateLimiter
+	// suspicious ratings are held in FlaggedRatingStore instead of being counted
+	BurstDetector      *BurstDetector
+	FlaggedRatingStore FlaggedRatingStore
+	// the laptops of a batch are saved by chunks of BatchChunkSize, and BatchWriters limits
+	// the chunks saved at once across the batches if not nil
+	BatchChunkSize int
+	BatchWriters   *Semaphore
+	// BatchMaxSize is the max number of laptops of an all-or-nothing batch, 0 for no limit
+	BatchMaxSize int
+	// MaxImageSize is the max size of an uploaded image in bytes, 0 for no limit
+	MaxImageSize int
+	// the changes of the laptops are published to EventBus, and watched through it
+	EventBus *LaptopEventBus
+	// the calls repeated with the same idempotency key are replayed from Idempotency, if not nil
+	Idempotency *IdempotencyStore
+	// the changes of the laptops are recorded to AuditLog, if not nil
+	AuditLog AuditLog
+	// ExchangeRates derive the prices of the laptops in the currencies they have no price in, if not nil
+	ExchangeRates *ExchangeRates
+	// the prices the laptops had are recorded to PriceHistoryStore, if not nil
+	PriceHistoryStore PriceHistoryStore
+	// the price alerts of the users are saved to PriceAlertStore, and their matches are sent
+	// through PriceAlertNotifier, if not nil
+	PriceAlertStore    PriceAlertStore
+	PriceAlertNotifier *PriceAlertNotifier
+	// WeightUnit is the unit the weights of the laptops are converted to when they are saved,
+	// they are kept in the unit they are sent in if it is unknown
+	WeightUnit pb.Weight_Unit
 	pb.UnimplementedLaptopServiceServer
 }
 
 func NewLaptopServer(laptopStore LaptopStore, imageStore ImageStore, ratingStore RatingStore) *LaptopServer {
 	return &LaptopServer{
-		LaptopStore: laptopStore,
-		ImageStore:  imageStore,
-		RatingStore: ratingStore,
+		LaptopStore:      laptopStore,
+		ImageStore:       imageStore,
+		RatingStore:      ratingStore,
+		RatingAggregator: MeanAggregator{},
+		BatchChunkSize:   100,
 	}
 }
 
 func contextError(ctx context.Context) error {
 	switch ctx.Err() {
 	case context.Canceled:
-		return logError(status.Error(codes.Canceled, "request is canceled"))
+		return status.Error(codes.Canceled, "request is canceled")
 	case context.DeadlineExceeded:
-		return logError(status.Error(codes.DeadlineExceeded, "deadline is exceeded"))
+		return status.Error(codes.DeadlineExceeded, "deadline is exceeded")
 	default:
 		return nil
 	}
@@ -44,20 +94,31 @@ func (server *LaptopServer) CreateLaptop(
 	ctx context.Context,
 	request *pb.CreateLaptopRequest,
 ) (*pb.CreateLaptopResponse, error) {
-	laptop := request.GetLaptop()
-	log.Printf("receive a create-laptop request with id: %s", laptop.GetId())
+	logging.FromContext(ctx).Debug("received create-laptop request")
 
-	if len(laptop.Id) > 0 {
-		_, err := uuid.Parse(laptop.Id)
+	res, err := server.idempotent(ctx, "CreateLaptop", fingerprint(request), func() (proto.Message, error) {
+		res, err := server.createLaptop(ctx, request.GetLaptop())
 		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "laptop id is not a valid uuid: %s", err)
+			return nil, err
 		}
-	} else {
-		id, err := uuid.NewRandom()
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "cannot generate uuid: %s", err)
-		}
-		laptop.Id = id.String()
+		return res, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*pb.CreateLaptopResponse), nil
+}
+
+func (server *LaptopServer) createLaptop(ctx context.Context, laptop *pb.Laptop) (*pb.CreateLaptopResponse, error) {
+	logger := logging.FromContext(ctx)
+	if err := validation.Laptop("laptop", laptop).Err(); err != nil {
+		return nil, err
+	}
+	if err := assignLaptopID(laptop); err != nil {
+		return nil, err
+	}
+	if err := server.normalizeWeight(laptop); err != nil {
+		return nil, err
 	}
 	// some heavy processing
 	// time.Sleep(6 *time.Second)
@@ -70,32 +131,450 @@ func (server *LaptopServer) CreateLaptop(
 	// save the laptop to in-mem
 	err := server.LaptopStore.Save(laptop)
 	if err != nil {
-		code := codes.Internal
-		if errors.Is(err, ErrAlreadyExists) {
-			code = codes.AlreadyExists
-		}
-		return nil, status.Errorf(code, "cannot save laptop: %s", err)
+		return nil, saveLaptopError(laptop.Id, err)
 	}
-	log.Printf("saved laptop with id: %s", laptop.Id)
+	logger.Info("saved laptop", "laptop_id", laptop.Id)
+	server.recordPrice(ctx, laptop)
+	server.publish(pb.LaptopEvent_CREATED, laptop, nil, "")
+	server.notifyPriceAlerts(ctx, laptop, nil, nil)
+	server.audit(ctx, "CreateLaptop", laptop.Id, nil, laptop)
 	return &pb.CreateLaptopResponse{Id: laptop.Id}, nil
 }
 
+// assignLaptopID checks the id of a new laptop, or generates it if it is empty
+func assignLaptopID(laptop *pb.Laptop) error {
+	if len(laptop.Id) > 0 {
+		_, err := uuid.Parse(laptop.Id)
+		if err != nil {
+			return rpcerror.New(codes.InvalidArgument, rpcerror.ReasonInvalidLaptopID, "laptop id is not a valid uuid: %s", err).
+				WithBadRequest(&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+					{Field: "laptop.id", Description: "laptop id must be a uuid"},
+				}}).
+				Err()
+		}
+		return nil
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot generate uuid: %s", err)
+	}
+	laptop.Id = id.String()
+	return nil
+}
+
+// normalizeWeight converts the weight of a laptop to the unit of the server, if it has one
+func (server *LaptopServer) normalizeWeight(laptop *pb.Laptop) error {
+	if server.WeightUnit == pb.Weight_UNKNOWN {
+		return nil
+	}
+	err := units.ConvertLaptopWeight(laptop, server.WeightUnit)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot normalize weight: %v", err)
+	}
+	return nil
+}
+
+// inWeightUnit returns laptop with its weight in unit, converted in a copy so that
+// the laptops of the store or of the events are not changed
+func inWeightUnit(laptop *pb.Laptop, unit pb.Weight_Unit) *pb.Laptop {
+	weight := units.LaptopWeight(laptop)
+	if unit == pb.Weight_UNKNOWN || weight == nil || weight.GetUnit() == unit {
+		return laptop
+	}
+	converted := proto.Clone(laptop).(*pb.Laptop)
+	if err := units.ConvertLaptopWeight(converted, unit); err != nil {
+		return laptop
+	}
+	return converted
+}
+
+// saveLaptopError returns the status error of a laptop which cannot be saved
+func saveLaptopError(id string, err error) error {
+	if errors.Is(err, ErrAlreadyExists) {
+		return rpcerror.New(codes.AlreadyExists, rpcerror.ReasonLaptopAlreadyExists, "cannot save laptop: %s", err).
+			WithResource(rpcerror.ResourceLaptop, id, "a laptop with this id already exists").
+			Err()
+	}
+	return status.Errorf(codes.Internal, "cannot save laptop: %s", err)
+}
+
+// laptopNotFoundError returns the status error of a laptop which doesn't exist
+func laptopNotFoundError(code codes.Code, id string) error {
+	return rpcerror.New(code, rpcerror.ReasonLaptopNotFound, "laptop %s doesn't exist", id).
+		WithResource(rpcerror.ResourceLaptop, id, "laptop doesn't exist").
+		Err()
+}
+
+// idempotent runs call, unless the caller already called method with the idempotency key of ctx.
+// The outcome of the previous call is returned then, if the request has the same fingerprint.
+func (server *LaptopServer) idempotent(
+	ctx context.Context,
+	method string,
+	fingerprint [sha256.Size]byte,
+	call func() (proto.Message, error),
+) (proto.Message, error) {
+	var key string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(IdempotencyKeyHeader); len(values) > 0 {
+		key = values[0]
+	}
+	if server.Idempotency == nil || key == "" {
+		return call()
+	}
+	if len(key) > MaxIdempotencyKeyLength {
+		return nil, status.Errorf(codes.InvalidArgument, "idempotency key is longer than %d characters", MaxIdempotencyKeyLength)
+	}
+
+	res, replayed, err := server.Idempotency.Do(ctx, caller(ctx)+"\x00"+method+"\x00"+key, fingerprint, call)
+	if errors.Is(err, ErrIdempotencyKeyReused) {
+		return nil, rpcerror.New(codes.InvalidArgument, rpcerror.ReasonIdempotencyKeyReused, "cannot use idempotency key %q: %v", key, err).
+			WithMetadata("idempotency_key", key).
+			Err()
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil, contextError(ctx)
+	}
+	if replayed {
+		logging.FromContext(ctx).Info("replayed idempotent call", "idempotency_key", key)
+		// the call is already made, failing to tell it to the client is not worth failing the replay
+		_ = grpc.SetHeader(ctx, metadata.Pairs(IdempotentReplayHeader, "true"))
+	}
+	return res, err
+}
+
+// fingerprint is the hash of a request, made of messages and raw data
+func fingerprint(parts ...interface{}) [sha256.Size]byte {
+	hash := sha256.New()
+	for _, part := range parts {
+		var data []byte
+		switch part := part.(type) {
+		case proto.Message:
+			// marshalling a message never fails once it is received
+			data, _ = proto.MarshalOptions{Deterministic: true}.Marshal(part)
+		case []byte:
+			data = part
+		}
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(data)))
+		hash.Write(size[:])
+		hash.Write(data)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum
+}
+
+// batchItem is a laptop of a batch, err is its status error if it cannot be created
+type batchItem struct {
+	index  int
+	laptop *pb.Laptop
+	err    error
+}
+
+// BatchCreateLaptops creates the laptops of a stream and sends the result of each of them.
+// The laptops are received again only once the previous ones are saved,
+// so that the flow control of the stream holds back a client sending faster than the store saves.
+func (server *LaptopServer) BatchCreateLaptops(stream pb.LaptopService_BatchCreateLaptopsServer) error {
+	ctx := stream.Context()
+	mode := pb.BatchCreateLaptopsRequest_BEST_EFFORT
+	chunkSize := server.BatchChunkSize
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+
+	var items []*batchItem
+	for count := 0; ; count++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return status.Errorf(codes.Unknown, "cannot receive laptop: %v", err)
+		}
+		if count == 0 {
+			mode = req.GetMode()
+			logging.FromContext(ctx).Debug("received batch-create-laptops request", "mode", mode)
+		}
+
+		item := &batchItem{index: count, laptop: req.GetLaptop()}
+		item.err = validation.Laptop("laptop", item.laptop).Err()
+		if item.err == nil {
+			item.err = assignLaptopID(item.laptop)
+		}
+		if item.err == nil {
+			item.err = server.normalizeWeight(item.laptop)
+		}
+		items = append(items, item)
+
+		if mode == pb.BatchCreateLaptopsRequest_ALL_OR_NOTHING {
+			if server.BatchMaxSize > 0 && len(items) > server.BatchMaxSize {
+				return status.Errorf(codes.InvalidArgument, "an all-or-nothing batch cannot have more than %d laptops", server.BatchMaxSize)
+			}
+			continue
+		}
+		if len(items) == chunkSize {
+			err = server.saveBestEffort(ctx, stream, items)
+			if err != nil {
+				return err
+			}
+			items = items[:0]
+		}
+	}
+
+	if mode == pb.BatchCreateLaptopsRequest_ALL_OR_NOTHING {
+		return server.saveAllOrNothing(ctx, stream, items)
+	}
+	return server.saveBestEffort(ctx, stream, items)
+}
+
+// saveBestEffort saves the valid laptops of a chunk one by one
+func (server *LaptopServer) saveBestEffort(ctx context.Context, stream pb.LaptopService_BatchCreateLaptopsServer, items []*batchItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	release, err := server.acquireBatchWriter(ctx)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.err != nil {
+			continue
+		}
+		err := server.LaptopStore.Save(item.laptop)
+		if err != nil {
+			item.err = saveLaptopError(item.laptop.Id, err)
+		}
+	}
+	release()
+	server.batchCreated(ctx, items)
+	return server.sendBatchResults(ctx, stream, items)
+}
+
+// saveAllOrNothing saves the laptops of a whole batch at once if all of them are valid.
+// Otherwise the valid laptops fail as aborted, and so does the call.
+func (server *LaptopServer) saveAllOrNothing(ctx context.Context, stream pb.LaptopService_BatchCreateLaptopsServer, items []*batchItem) error {
+	failed := 0
+	for _, item := range items {
+		if item.err != nil {
+			failed++
+		}
+	}
+
+	if failed == 0 && len(items) > 0 {
+		laptops := make([]*pb.Laptop, len(items))
+		for i, item := range items {
+			laptops[i] = item.laptop
+		}
+		release, err := server.acquireBatchWriter(ctx)
+		if err != nil {
+			return err
+		}
+		err = server.LaptopStore.SaveAll(laptops)
+		release()
+
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) {
+			for i, err := range batchErr.Errors {
+				items[i].err = saveLaptopError(items[i].laptop.Id, err)
+			}
+			failed = len(batchErr.Errors)
+		} else if err != nil {
+			return status.Errorf(codes.Internal, "cannot save laptops: %v", err)
+		}
+		if failed == 0 {
+			server.batchCreated(ctx, items)
+		}
+	}
+
+	if failed > 0 {
+		for _, item := range items {
+			if item.err == nil {
+				item.err = rpcerror.New(codes.Aborted, rpcerror.ReasonBatchAborted, "batch is aborted").Err()
+			}
+		}
+	}
+	err := server.sendBatchResults(ctx, stream, items)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return rpcerror.New(codes.Aborted, rpcerror.ReasonBatchAborted, "%d of %d laptops cannot be created, none is created", failed, len(items)).
+			WithMetadata("failed", strconv.Itoa(failed)).
+			WithMetadata("total", strconv.Itoa(len(items))).
+			Err()
+	}
+	return nil
+}
+
+// acquireBatchWriter waits for the turn of a batch to save laptops and returns the function to release it
+func (server *LaptopServer) acquireBatchWriter(ctx context.Context) (func(), error) {
+	if server.BatchWriters == nil {
+		return func() {}, nil
+	}
+	err := server.BatchWriters.Acquire(ctx)
+	if err != nil {
+		return nil, contextError(ctx)
+	}
+	return server.BatchWriters.Release, nil
+}
+
+// batchCreated records, publishes and audits the laptops of a batch that are saved.
+// It runs before any result is sent, so that a client going away cannot lose them.
+func (server *LaptopServer) batchCreated(ctx context.Context, items []*batchItem) {
+	for _, item := range items {
+		if item.err != nil {
+			continue
+		}
+		server.recordPrice(ctx, item.laptop)
+		server.publish(pb.LaptopEvent_CREATED, item.laptop, nil, "")
+		server.notifyPriceAlerts(ctx, item.laptop, nil, nil)
+		server.audit(ctx, "BatchCreateLaptops", item.laptop.Id, nil, item.laptop)
+	}
+}
+
+// sendBatchResults sends the result of every item
+func (server *LaptopServer) sendBatchResults(ctx context.Context, stream pb.LaptopService_BatchCreateLaptopsServer, items []*batchItem) error {
+	created := 0
+	for _, item := range items {
+		res := &pb.BatchCreateLaptopsResponse{Index: uint32(item.index)}
+		if item.err == nil {
+			created++
+			res.Id = item.laptop.Id
+		} else {
+			st := status.Convert(item.err)
+			res.Code = int32(st.Code())
+			res.Error = st.Message()
+		}
+		err := stream.Send(res)
+		if err != nil {
+			return status.Errorf(codes.Unknown, "cannot send result: %v", err)
+		}
+	}
+	logging.FromContext(ctx).Info("saved laptops", "created", created, "failed", len(items)-created)
+	return nil
+}
+
+func (server *LaptopServer) UpdateLaptop(
+	ctx context.Context,
+	req *pb.UpdateLaptopRequest,
+) (*pb.UpdateLaptopResponse, error) {
+	laptop := req.GetLaptop()
+	if laptop.GetId() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "laptop id is required")
+	}
+	if err := validation.Laptop("laptop", laptop).Err(); err != nil {
+		return nil, err
+	}
+	if err := server.normalizeWeight(laptop); err != nil {
+		return nil, err
+	}
+
+	previous, err := server.LaptopStore.Update(laptop)
+	if errors.Is(err, ErrNotFound) {
+		return nil, laptopNotFoundError(codes.NotFound, laptop.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot update laptop: %v", err)
+	}
+	logging.FromContext(ctx).Info("updated laptop", "laptop_id", laptop.GetId())
+	previousHistory := server.priceHistory(ctx, laptop.GetId())
+	server.recordPrice(ctx, laptop)
+	server.publish(pb.LaptopEvent_UPDATED, laptop, previous, "")
+	server.notifyPriceAlerts(ctx, laptop, previous, previousHistory)
+	server.audit(ctx, "UpdateLaptop", laptop.GetId(), previous, laptop)
+	return &pb.UpdateLaptopResponse{Laptop: laptop}, nil
+}
+
+func (server *LaptopServer) DeleteLaptop(
+	ctx context.Context,
+	req *pb.DeleteLaptopRequest,
+) (*pb.DeleteLaptopResponse, error) {
+	laptop, err := server.LaptopStore.Delete(req.GetId())
+	if errors.Is(err, ErrNotFound) {
+		return nil, laptopNotFoundError(codes.NotFound, req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete laptop: %v", err)
+	}
+	logging.FromContext(ctx).Info("deleted laptop", "laptop_id", laptop.GetId())
+	server.deletePrices(ctx, laptop.GetId())
+	server.publish(pb.LaptopEvent_DELETED, laptop, nil, "")
+	server.audit(ctx, "DeleteLaptop", laptop.GetId(), laptop, nil)
+	return &pb.DeleteLaptopResponse{Laptop: laptop}, nil
+}
+
+func (server *LaptopServer) GetLaptop(
+	ctx context.Context,
+	req *pb.GetLaptopRequest,
+) (*pb.GetLaptopResponse, error) {
+	if err := validation.WeightUnit("weight_unit", req.GetWeightUnit()).Err(); err != nil {
+		return nil, err
+	}
+	laptop, err := server.LaptopStore.Find(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+	if laptop == nil {
+		return nil, laptopNotFoundError(codes.NotFound, req.GetId())
+	}
+	return &pb.GetLaptopResponse{Laptop: inWeightUnit(laptop, req.GetWeightUnit())}, nil
+}
+
+// GetPriceHistory returns the prices a laptop had, from the oldest
+func (server *LaptopServer) GetPriceHistory(
+	ctx context.Context,
+	req *pb.GetPriceHistoryRequest,
+) (*pb.GetPriceHistoryResponse, error) {
+	if server.PriceHistoryStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "price history is not enabled")
+	}
+	laptop, err := server.LaptopStore.Find(req.GetLaptopId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+	if laptop == nil {
+		return nil, laptopNotFoundError(codes.NotFound, req.GetLaptopId())
+	}
+
+	history, err := server.PriceHistoryStore.History(laptop.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find price history: %v", err)
+	}
+	res := &pb.GetPriceHistoryResponse{}
+	for _, point := range history {
+		res.Prices = append(res.Prices, &pb.PricePoint{
+			PriceUsd: point.PriceUSD,
+			Time:     timestamppb.New(point.Time),
+		})
+	}
+	return res, nil
+}
+
 func (server *LaptopServer) SearchLaptop(
 	req *pb.SearchLaptopRequest,
 	stream pb.LaptopService_SearchLaptopServer,
 ) error {
 	filter := req.GetFilter()
-	log.Printf("receive a search-laptop request with filter: %v", filter)
+	logger := logging.FromContext(stream.Context())
+	logger.Debug("received search-laptop request", "filter", filter)
+	violations := validation.Filter("filter", filter)
+	violations = append(violations, validation.WeightUnit("weight_unit", req.GetWeightUnit())...)
+	if err := violations.Err(); err != nil {
+		return err
+	}
 	err := server.LaptopStore.Search(
 		stream.Context(),
 		filter,
 		func(laptop *pb.Laptop) error {
-			res := &pb.SearchLaptopResponse{Laptop: laptop}
+			if !server.matchesPrices(filter, laptop) {
+				return nil
+			}
+			res := &pb.SearchLaptopResponse{Laptop: inWeightUnit(laptop, req.GetWeightUnit())}
 			err := stream.Send(res)
 			if err != nil {
 				return err
 			}
-			log.Printf("send laptop with id: %s", laptop.GetId())
+			logger.Debug("sent laptop", "laptop_id", laptop.GetId())
 			return nil
 		},
 	)
@@ -106,111 +585,580 @@ func (server *LaptopServer) SearchLaptop(
 }
 
 func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServer) error {
+	ctx := stream.Context()
 	req, err := stream.Recv()
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot receive image info"))
+		return status.Errorf(codes.Unknown, "cannot receive image info")
 	}
 	laptopID := req.GetInfo().GetLaptopId()
 	imageType := req.GetInfo().GetImageType()
-	log.Printf("receive an upload-image request for laptop %s with image type %s", laptopID, imageType)
+	logger := logging.FromContext(ctx).With("laptop_id", laptopID)
+	ctx = logging.NewContext(ctx, logger)
+	logger.Debug("received upload-image request", "image_type", imageType)
 
 	laptop, err := server.LaptopStore.Find(laptopID)
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot find laptop %v", err))
+		return status.Errorf(codes.Internal, "cannot find laptop %v", err)
 	}
 	if laptop == nil {
-		return logError(status.Errorf(codes.InvalidArgument, "laptop %s doesn't exist", laptopID))
+		return laptopNotFoundError(codes.InvalidArgument, laptopID)
 	}
 	imageData := bytes.Buffer{}
 	imageSize := 0
-	headers, _ := metadata.FromIncomingContext(stream.Context())
-	log.Println("get value from client context", headers.Get("name"))
+	headers, _ := metadata.FromIncomingContext(ctx)
+	logger.Debug("got value from client context", "name", headers.Get("name"))
 	for {
 		// check context error
-		err := contextError(stream.Context())
+		err := contextError(ctx)
 		if err != nil {
 			return err
 		}
 
 		req, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
-			log.Print("no more data")
+			logger.Debug("no more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err))
+			return status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err)
 		}
 		chunk := req.GetChunkData()
 		size := len(chunk)
 		imageSize += size
+		if server.MaxImageSize > 0 && imageSize > server.MaxImageSize {
+			return rpcerror.New(codes.InvalidArgument, rpcerror.ReasonImageTooLarge, "image is too large: %d > %d", imageSize, server.MaxImageSize).
+				WithMetadata("max_size", strconv.Itoa(server.MaxImageSize)).
+				Err()
+		}
 		// mock write data slowly
 		//time.Sleep(time.Second)
 		_, err = imageData.Write(chunk)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot write chunk data: %v", err))
+			return status.Errorf(codes.Internal, "cannot write chunk data: %v", err)
 		}
 
 	}
-	imageID, err := server.ImageStore.Save(laptopID, imageType, imageData)
+	// a repeated upload is told apart from another one by the hash of the whole image
+	res, err := server.idempotent(ctx, "UploadImage", fingerprint(req.GetInfo(), imageData.Bytes()), func() (proto.Message, error) {
+		imageID, err := server.ImageStore.Save(laptopID, imageType, imageData)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot write image to file: %v", err)
+		}
+		logger.Info("saved image", "image_id", imageID, "size", imageSize)
+		server.publish(pb.LaptopEvent_IMAGE_ADDED, laptop, nil, imageID)
+		res := &pb.UploadImageResponse{
+			Id:   imageID,
+			Size: uint32(imageSize),
+		}
+		server.audit(ctx, "UploadImage", laptopID, nil, res)
+		return res, nil
+	})
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot write image to file: %v", err))
-	}
-	res := &pb.UploadImageResponse{
-		Id:   imageID,
-		Size: uint32(imageSize),
+		return err
 	}
-	err = stream.SendAndClose(res)
+	err = stream.SendAndClose(res.(*pb.UploadImageResponse))
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot send response: %v", err))
+		return status.Errorf(codes.Unknown, "cannot send response: %v", err)
 	}
 	return nil
 }
 
 func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	source := caller(stream.Context())
 	for {
-		err := contextError(stream.Context())
+		ctx := stream.Context()
+		err := contextError(ctx)
 		if err != nil {
 			return err
 		}
 		req, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
-			log.Println("no more client data")
+			logging.FromContext(ctx).Debug("no more client data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot received stream request: %v", err))
+			return status.Errorf(codes.Unknown, "cannot received stream request: %v", err)
 		}
 		laptopID := req.GetLaptopId()
 		score := req.GetScore()
-		log.Printf("received a rate-laptop: id = %s, score = %.2f", laptopID, score)
+		logger := logging.FromContext(ctx).With("laptop_id", laptopID)
+		ctx = logging.NewContext(ctx, logger)
+		logger.Debug("received rate-laptop request", "score", score)
 
 		found, err := server.LaptopStore.Find(laptopID)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot find laptop:%r", err))
+			return status.Errorf(codes.Internal, "cannot find laptop: %v", err)
 		}
 		if found == nil {
-			return logError(status.Errorf(codes.NotFound, "laptopID %s is not found: %v", laptopID, err))
+			return laptopNotFoundError(codes.NotFound, laptopID)
 		}
-		rating, err := server.RatingStore.Add(laptopID, score)
+		err = server.reserveRating(source, laptopID)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot add rating to the store: %v", err))
+			return err
 		}
+
+		flagged := server.BurstDetector != nil && server.FlaggedRatingStore != nil &&
+			server.BurstDetector.IsSuspicious(source, score)
+		var rating *Rating
+		if flagged {
+			err = server.FlaggedRatingStore.Save(&FlaggedRating{
+				LaptopID: laptopID,
+				Source:   source,
+				Score:    score,
+				Reason:   "burst of extreme scores",
+				Time:     time.Now(),
+			})
+			if err != nil {
+				return status.Errorf(codes.Internal, "cannot flag rating: %v", err)
+			}
+			logger.Warn("flagged rating", "source", source, "score", score)
+			rating, err = server.RatingStore.Find(laptopID)
+		} else {
+			rating, err = server.RatingStore.Add(laptopID, score)
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "cannot add rating to the store: %v", err)
+		}
+		server.audit(ctx, "RateLaptop", laptopID, nil, req)
 		res := &pb.RateLaptopResponse{
 			LaptopId:     laptopID,
 			RatedCount:   rating.Count,
-			AverageScore: rating.Sum / float64(rating.Count),
+			AverageScore: MeanAggregator{}.Aggregate(rating),
+			Score:        server.RatingAggregator.Aggregate(rating),
+			Aggregation:  server.RatingAggregator.Name(),
+			Flagged:      flagged,
 		}
 		err = stream.Send(res)
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot send stream response: %v", err))
+			return status.Errorf(codes.Unknown, "cannot send stream response: %v", err)
+		}
+	}
+	return nil
+}
+
+// WatchLaptops sends the changes of the laptops until the client leaves.
+// A client too slow to receive them is disconnected, and can resume from the cursor of the last event it received.
+func (server *LaptopServer) WatchLaptops(req *pb.WatchLaptopsRequest, stream pb.LaptopService_WatchLaptopsServer) error {
+	ctx := stream.Context()
+	if server.EventBus == nil {
+		return status.Errorf(codes.Unimplemented, "watching laptops is not enabled")
+	}
+
+	violations := validation.Filter("filter", req.GetFilter())
+	violations = append(violations, validation.WeightUnit("weight_unit", req.GetWeightUnit())...)
+	if err := violations.Err(); err != nil {
+		return err
+	}
+	subscription, err := server.EventBus.Subscribe(req.GetCursor())
+	if errors.Is(err, ErrCursorExpired) {
+		return rpcerror.New(codes.OutOfRange, rpcerror.ReasonCursorExpired, "cannot resume watching: %v, search the laptops again", err).Err()
+	}
+	if errors.Is(err, ErrClosed) {
+		return status.Errorf(codes.Unavailable, "server is shutting down")
+	}
+	if err != nil {
+		return rpcerror.New(codes.InvalidArgument, rpcerror.ReasonInvalidCursor, "cannot watch laptops: %v", err).
+			WithBadRequest(&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "cursor", Description: err.Error()},
+			}}).
+			Err()
+	}
+	defer subscription.Close()
+	logging.FromContext(ctx).Debug("watching laptops", "filter", req.GetFilter(), "cursor", req.GetCursor())
+	// the headers tell the client that it receives the changes from now on
+	err = stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return status.Errorf(codes.Unknown, "cannot send header: %v", err)
+	}
+
+	send := func(event *LaptopEvent) error {
+		if !server.watchMatches(req.GetFilter(), event) {
+			return nil
+		}
+		pbEvent := toPbLaptopEvent(event, server.EventBus.Cursor(event))
+		pbEvent.Laptop = inWeightUnit(pbEvent.Laptop, req.GetWeightUnit())
+		err := stream.Send(&pb.WatchLaptopsResponse{Event: pbEvent})
+		if err != nil {
+			return status.Errorf(codes.Unknown, "cannot send event: %v", err)
+		}
+		return nil
+	}
+
+	err = subscription.Receive(ctx, func(item interface{}) error {
+		return send(item.(*LaptopEvent))
+	})
+	return watchError(ctx, err, "client is too slow to receive the events, resume from the last cursor")
+}
+
+// CreatePriceAlert saves an alert of the caller
+func (server *LaptopServer) CreatePriceAlert(
+	ctx context.Context,
+	req *pb.CreatePriceAlertRequest,
+) (*pb.CreatePriceAlertResponse, error) {
+	if server.PriceAlertStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "price alerts are not enabled")
+	}
+	if err := validation.PriceAlert("alert", req.GetAlert()).Err(); err != nil {
+		return nil, err
+	}
+
+	alert := NewPriceAlert(caller(ctx), req.GetAlert().GetFilter(), req.GetAlert().GetTargetPrice())
+	err := server.PriceAlertStore.Save(alert)
+	if errors.Is(err, ErrTooManyPriceAlerts) {
+		return nil, rpcerror.New(codes.ResourceExhausted, rpcerror.ReasonTooManyPriceAlerts, "cannot create more price alerts, delete one first").Err()
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save price alert: %v", err)
+	}
+	logging.FromContext(ctx).Info("created price alert", "alert_id", alert.ID, "owner", alert.Owner)
+	return &pb.CreatePriceAlertResponse{Alert: toPbPriceAlert(alert)}, nil
+}
+
+func (server *LaptopServer) ListPriceAlerts(
+	ctx context.Context,
+	req *pb.ListPriceAlertsRequest,
+) (*pb.ListPriceAlertsResponse, error) {
+	if server.PriceAlertStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "price alerts are not enabled")
+	}
+
+	alerts, err := server.PriceAlertStore.List(caller(ctx))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list price alerts: %v", err)
+	}
+	res := &pb.ListPriceAlertsResponse{}
+	for _, alert := range alerts {
+		res.Alerts = append(res.Alerts, toPbPriceAlert(alert))
+	}
+	return res, nil
+}
+
+// DeletePriceAlert deletes an alert of the caller, the ones of the other users are not found
+func (server *LaptopServer) DeletePriceAlert(
+	ctx context.Context,
+	req *pb.DeletePriceAlertRequest,
+) (*pb.DeletePriceAlertResponse, error) {
+	if server.PriceAlertStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "price alerts are not enabled")
+	}
+
+	alert, err := server.PriceAlertStore.Delete(caller(ctx), req.GetId())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "price alert %s doesn't exist", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete price alert: %v", err)
+	}
+	logging.FromContext(ctx).Info("deleted price alert", "alert_id", alert.ID)
+	return &pb.DeletePriceAlertResponse{Alert: toPbPriceAlert(alert)}, nil
+}
+
+// WatchPriceAlerts sends the laptops triggering the alerts of the caller until the client leaves.
+// Only the laptops created or updated while watching are sent, and a client too slow to receive them is disconnected.
+func (server *LaptopServer) WatchPriceAlerts(req *pb.WatchPriceAlertsRequest, stream pb.LaptopService_WatchPriceAlertsServer) error {
+	ctx := stream.Context()
+	if server.PriceAlertNotifier == nil {
+		return status.Errorf(codes.Unimplemented, "price alerts are not enabled")
+	}
+	if err := validation.WeightUnit("weight_unit", req.GetWeightUnit()).Err(); err != nil {
+		return err
+	}
+
+	subscription, err := server.PriceAlertNotifier.Subscribe(caller(ctx))
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "server is shutting down")
+	}
+	defer subscription.Close()
+	logging.FromContext(ctx).Debug("watching price alerts")
+	// the headers tell the client that it receives the matches from now on
+	err = stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return status.Errorf(codes.Unknown, "cannot send header: %v", err)
+	}
+
+	send := func(match *PriceAlertMatch) error {
+		err := stream.Send(&pb.WatchPriceAlertsResponse{
+			AlertId: match.Alert.ID,
+			Laptop:  inWeightUnit(match.Laptop, req.GetWeightUnit()),
+			Price:   match.Price,
+			Time:    timestamppb.New(match.Time),
+		})
+		if err != nil {
+			return status.Errorf(codes.Unknown, "cannot send price alert: %v", err)
+		}
+		return nil
+	}
+
+	err = subscription.Receive(ctx, func(item interface{}) error {
+		return send(item.(*PriceAlertMatch))
+	})
+	return watchError(ctx, err, "client is too slow to receive the price alerts")
+}
+
+// reserveRating counts a rating of a laptop by source in the rating limiters.
+// A rating refused by a limiter doesn't count for the other one.
+func (server *LaptopServer) reserveRating(source, laptopID string) error {
+	releaseUser := func() {}
+	if server.UserRatingLimiter != nil {
+		ok, release := server.UserRatingLimiter.Reserve(source)
+		if !ok {
+			return rateLimitedError(server.UserRatingLimiter, source, "too many ratings from %s", source)
+		}
+		releaseUser = release
+	}
+	if server.LaptopRatingLimiter != nil {
+		ok, _ := server.LaptopRatingLimiter.Reserve(laptopID)
+		if !ok {
+			releaseUser()
+			return rateLimitedError(server.LaptopRatingLimiter, laptopID, "too many ratings for laptop %s", laptopID)
 		}
 	}
 	return nil
 }
 
-func logError(err error) error {
+// watchError returns the error ending a watching call once its subscription receives err:
+// the client left, was too slow to receive the items, the server is shutting down, or the items cannot be sent
+func watchError(ctx context.Context, err error, tooSlowMessage string) error {
+	switch {
+	case ctx.Err() != nil:
+		return contextError(ctx)
+	case errors.Is(err, ErrSubscriberTooSlow):
+		return rpcerror.New(codes.ResourceExhausted, rpcerror.ReasonWatcherTooSlow, "%s", tooSlowMessage).Err()
+	case errors.Is(err, ErrClosed):
+		return status.Errorf(codes.Unavailable, "server is shutting down")
+	default:
+		return err
+	}
+}
+
+// notifyPriceAlerts sends the alerts a created or updated laptop triggers to their owners.
+// An update triggers the alerts the laptop didn't trigger before it, so that an owner is told once about a price drop:
+// previous is checked against previousHistory, the price history before the update, nil if it is unknown.
+// Only the alerts whose target price is at least the price of the laptop are checked.
+func (server *LaptopServer) notifyPriceAlerts(ctx context.Context, laptop, previous *pb.Laptop, previousHistory []PricePoint) {
+	if server.PriceAlertStore == nil || server.PriceAlertNotifier == nil {
+		return
+	}
+
+	for _, currency := range money.Currencies() {
+		price, err := server.ExchangeRates.Price(laptop, currency)
+		if err != nil {
+			continue
+		}
+		alerts, err := server.PriceAlertStore.Triggerable(price)
+		if err != nil {
+			logging.FromContext(ctx).Error("cannot list price alerts", "laptop_id", laptop.GetId(), "error", err)
+			return
+		}
+
+		for _, alert := range alerts {
+			if !server.matches(alert.triggerFilter, laptop) || previous != nil && server.triggeredBefore(alert, previous, previousHistory) {
+				continue
+			}
+			server.PriceAlertNotifier.Notify(&PriceAlertMatch{
+				Alert:  alert,
+				Laptop: laptop,
+				Price:  price,
+				Time:   time.Now(),
+			})
+			logging.FromContext(ctx).Debug("triggered price alert", "alert_id", alert.ID, "laptop_id", laptop.GetId())
+		}
+	}
+}
+
+// triggeredBefore reports whether the previous version of a laptop triggered a saved alert,
+// the price history criteria of the alert are checked against history which the laptop had then
+func (server *LaptopServer) triggeredBefore(alert *PriceAlert, previous *pb.Laptop, history []PricePoint) bool {
+	filter := alert.triggerFilter
+	if !isQualified(filter, previous) || !server.matchesMaxPrice(filter, previous) {
+		return false
+	}
+	if !hasHistoryCriteria(filter) {
+		return true
+	}
+	return history != nil && matchesHistory(filter, previous, history)
+}
+
+// watchMatches reports whether an event is about a laptop matching filter,
+// an update matches if the laptop matches before or after it
+func (server *LaptopServer) watchMatches(filter *pb.Filter, event *LaptopEvent) bool {
+	if filter == nil {
+		return true
+	}
+	return server.matches(filter, event.Laptop) ||
+		event.Previous != nil && server.matches(filter, event.Previous)
+}
+
+// matches reports whether a laptop matches all the criteria of filter
+func (server *LaptopServer) matches(filter *pb.Filter, laptop *pb.Laptop) bool {
+	return isQualified(filter, laptop) && server.matchesPrices(filter, laptop)
+}
+
+// matchesPrices reports whether a laptop matches the price criteria of filter the store cannot check:
+// the max price in another currency, and the ones about the price history which nothing matches without PriceHistoryStore
+func (server *LaptopServer) matchesPrices(filter *pb.Filter, laptop *pb.Laptop) bool {
+	if !server.matchesMaxPrice(filter, laptop) {
+		return false
+	}
+	if !hasHistoryCriteria(filter) {
+		return true
+	}
+	if server.PriceHistoryStore == nil {
+		return false
+	}
+	history, err := server.PriceHistoryStore.History(laptop.GetId())
+	if err != nil {
+		return false
+	}
+	return matchesHistory(filter, laptop, history)
+}
+
+// hasHistoryCriteria reports whether filter has criteria about the price history
+func hasHistoryCriteria(filter *pb.Filter) bool {
+	return filter.GetPriceDroppedWithin() != nil || filter.GetLowestPriceEver()
+}
+
+// matchesHistory reports whether a laptop with a price history matches the price history criteria of filter
+func matchesHistory(filter *pb.Filter, laptop *pb.Laptop, history []PricePoint) bool {
+	if filter.GetLowestPriceEver() && !isLowestPrice(history, laptop.GetPriceUsd()) {
+		return false
+	}
+	if within := filter.GetPriceDroppedWithin(); within != nil && !priceDroppedSince(history, time.Now().Add(-within.AsDuration())) {
+		return false
+	}
+	return true
+}
+
+// matchesMaxPrice reports whether the price of a laptop in the currency of the max price
+// of filter is at most that, a laptop without price in the currency doesn't match
+func (server *LaptopServer) matchesMaxPrice(filter *pb.Filter, laptop *pb.Laptop) bool {
+	maxPrice := filter.GetMaxPrice()
+	if maxPrice == nil {
+		return true
+	}
+	price, err := server.ExchangeRates.Price(laptop, maxPrice.GetCurrencyCode())
+	if err != nil {
+		return false
+	}
+	cmp, err := money.Compare(price, maxPrice)
+	return err == nil && cmp <= 0
+}
+
+func (server *LaptopServer) publish(eventType pb.LaptopEvent_Type, laptop, previous *pb.Laptop, imageID string) {
+	if server.EventBus != nil {
+		server.EventBus.Publish(eventType, laptop, previous, imageID)
+	}
+}
+
+// audit records a change of a laptop, a failure is logged without failing the call which already made it
+func (server *LaptopServer) audit(ctx context.Context, method, laptopID string, before, after proto.Message) {
+	if server.AuditLog == nil {
+		return
+	}
+	entry, err := NewAuditEntry(caller(ctx), method, laptopID, before, after)
+	if err == nil {
+		err = server.AuditLog.Append(entry)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot audit change", "method", method, "laptop_id", laptopID, "error", err)
+	}
+}
+
+// priceHistory returns the price history of a laptop, nil without PriceHistoryStore or if it cannot be read
+func (server *LaptopServer) priceHistory(ctx context.Context, laptopID string) []PricePoint {
+	if server.PriceHistoryStore == nil {
+		return nil
+	}
+	history, err := server.PriceHistoryStore.History(laptopID)
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot read price history", "laptop_id", laptopID, "error", err)
+		return nil
+	}
+	return history
+}
+
+// recordPrice adds the price of a laptop to its history if it changed,
+// a failure is logged without failing the call which already saved the laptop
+func (server *LaptopServer) recordPrice(ctx context.Context, laptop *pb.Laptop) {
+	if server.PriceHistoryStore == nil {
+		return
+	}
+	_, err := server.PriceHistoryStore.Record(laptop.GetId(), laptop.GetPriceUsd(), time.Now())
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot record price", "laptop_id", laptop.GetId(), "error", err)
+	}
+}
+
+// deletePrices removes the price history of a deleted laptop,
+// a failure is logged without failing the call which already deleted the laptop
+func (server *LaptopServer) deletePrices(ctx context.Context, laptopID string) {
+	if server.PriceHistoryStore == nil {
+		return
+	}
+	err := server.PriceHistoryStore.Delete(laptopID)
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot delete price history", "laptop_id", laptopID, "error", err)
+	}
+}
+
+// RecordCurrentPrices adds the current price of every laptop of the store to its history.
+// It is called once the laptops are loaded at startup, since the history is not persisted
+// and a laptop without history would have its lowest price ever.
+func (server *LaptopServer) RecordCurrentPrices(ctx context.Context) error {
+	if server.PriceHistoryStore == nil {
+		return nil
+	}
+	now := time.Now()
+	return server.LaptopStore.ForEach(ctx, func(laptop *pb.Laptop) error {
+		_, err := server.PriceHistoryStore.Record(laptop.GetId(), laptop.GetPriceUsd(), now)
+		if err != nil {
+			return fmt.Errorf("cannot record price of laptop %s: %w", laptop.GetId(), err)
+		}
+		return nil
+	})
+}
+
+func toPbPriceAlert(alert *PriceAlert) *pb.PriceAlert {
+	return &pb.PriceAlert{
+		Id:          alert.ID,
+		Filter:      alert.Filter,
+		TargetPrice: alert.TargetPrice,
+		CreatedAt:   timestamppb.New(alert.CreatedAt),
+	}
+}
+
+func toPbLaptopEvent(event *LaptopEvent, cursor string) *pb.LaptopEvent {
+	return &pb.LaptopEvent{
+		Type:    event.Type,
+		Laptop:  event.Laptop,
+		ImageId: event.ImageID,
+		Cursor:  cursor,
+		Time:    timestamppb.New(event.Time),
+	}
+}
+
+// caller identifies who makes a call, such as the source of ratings or the actor of a change
+func caller(ctx context.Context) string {
+	if identity := IdentityFromContext(ctx); identity != nil {
+		return identity.Subject
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "unknown"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
 	if err != nil {
-		log.Println(err)
+		return p.Addr.String()
 	}
-	return err
+	return host
+}
+
+// rateLimitedError returns the status error of an event for key refused by limiter,
+// telling the client when the limit allows it again
+func rateLimitedError(limiter *RateLimiter, key string, format string, args ...interface{}) error {
+	return rpcerror.New(codes.ResourceExhausted, rpcerror.ReasonRateLimited, format, args...).
+		WithMetadata("limit", strconv.Itoa(limiter.limit)).
+		WithMetadata("window", limiter.events.window.String()).
+		WithRetryDelay(limiter.RetryAfter(key)).
+		Err()
 }
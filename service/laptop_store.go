@@ -5,17 +5,37 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jinzhu/copier"
+	"github.com/neepoo/pcbook/logging"
 	"github.com/neepoo/pcbook/pb"
-	"log"
+	"github.com/neepoo/pcbook/units"
 	"sync"
 )
 
 var ErrAlreadyExists = errors.New("record already exists")
 
+// BatchError is returned when some laptops of a batch cannot be saved, none of the batch is saved then
+type BatchError struct {
+	// Errors are the errors of the laptops which cannot be saved, by their index in the batch
+	Errors map[int]error
+}
+
+func (err *BatchError) Error() string {
+	return fmt.Sprintf("%d laptops of the batch cannot be saved", len(err.Errors))
+}
+
 type LaptopStore interface {
 	Save(laptop *pb.Laptop) error
+	// SaveAll saves all the laptops or none of them, returning a *BatchError if some cannot be saved
+	SaveAll(laptops []*pb.Laptop) error
+	// Update replaces a saved laptop and returns its previous version
+	Update(laptop *pb.Laptop) (*pb.Laptop, error)
+	// Delete removes a laptop and returns it
+	Delete(id string) (*pb.Laptop, error)
 	Find(id string) (*pb.Laptop, error)
 	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error
+	// ForEach calls fn with every laptop of the store until it fails
+	ForEach(ctx context.Context, fn func(laptop *pb.Laptop) error) error
+	Count() int
 }
 
 type InMemoryLaptopStore struct {
@@ -27,16 +47,30 @@ func (store *InMemoryLaptopStore) Search(
 	ctx context.Context,
 	filter *pb.Filter,
 	found func(laptop *pb.Laptop) error,
+) error {
+	return store.each(ctx, func(laptop *pb.Laptop) bool { return isQualified(filter, laptop) }, found)
+}
+
+// ForEach calls fn with a copy of every laptop of the store until it fails
+func (store *InMemoryLaptopStore) ForEach(ctx context.Context, fn func(laptop *pb.Laptop) error) error {
+	return store.each(ctx, func(*pb.Laptop) bool { return true }, fn)
+}
+
+// each calls found with a copy of every laptop which matches until it fails
+func (store *InMemoryLaptopStore) each(
+	ctx context.Context,
+	matches func(laptop *pb.Laptop) bool,
+	found func(laptop *pb.Laptop) error,
 ) error {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 	for _, laptop := range store.data {
 		// heavy processing
 		if errors.Is(ctx.Err(), context.Canceled) || ctx.Err() == context.DeadlineExceeded{
-			log.Printf("context is cancelled")
+			logging.FromContext(ctx).Debug("context is cancelled")
 			return errors.New("context is cancelled")
 		}
-		if isQualified(filter, laptop) {
+		if matches(laptop) {
 			other, err := deepCopy(laptop)
 			if err != nil {
 				return err
@@ -71,6 +105,74 @@ func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 	return nil
 }
 
+func (store *InMemoryLaptopStore) SaveAll(laptops []*pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	batchErr := &BatchError{Errors: map[int]error{}}
+	others := make([]*pb.Laptop, 0, len(laptops))
+	seen := make(map[string]bool, len(laptops))
+	for i, laptop := range laptops {
+		if store.data[laptop.Id] != nil || seen[laptop.Id] {
+			batchErr.Errors[i] = ErrAlreadyExists
+			continue
+		}
+		seen[laptop.Id] = true
+
+		other, err := deepCopy(laptop)
+		if err != nil {
+			batchErr.Errors[i] = err
+			continue
+		}
+		others = append(others, other)
+	}
+	if len(batchErr.Errors) > 0 {
+		return batchErr
+	}
+
+	for _, other := range others {
+		store.data[other.Id] = other
+	}
+	return nil
+}
+
+func (store *InMemoryLaptopStore) Update(laptop *pb.Laptop) (*pb.Laptop, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous := store.data[laptop.Id]
+	if previous == nil {
+		return nil, ErrNotFound
+	}
+
+	other, err := deepCopy(laptop)
+	if err != nil {
+		return nil, err
+	}
+
+	store.data[other.Id] = other
+	return previous, nil
+}
+
+func (store *InMemoryLaptopStore) Delete(id string) (*pb.Laptop, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	laptop := store.data[id]
+	if laptop == nil {
+		return nil, ErrNotFound
+	}
+	delete(store.data, id)
+	return laptop, nil
+}
+
+// Count returns the number of laptops in the store
+func (store *InMemoryLaptopStore) Count() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return len(store.data)
+}
+
 func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
@@ -99,7 +201,8 @@ func deepCopy(laptop *pb.Laptop) (*pb.Laptop, error) {
 }
 
 func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
-	if laptop.GetPriceUsd() > filter.GetMaxPriceUsd() {
+	// the max price in another currency is checked by the server which has the exchange rates
+	if filter.GetMaxPrice() == nil && laptop.GetPriceUsd() > filter.GetMaxPriceUsd() {
 		return false
 	}
 	if laptop.GetCpu().GetNumberCores() < filter.GetMinCpuCores() {
@@ -108,28 +211,25 @@ func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
 	if laptop.GetCpu().GetMinGhz() < filter.GetMinCpuGhz() {
 		return false
 	}
-	if toBit(laptop.GetRam()) < toBit(filter.GetMinRam()) {
-		return false
+	// a filter without a min ram unit doesn't filter on ram
+	if minRam := filter.GetMinRam(); minRam.GetUnit() != pb.Memory_UNKNOWN {
+		cmp, err := units.CompareMemory(laptop.GetRam(), minRam)
+		if err != nil || cmp < 0 {
+			return false
+		}
 	}
-	return true
-}
-
-func toBit(memory *pb.Memory) uint64 {
-	value := memory.GetValue()
-	switch memory.GetUnit() {
-	case pb.Memory_BIT:
-		return value
-	case pb.Memory_BYTE:
-		return value << 3
-	case pb.Memory_KILOBYTE:
-		return value << 13
-	case pb.Memory_MEGABYTE:
-		return value << 23
-	case pb.Memory_GIGABYTE:
-		return value << 33
-	case pb.Memory_TERABYTE:
-		return value << 43
-	default:
-		return 0
+	// a laptop without weight doesn't match the weight bounds
+	if minWeight := filter.GetMinWeight(); minWeight.GetUnit() != pb.Weight_UNKNOWN {
+		cmp, err := units.CompareWeight(units.LaptopWeight(laptop), minWeight)
+		if err != nil || cmp < 0 {
+			return false
+		}
 	}
+	if maxWeight := filter.GetMaxWeight(); maxWeight.GetUnit() != pb.Weight_UNKNOWN {
+		cmp, err := units.CompareWeight(units.LaptopWeight(laptop), maxWeight)
+		if err != nil || cmp > 0 {
+			return false
+		}
+	}
+	return true
 }
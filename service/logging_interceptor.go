@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/logging"
+	"github.com/neepoo/pcbook/pb"
+)
+
+const requestIDHeader = "x-request-id"
+
+// LoggingInterceptor gives every call a logger carrying its method, request id and peer,
+// and logs the status code and latency of the call when it ends
+type LoggingInterceptor struct {
+	logger *logging.Logger
+}
+
+func NewLoggingInterceptor(logger *logging.Logger) *LoggingInterceptor {
+	return &LoggingInterceptor{logger: logger}
+}
+
+func (interceptor *LoggingInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		ctx, logger := interceptor.newContext(ctx, info.FullMethod)
+		if laptopID := requestLaptopID(req); laptopID != "" {
+			logger = logger.With("laptop_id", laptopID)
+		}
+
+		res, err := handler(ctx, req)
+		logCall(logger, err, time.Since(start))
+		return res, err
+	}
+}
+
+func (interceptor *LoggingInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		ctx, logger := interceptor.newContext(stream.Context(), info.FullMethod)
+
+		err := handler(srv, &contextServerStream{ServerStream: stream, ctx: ctx})
+		logCall(logger, err, time.Since(start))
+		return err
+	}
+}
+
+// newContext returns a context carrying a logger for the call, and sends the request id back to the client
+func (interceptor *LoggingInterceptor) newContext(ctx context.Context, method string) (context.Context, *logging.Logger) {
+	requestID := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md[requestIDHeader]; len(values) > 0 && values[0] != "" {
+		requestID = values[0]
+	} else {
+		requestID = uuid.NewString()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, requestID))
+
+	peerAddress := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		peerAddress = p.Addr.String()
+	}
+
+	logger := interceptor.logger.With("method", method, "request_id", requestID, "peer", peerAddress)
+	return logging.NewContext(ctx, logger), logger
+}
+
+// logCall logs the end of a call, the failures are only logged here.
+// The failures of the server are errors, and the ones caused by the client warnings.
+func logCall(logger *logging.Logger, err error, latency time.Duration) {
+	st := status.Convert(err)
+	keyvals := []interface{}{"code", st.Code(), "latency", latency}
+	switch st.Code() {
+	case codes.OK:
+		logger.Info("call finished", keyvals...)
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unavailable:
+		logger.Error("call failed", append(keyvals, "error", st.Message())...)
+	default:
+		logger.Warn("call failed", append(keyvals, "error", st.Message())...)
+	}
+}
+
+// requestLaptopID returns the id of the laptop a request is about, if any
+func requestLaptopID(req interface{}) string {
+	switch r := req.(type) {
+	case *pb.GetLaptopRequest:
+		return r.GetId()
+	case *pb.DeleteLaptopRequest:
+		return r.GetId()
+	case interface{ GetLaptopId() string }:
+		return r.GetLaptopId()
+	case interface{ GetLaptop() *pb.Laptop }:
+		return r.GetLaptop().GetId()
+	default:
+		return ""
+	}
+}
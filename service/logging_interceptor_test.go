@@ -0,0 +1,96 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/logging"
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+// syncBuffer is a buffer safe to write from the server goroutines
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) Lines() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return strings.Split(strings.TrimSpace(b.buffer.String()), "\n")
+}
+
+func TestLoggingInterceptor(t *testing.T) {
+	t.Parallel()
+
+	out := &syncBuffer{}
+	interceptor := service.NewLoggingInterceptor(logging.New(out, logging.LevelInfo, true))
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+	serverAddress := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	}, grpc.UnaryInterceptor(interceptor.Unary()), grpc.StreamInterceptor(interceptor.Stream()))
+	laptopClient := newLaptopClient(t, serverAddress)
+
+	laptop := sample.NewLaptop()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "request-1")
+	var header metadata.MD
+	_, err := laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Equal(t, []string{"request-1"}, header.Get("x-request-id"))
+
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.Error(t, err)
+
+	lines := out.Lines()
+	require.Len(t, lines, 3)
+
+	fields := make([]map[string]interface{}, len(lines))
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal([]byte(line), &fields[i]))
+		require.Equal(t, "/pcbook.LaptopService/CreateLaptop", fields[i]["method"])
+		require.NotEmpty(t, fields[i]["peer"])
+		require.Equal(t, laptop.GetId(), fields[i]["laptop_id"])
+	}
+
+	require.Equal(t, "saved laptop", fields[0]["msg"])
+	require.Equal(t, "request-1", fields[0]["request_id"])
+	require.Equal(t, "call finished", fields[1]["msg"])
+	require.Equal(t, "request-1", fields[1]["request_id"])
+	require.Equal(t, codes.OK.String(), fields[1]["code"])
+	require.NotEmpty(t, fields[1]["latency"])
+
+	require.Equal(t, "call failed", fields[2]["msg"])
+	require.NotEqual(t, "request-1", fields[2]["request_id"])
+	require.Equal(t, codes.AlreadyExists.String(), fields[2]["code"])
+	require.Equal(t, "warn", fields[2]["level"])
+
+	// a failure is logged once, by the interceptor
+	invalid := sample.NewLaptop()
+	invalid.PriceUsd = 0
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: invalid})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	lines = out.Lines()
+	require.Len(t, lines, 4)
+	var last map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[3]), &last))
+	require.Equal(t, "call failed", last["msg"])
+	require.Equal(t, "warn", last["level"])
+}
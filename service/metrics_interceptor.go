@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/metrics"
+)
+
+// MetricsInterceptor counts the calls by status code, measures their latency,
+// and tracks the streams in flight and the image bytes uploaded
+type MetricsInterceptor struct {
+	handled       *metrics.CounterVec
+	latency       *metrics.HistogramVec
+	inFlight      *metrics.GaugeVec
+	uploadedBytes *metrics.CounterVec
+}
+
+// NewMetricsInterceptor returns a metrics interceptor registering its metrics in registry
+func NewMetricsInterceptor(registry *metrics.Registry) *MetricsInterceptor {
+	interceptor := &MetricsInterceptor{
+		handled: metrics.NewCounterVec(
+			"pcbook_grpc_server_handled_total",
+			"Number of RPCs completed on the server, by method and status code.",
+			"method", "code",
+		),
+		latency: metrics.NewHistogramVec(
+			"pcbook_grpc_server_handling_seconds",
+			"Duration of the RPCs on the server, including whole streams.",
+			metrics.DefaultBuckets,
+			"method",
+		),
+		inFlight: metrics.NewGaugeVec(
+			"pcbook_grpc_server_streams_in_flight",
+			"Number of streams currently open on the server.",
+			"method",
+		),
+		uploadedBytes: metrics.NewCounterVec(
+			"pcbook_uploaded_image_bytes_total",
+			"Number of image bytes received by UploadImage.",
+		),
+	}
+	registry.MustRegister(interceptor.handled, interceptor.latency, interceptor.inFlight, interceptor.uploadedBytes)
+	return interceptor
+}
+
+func (interceptor *MetricsInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+		interceptor.observe(info.FullMethod, err, time.Since(start))
+		return res, err
+	}
+}
+
+func (interceptor *MetricsInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		interceptor.inFlight.Inc(info.FullMethod)
+		defer interceptor.inFlight.Dec(info.FullMethod)
+
+		err := handler(srv, &metricsServerStream{ServerStream: stream, uploadedBytes: interceptor.uploadedBytes})
+		interceptor.observe(info.FullMethod, err, time.Since(start))
+		return err
+	}
+}
+
+func (interceptor *MetricsInterceptor) observe(method string, err error, latency time.Duration) {
+	interceptor.handled.Inc(method, status.Code(err).String())
+	interceptor.latency.Observe(latency.Seconds(), method)
+}
+
+// metricsServerStream counts the image chunks received on a stream
+type metricsServerStream struct {
+	grpc.ServerStream
+	uploadedBytes *metrics.CounterVec
+}
+
+func (stream *metricsServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err == nil {
+		if chunk, ok := m.(interface{ GetChunkData() []byte }); ok {
+			stream.uploadedBytes.Add(float64(len(chunk.GetChunkData())))
+		}
+	}
+	return err
+}
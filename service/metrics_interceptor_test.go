@@ -0,0 +1,65 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/neepoo/pcbook/metrics"
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestMetricsInterceptor(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	interceptor := service.NewMetricsInterceptor(registry)
+	laptopServer := service.NewLaptopServer(
+		service.NewInMemoryLaptopStore(),
+		service.NewDiskImageStore(t.TempDir()),
+		nil,
+	)
+	serverAddress := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	}, grpc.UnaryInterceptor(interceptor.Unary()), grpc.StreamInterceptor(interceptor.Stream()))
+	laptopClient := newLaptopClient(t, serverAddress)
+
+	laptop := sample.NewLaptop()
+	req := &pb.CreateLaptopRequest{Laptop: laptop}
+	_, err := laptopClient.CreateLaptop(context.Background(), req)
+	require.NoError(t, err)
+	_, err = laptopClient.CreateLaptop(context.Background(), req)
+	require.Error(t, err)
+
+	stream, err := laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+	info := &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg"}
+	require.NoError(t, stream.Send(&pb.UploadImageRequest{Data: &pb.UploadImageRequest_Info{Info: info}}))
+	for i := 0; i < 3; i++ {
+		chunk := &pb.UploadImageRequest_ChunkData{ChunkData: make([]byte, 100)}
+		require.NoError(t, stream.Send(&pb.UploadImageRequest{Data: chunk}))
+	}
+	_, err = stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	searchStream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = searchStream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	var out bytes.Buffer
+	require.NoError(t, registry.WriteText(&out))
+	text := out.String()
+	require.Contains(t, text, `pcbook_grpc_server_handled_total{method="/pcbook.LaptopService/CreateLaptop",code="OK"} 1`)
+	require.Contains(t, text, `pcbook_grpc_server_handled_total{method="/pcbook.LaptopService/CreateLaptop",code="AlreadyExists"} 1`)
+	require.Contains(t, text, `pcbook_grpc_server_handled_total{method="/pcbook.LaptopService/UploadImage",code="OK"} 1`)
+	require.Contains(t, text, `pcbook_grpc_server_handling_seconds_count{method="/pcbook.LaptopService/CreateLaptop"} 2`)
+	require.Contains(t, text, "# TYPE pcbook_grpc_server_streams_in_flight gauge\n")
+	require.Contains(t, text, "pcbook_uploaded_image_bytes_total 300\n")
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/neepoo/pcbook/pb"
+)
+
+// PriceAlertMatch is a laptop which triggered an alert
+type PriceAlertMatch struct {
+	Alert  *PriceAlert
+	Laptop *pb.Laptop
+	// Price is the price of the laptop in the currency of the target price of the alert
+	Price *pb.Money
+	Time  time.Time
+}
+
+// PriceAlertNotifier sends the matches of the alerts to the subscriptions of their owners without ever waiting for them.
+// The matches of an owner without subscription are dropped.
+type PriceAlertNotifier struct {
+	mutex         sync.Mutex
+	bufferSize    int
+	subscriptions map[string]map[*Subscription]bool
+	closed        bool
+}
+
+// NewPriceAlertNotifier returns a notifier whose subscriptions are ended when bufferSize matches are waiting for them
+func NewPriceAlertNotifier(bufferSize int) *PriceAlertNotifier {
+	return &PriceAlertNotifier{
+		bufferSize:    bufferSize,
+		subscriptions: map[string]map[*Subscription]bool{},
+	}
+}
+
+// Notify sends a match to the subscriptions of the owner of its alert
+func (notifier *PriceAlertNotifier) Notify(match *PriceAlertMatch) {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	for subscription := range notifier.subscriptions[match.Alert.Owner] {
+		subscription.send(match)
+	}
+}
+
+// Subscribe returns a subscription to the next matches of the alerts of owner, its items are *PriceAlertMatch
+func (notifier *PriceAlertNotifier) Subscribe(owner string) (*Subscription, error) {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	if notifier.closed {
+		return nil, ErrClosed
+	}
+	var subscription *Subscription
+	subscription = newSubscription(&notifier.mutex, notifier.bufferSize, func() {
+		delete(notifier.subscriptions[owner], subscription)
+		if len(notifier.subscriptions[owner]) == 0 {
+			delete(notifier.subscriptions, owner)
+		}
+	})
+	if notifier.subscriptions[owner] == nil {
+		notifier.subscriptions[owner] = map[*Subscription]bool{}
+	}
+	notifier.subscriptions[owner][subscription] = true
+	return subscription, nil
+}
+
+// Close ends all subscriptions with ErrClosed and rejects new ones
+func (notifier *PriceAlertNotifier) Close() error {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	notifier.closed = true
+	for _, subscriptions := range notifier.subscriptions {
+		for subscription := range subscriptions {
+			subscription.end(ErrClosed)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/neepoo/pcbook/pb"
+)
+
+// ErrTooManyPriceAlerts is returned when an owner already has the max number of alerts of a store
+var ErrTooManyPriceAlerts = errors.New("too many price alerts")
+
+// PriceAlert is a search saved by Owner, who is told when a laptop matching Filter
+// gets a price at most TargetPrice
+type PriceAlert struct {
+	ID          string
+	Owner       string
+	Filter      *pb.Filter
+	TargetPrice *pb.Money
+	CreatedAt   time.Time
+	// triggerFilter is Filter with TargetPrice as max price, which the laptops triggering the alert match.
+	// It is set by the store and never modified.
+	triggerFilter *pb.Filter
+}
+
+// NewPriceAlert returns a new alert of owner, a nil filter matches all laptops
+func NewPriceAlert(owner string, filter *pb.Filter, targetPrice *pb.Money) *PriceAlert {
+	if filter == nil {
+		filter = &pb.Filter{}
+	}
+	return &PriceAlert{
+		ID:          uuid.NewString(),
+		Owner:       owner,
+		Filter:      proto.Clone(filter).(*pb.Filter),
+		TargetPrice: proto.Clone(targetPrice).(*pb.Money),
+		CreatedAt:   time.Now(),
+	}
+}
+
+func (alert *PriceAlert) Clone() *PriceAlert {
+	clone := *alert
+	clone.Filter = proto.Clone(alert.Filter).(*pb.Filter)
+	clone.TargetPrice = proto.Clone(alert.TargetPrice).(*pb.Money)
+	return &clone
+}
+
+type PriceAlertStore interface {
+	// Save saves a new alert, it returns ErrTooManyPriceAlerts if its owner cannot have more alerts
+	Save(alert *PriceAlert) error
+	// List returns the alerts of owner, or of everyone if owner is empty, oldest first
+	List(owner string) ([]*PriceAlert, error)
+	// Triggerable returns the alerts whose target price is in the currency of price and at least price,
+	// which are the only ones a laptop at this price can trigger
+	Triggerable(price *pb.Money) ([]*PriceAlert, error)
+	// Delete removes an alert of owner, it returns ErrNotFound if owner has no alert with the given id
+	Delete(owner, id string) (*PriceAlert, error)
+}
+
+type InMemoryPriceAlertStore struct {
+	mutex       sync.RWMutex
+	maxPerOwner int
+	alerts      map[string]*PriceAlert
+	owned       map[string]int
+	// byTarget are the alerts by currency of their target price, sorted by target price
+	byTarget map[string][]*PriceAlert
+}
+
+// NewInMemoryPriceAlertStore returns a store keeping up to maxPerOwner alerts per owner, 0 for no limit
+func NewInMemoryPriceAlertStore(maxPerOwner int) *InMemoryPriceAlertStore {
+	return &InMemoryPriceAlertStore{
+		maxPerOwner: maxPerOwner,
+		alerts:      map[string]*PriceAlert{},
+		owned:       map[string]int{},
+		byTarget:    map[string][]*PriceAlert{},
+	}
+}
+
+func (store *InMemoryPriceAlertStore) Save(alert *PriceAlert) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.alerts[alert.ID] != nil {
+		return ErrAlreadyExists
+	}
+	if store.maxPerOwner > 0 && store.owned[alert.Owner] >= store.maxPerOwner {
+		return ErrTooManyPriceAlerts
+	}
+
+	other := alert.Clone()
+	other.triggerFilter = proto.Clone(other.Filter).(*pb.Filter)
+	other.triggerFilter.MaxPrice = other.TargetPrice
+	store.alerts[other.ID] = other
+	store.owned[other.Owner]++
+
+	// the alerts with the same target price stay in the order they are saved
+	currency := other.TargetPrice.GetCurrencyCode()
+	alerts := store.byTarget[currency]
+	i := sort.Search(len(alerts), func(i int) bool {
+		return alerts[i].TargetPrice.GetMinorUnits() > other.TargetPrice.GetMinorUnits()
+	})
+	alerts = append(alerts, nil)
+	copy(alerts[i+1:], alerts[i:])
+	alerts[i] = other
+	store.byTarget[currency] = alerts
+	return nil
+}
+
+func (store *InMemoryPriceAlertStore) List(owner string) ([]*PriceAlert, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	alerts := make([]*PriceAlert, 0)
+	for _, alert := range store.alerts {
+		if owner == "" || alert.Owner == owner {
+			alerts = append(alerts, alert.Clone())
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].CreatedAt.Before(alerts[j].CreatedAt)
+	})
+	return alerts, nil
+}
+
+func (store *InMemoryPriceAlertStore) Triggerable(price *pb.Money) ([]*PriceAlert, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	targeted := store.byTarget[price.GetCurrencyCode()]
+	targeted = targeted[store.searchTarget(targeted, price.GetMinorUnits()):]
+	alerts := make([]*PriceAlert, len(targeted))
+	for i, alert := range targeted {
+		alerts[i] = alert.Clone()
+	}
+	return alerts, nil
+}
+
+func (store *InMemoryPriceAlertStore) Delete(owner, id string) (*PriceAlert, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	alert := store.alerts[id]
+	if alert == nil || alert.Owner != owner {
+		return nil, ErrNotFound
+	}
+	delete(store.alerts, id)
+	store.owned[owner]--
+	if store.owned[owner] == 0 {
+		delete(store.owned, owner)
+	}
+
+	currency := alert.TargetPrice.GetCurrencyCode()
+	alerts := store.byTarget[currency]
+	for i := store.searchTarget(alerts, alert.TargetPrice.GetMinorUnits()); i < len(alerts); i++ {
+		if alerts[i] == alert {
+			store.byTarget[currency] = append(alerts[:i], alerts[i+1:]...)
+			break
+		}
+	}
+	if len(store.byTarget[currency]) == 0 {
+		delete(store.byTarget, currency)
+	}
+	return alert, nil
+}
+
+// searchTarget returns the index of the first of alerts sorted by target price whose target is at least minorUnits
+func (store *InMemoryPriceAlertStore) searchTarget(alerts []*PriceAlert, minorUnits int64) int {
+	return sort.Search(len(alerts), func(i int) bool {
+		return alerts[i].TargetPrice.GetMinorUnits() >= minorUnits
+	})
+}
@@ -0,0 +1,242 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/rpcerror"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestInMemoryPriceAlertStore(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryPriceAlertStore(0)
+	alice := service.NewPriceAlert("alice", nil, &pb.Money{CurrencyCode: "USD", MinorUnits: 100000})
+	bob := service.NewPriceAlert("bob", &pb.Filter{MinCpuCores: 4}, &pb.Money{CurrencyCode: "EUR", MinorUnits: 90000})
+	require.NoError(t, store.Save(alice))
+	require.NoError(t, store.Save(bob))
+	require.ErrorIs(t, store.Save(alice), service.ErrAlreadyExists)
+
+	alerts, err := store.List("alice")
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, alice.ID, alerts[0].ID)
+	require.NotNil(t, alerts[0].Filter)
+
+	alerts, err = store.List("")
+	require.NoError(t, err)
+	require.Len(t, alerts, 2)
+
+	_, err = store.Delete("alice", bob.ID)
+	require.ErrorIs(t, err, service.ErrNotFound)
+	deleted, err := store.Delete("bob", bob.ID)
+	require.NoError(t, err)
+	require.Equal(t, bob.ID, deleted.ID)
+	alerts, err = store.List("bob")
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+}
+
+func TestInMemoryPriceAlertStoreTriggerable(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryPriceAlertStore(2)
+	eur := func(minorUnits int64) *pb.Money {
+		return &pb.Money{CurrencyCode: "EUR", MinorUnits: minorUnits}
+	}
+	high := service.NewPriceAlert("alice", nil, eur(100000))
+	low := service.NewPriceAlert("alice", nil, eur(50000))
+	usd := service.NewPriceAlert("bob", nil, &pb.Money{CurrencyCode: "USD", MinorUnits: 100000})
+	for _, alert := range []*service.PriceAlert{high, low, usd} {
+		require.NoError(t, store.Save(alert))
+	}
+	require.ErrorIs(t, store.Save(service.NewPriceAlert("alice", nil, eur(1))), service.ErrTooManyPriceAlerts)
+
+	triggerable := func(price *pb.Money) []string {
+		alerts, err := store.Triggerable(price)
+		require.NoError(t, err)
+		var ids []string
+		for _, alert := range alerts {
+			ids = append(ids, alert.ID)
+		}
+		return ids
+	}
+	require.Equal(t, []string{low.ID, high.ID}, triggerable(eur(50000)))
+	require.Equal(t, []string{high.ID}, triggerable(eur(50001)))
+	require.Empty(t, triggerable(eur(100001)))
+	require.Equal(t, []string{usd.ID}, triggerable(&pb.Money{CurrencyCode: "USD", MinorUnits: 1}))
+
+	// a deleted alert is neither triggerable nor counted
+	_, err := store.Delete("alice", low.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{high.ID}, triggerable(eur(1)))
+	require.NoError(t, store.Save(service.NewPriceAlert("alice", nil, eur(1))))
+}
+
+func TestPriceAlertNotifier(t *testing.T) {
+	t.Parallel()
+
+	notifier := service.NewPriceAlertNotifier(1)
+	alice, err := notifier.Subscribe("alice")
+	require.NoError(t, err)
+	bob, err := notifier.Subscribe("bob")
+	require.NoError(t, err)
+	match := &service.PriceAlertMatch{Alert: &service.PriceAlert{Owner: "alice"}}
+
+	notifier.Notify(match)
+	require.Equal(t, match, <-alice.Items())
+	require.Empty(t, bob.Items())
+
+	// the second match doesn't fit in the buffer of the subscription
+	notifier.Notify(match)
+	notifier.Notify(match)
+	<-alice.Done()
+	require.ErrorIs(t, alice.Err(), service.ErrSubscriberTooSlow)
+	require.Len(t, alice.Items(), 1)
+
+	require.NoError(t, notifier.Close())
+	<-bob.Done()
+	require.ErrorIs(t, bob.Err(), service.ErrClosed)
+	_, err = notifier.Subscribe("alice")
+	require.ErrorIs(t, err, service.ErrClosed)
+}
+
+func TestClientPriceAlerts(t *testing.T) {
+	t.Parallel()
+
+	exchangeRates, err := service.NewExchangeRates(map[string]float64{"EUR": 0.5})
+	require.NoError(t, err)
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+	laptopServer.ExchangeRates = exchangeRates
+	laptopServer.PriceAlertStore = service.NewInMemoryPriceAlertStore(1)
+	laptopServer.PriceAlertNotifier = service.NewPriceAlertNotifier(10)
+	serverAddr := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	})
+	laptopClient := newLaptopClient(t, serverAddr)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = laptopClient.CreatePriceAlert(ctx, &pb.CreatePriceAlertRequest{Alert: &pb.PriceAlert{}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	created, err := laptopClient.CreatePriceAlert(ctx, &pb.CreatePriceAlertRequest{Alert: &pb.PriceAlert{
+		Filter:      &pb.Filter{MinCpuCores: 2},
+		TargetPrice: &pb.Money{CurrencyCode: "EUR", MinorUnits: 50000},
+	}})
+	require.NoError(t, err)
+	alert := created.GetAlert()
+	require.NotEmpty(t, alert.GetId())
+	require.NotNil(t, alert.GetCreatedAt())
+
+	_, err = laptopClient.CreatePriceAlert(ctx, &pb.CreatePriceAlertRequest{Alert: &pb.PriceAlert{
+		TargetPrice: &pb.Money{CurrencyCode: "USD", MinorUnits: 50000},
+	}})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, rpcerror.ReasonTooManyPriceAlerts, rpcerror.Reason(err))
+
+	listed, err := laptopClient.ListPriceAlerts(ctx, &pb.ListPriceAlertsRequest{})
+	require.NoError(t, err)
+	require.Len(t, listed.GetAlerts(), 1)
+	require.Equal(t, alert.GetId(), listed.GetAlerts()[0].GetId())
+
+	stream, err := laptopClient.WatchPriceAlerts(ctx, &pb.WatchPriceAlertsRequest{})
+	require.NoError(t, err)
+	// the matches are sent once the headers are received
+	_, err = stream.Header()
+	require.NoError(t, err)
+
+	save := func(laptop *pb.Laptop, priceUSD float64, create bool) {
+		laptop.PriceUsd = priceUSD
+		if create {
+			_, err := laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+			require.NoError(t, err)
+			return
+		}
+		_, err := laptopClient.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: laptop})
+		require.NoError(t, err)
+	}
+	expensive := sample.NewLaptop()
+	expensive.Cpu.NumberCores = 4
+	expensive.Cpu.NumberThreads = 8
+	save(expensive, 2000, true)
+	cheap := sample.NewLaptop()
+	cheap.Cpu.NumberCores = 8
+	cheap.Cpu.NumberThreads = 8
+	save(cheap, 900, true)
+	tooFewCores := sample.NewLaptop()
+	tooFewCores.Cpu.NumberCores = 1
+	tooFewCores.Cpu.NumberThreads = 1
+	save(tooFewCores, 100, true)
+	// the price of expensive drops to 500 EUR, then drops again while it still triggers the alert
+	save(expensive, 1000, false)
+	save(expensive, 800, false)
+
+	var laptopIDs []string
+	for i := 0; i < 2; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, alert.GetId(), res.GetAlertId())
+		require.Equal(t, "EUR", res.GetPrice().GetCurrencyCode())
+		require.NotNil(t, res.GetTime())
+		laptopIDs = append(laptopIDs, res.GetLaptop().GetId())
+	}
+	require.Equal(t, []string{cheap.GetId(), expensive.GetId()}, laptopIDs)
+
+	_, err = laptopClient.DeletePriceAlert(ctx, &pb.DeletePriceAlertRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	deleted, err := laptopClient.DeletePriceAlert(ctx, &pb.DeletePriceAlertRequest{Id: alert.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, alert.GetId(), deleted.GetAlert().GetId())
+
+	// a deleted alert is not triggered anymore
+	save(expensive, 2000, false)
+	save(expensive, 100, false)
+	recvCtx, cancelRecv := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelRecv()
+	stream, err = laptopClient.WatchPriceAlerts(recvCtx, &pb.WatchPriceAlertsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestLaptopServerPriceAlertLowestPriceEver(t *testing.T) {
+	t.Parallel()
+
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+	laptopServer.PriceHistoryStore = service.NewInMemoryPriceHistoryStore()
+	laptopServer.PriceAlertStore = service.NewInMemoryPriceAlertStore(1)
+	laptopServer.PriceAlertNotifier = service.NewPriceAlertNotifier(10)
+	alert := service.NewPriceAlert("alice", &pb.Filter{LowestPriceEver: true}, &pb.Money{CurrencyCode: "USD", MinorUnits: 90000})
+	require.NoError(t, laptopServer.PriceAlertStore.Save(alert))
+	subscription, err := laptopServer.PriceAlertNotifier.Subscribe("alice")
+	require.NoError(t, err)
+	defer subscription.Close()
+
+	ctx := context.Background()
+	laptop := sample.NewLaptop()
+	laptop.PriceUsd = 1000
+	_, err = laptopServer.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	// the price drops below the target price twice in a row, the second drop is not told
+	// since the laptop already had its lowest price ever below the target price
+	for _, priceUSD := range []float64{850, 800} {
+		laptop.PriceUsd = priceUSD
+		_, err = laptopServer.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: laptop})
+		require.NoError(t, err)
+	}
+	require.Len(t, subscription.Items(), 1)
+	match := (<-subscription.Items()).(*service.PriceAlertMatch)
+	require.Equal(t, alert.ID, match.Alert.ID)
+	require.Equal(t, int64(85000), match.Price.GetMinorUnits())
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// PricePoint is a price a laptop has from a time on
+type PricePoint struct {
+	PriceUSD float64
+	Time     time.Time
+}
+
+// PriceHistoryStore keeps the prices the laptops had
+type PriceHistoryStore interface {
+	// Record adds the price a laptop has from a time on, unless it already has it.
+	// It reports whether the price is added.
+	Record(laptopID string, priceUSD float64, at time.Time) (bool, error)
+	// History returns the prices of a laptop from the oldest, empty if it has none
+	History(laptopID string) ([]PricePoint, error)
+	// Delete removes the prices of a laptop, which may have none
+	Delete(laptopID string) error
+}
+
+type InMemoryPriceHistoryStore struct {
+	mutex  sync.RWMutex
+	prices map[string][]PricePoint
+}
+
+func NewInMemoryPriceHistoryStore() *InMemoryPriceHistoryStore {
+	return &InMemoryPriceHistoryStore{prices: make(map[string][]PricePoint)}
+}
+
+func (store *InMemoryPriceHistoryStore) Record(laptopID string, priceUSD float64, at time.Time) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	prices := store.prices[laptopID]
+	if len(prices) > 0 && prices[len(prices)-1].PriceUSD == priceUSD {
+		return false, nil
+	}
+	store.prices[laptopID] = append(prices, PricePoint{PriceUSD: priceUSD, Time: at})
+	return true, nil
+}
+
+func (store *InMemoryPriceHistoryStore) History(laptopID string) ([]PricePoint, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	prices := make([]PricePoint, len(store.prices[laptopID]))
+	copy(prices, store.prices[laptopID])
+	return prices, nil
+}
+
+func (store *InMemoryPriceHistoryStore) Delete(laptopID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.prices, laptopID)
+	return nil
+}
+
+// isLowestPrice reports whether priceUSD is at most all the prices of history
+func isLowestPrice(history []PricePoint, priceUSD float64) bool {
+	for _, point := range history {
+		if point.PriceUSD < priceUSD {
+			return false
+		}
+	}
+	return true
+}
+
+// priceDroppedSince reports whether a price of history added since a time is lower than the previous one
+func priceDroppedSince(history []PricePoint, since time.Time) bool {
+	for i := 1; i < len(history); i++ {
+		if !history[i].Time.Before(since) && history[i].PriceUSD < history[i-1].PriceUSD {
+			return true
+		}
+	}
+	return false
+}
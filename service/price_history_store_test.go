@@ -0,0 +1,171 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestInMemoryPriceHistoryStore(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryPriceHistoryStore()
+	now := time.Now()
+	for i, price := range []float64{1000, 1000, 900, 950} {
+		_, err := store.Record("1", price, now.Add(time.Duration(i)*time.Minute))
+		require.NoError(t, err)
+	}
+
+	history, err := store.History("1")
+	require.NoError(t, err)
+	require.Equal(t, []service.PricePoint{
+		{PriceUSD: 1000, Time: now},
+		{PriceUSD: 900, Time: now.Add(2 * time.Minute)},
+		{PriceUSD: 950, Time: now.Add(3 * time.Minute)},
+	}, history)
+
+	history[0].PriceUSD = 1
+	history, err = store.History("1")
+	require.NoError(t, err)
+	require.Equal(t, 1000.0, history[0].PriceUSD)
+
+	history, err = store.History("2")
+	require.NoError(t, err)
+	require.Empty(t, history)
+
+	require.NoError(t, store.Delete("1"))
+	history, err = store.History("1")
+	require.NoError(t, err)
+	require.Empty(t, history)
+	require.NoError(t, store.Delete("2"))
+}
+
+func TestLaptopServerRecordCurrentPrices(t *testing.T) {
+	t.Parallel()
+
+	// the laptops are saved without the server, like the ones loaded from a file
+	store := service.NewInMemoryLaptopStore()
+	laptops := make([]*pb.Laptop, 2)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		laptops[i].PriceUsd = float64(1000 + i*100)
+		require.NoError(t, store.Save(laptops[i]))
+	}
+	priceHistoryStore := service.NewInMemoryPriceHistoryStore()
+	laptopServer := service.NewLaptopServer(store, nil, nil)
+	laptopServer.PriceHistoryStore = priceHistoryStore
+
+	require.NoError(t, laptopServer.RecordCurrentPrices(context.Background()))
+	require.NoError(t, laptopServer.RecordCurrentPrices(context.Background()))
+	for _, laptop := range laptops {
+		history, err := priceHistoryStore.History(laptop.GetId())
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.Equal(t, laptop.GetPriceUsd(), history[0].PriceUSD)
+	}
+
+	// the history goes on with the changes made after the restart, and ends with the laptop
+	laptops[0].PriceUsd = 1500
+	_, err := laptopServer.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: laptops[0]})
+	require.NoError(t, err)
+	res, err := laptopServer.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptops[1].GetId()})
+	require.NoError(t, err)
+	require.Equal(t, laptops[1].GetId(), res.GetLaptop().GetId())
+
+	history, err := priceHistoryStore.History(laptops[0].GetId())
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	history, err = priceHistoryStore.History(laptops[1].GetId())
+	require.NoError(t, err)
+	require.Empty(t, history)
+}
+
+func TestClientPriceHistory(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	priceHistoryStore := service.NewInMemoryPriceHistoryStore()
+	laptopServer := service.NewLaptopServer(store, nil, nil)
+	laptopServer.PriceHistoryStore = priceHistoryStore
+	serverAddr := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	})
+	laptopClient := newLaptopClient(t, serverAddr)
+
+	// the first laptop drops in price, the second one goes up, the third one never changes
+	laptops := make([]*pb.Laptop, 3)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		laptops[i].PriceUsd = 1000
+		_, err := laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptops[i]})
+		require.NoError(t, err)
+	}
+	for _, price := range []float64{800, 800, 900} {
+		laptops[0].PriceUsd = price
+		_, err := laptopClient.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: laptops[0]})
+		require.NoError(t, err)
+	}
+	laptops[1].PriceUsd = 1200
+	_, err := laptopClient.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: laptops[1]})
+	require.NoError(t, err)
+
+	res, err := laptopClient.GetPriceHistory(context.Background(), &pb.GetPriceHistoryRequest{LaptopId: laptops[0].GetId()})
+	require.NoError(t, err)
+	var prices []float64
+	for _, point := range res.GetPrices() {
+		prices = append(prices, point.GetPriceUsd())
+		require.NotNil(t, point.GetTime())
+	}
+	require.Equal(t, []float64{1000, 800, 900}, prices)
+
+	_, err = laptopClient.GetPriceHistory(context.Background(), &pb.GetPriceHistoryRequest{LaptopId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	search := func(filter *pb.Filter) []string {
+		filter.MaxPriceUsd = 1e6
+		stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Filter: filter})
+		require.NoError(t, err)
+		var ids []string
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return ids
+			}
+			require.NoError(t, err)
+			ids = append(ids, res.GetLaptop().GetId())
+		}
+	}
+	require.Equal(t, []string{laptops[0].GetId()}, search(&pb.Filter{PriceDroppedWithin: durationpb.New(30 * 24 * time.Hour)}))
+	require.ElementsMatch(t, []string{laptops[2].GetId()}, search(&pb.Filter{LowestPriceEver: true}))
+
+	// a drop older than the window doesn't count
+	_, err = priceHistoryStore.Record("old", 1000, time.Now().Add(-48*time.Hour))
+	require.NoError(t, err)
+	_, err = priceHistoryStore.Record("old", 900, time.Now().Add(-47*time.Hour))
+	require.NoError(t, err)
+	old := sample.NewLaptop()
+	old.Id = "old"
+	old.PriceUsd = 900
+	require.NoError(t, store.Save(old))
+	require.Equal(t, []string{laptops[0].GetId()}, search(&pb.Filter{PriceDroppedWithin: durationpb.New(24 * time.Hour)}))
+	require.ElementsMatch(t, []string{laptops[0].GetId(), "old"}, search(&pb.Filter{PriceDroppedWithin: durationpb.New(72 * time.Hour)}))
+	require.ElementsMatch(t, []string{laptops[2].GetId(), "old"}, search(&pb.Filter{LowestPriceEver: true}))
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{PriceDroppedWithin: durationpb.New(-time.Hour)},
+	})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
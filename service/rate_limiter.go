@@ -0,0 +1,183 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// slidingWindow keeps the times of recent events per key.
+// The keys without events in the window are swept once per window.
+type slidingWindow struct {
+	window time.Duration
+	events map[string][]time.Time
+	swept  time.Time
+}
+
+func newSlidingWindow(window time.Duration) *slidingWindow {
+	return &slidingWindow{
+		window: window,
+		events: map[string][]time.Time{},
+	}
+}
+
+// count drops the events of key older than the window and returns how many are left
+func (w *slidingWindow) count(key string, now time.Time) int {
+	w.sweep(now)
+	events := w.events[key]
+	start := now.Add(-w.window)
+	i := 0
+	for i < len(events) && !events[i].After(start) {
+		i++
+	}
+	events = events[i:]
+	if len(events) == 0 {
+		delete(w.events, key)
+		return 0
+	}
+	w.events[key] = events
+	return len(events)
+}
+
+// retryAfter returns how long until key has less than limit events in the window
+func (w *slidingWindow) retryAfter(key string, limit int, now time.Time) time.Duration {
+	events := w.events[key]
+	if len(events) < limit {
+		return 0
+	}
+	return events[len(events)-limit].Add(w.window).Sub(now)
+}
+
+func (w *slidingWindow) add(key string, now time.Time) {
+	w.events[key] = append(w.events[key], now)
+}
+
+// remove drops an event of key added at a time, if it is still in the window
+func (w *slidingWindow) remove(key string, at time.Time) {
+	events := w.events[key]
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Equal(at) {
+			events = append(events[:i], events[i+1:]...)
+			break
+		}
+	}
+	if len(events) == 0 {
+		delete(w.events, key)
+		return
+	}
+	w.events[key] = events
+}
+
+// sweep drops the keys whose last event is older than the window, if they were not swept within the window
+func (w *slidingWindow) sweep(now time.Time) {
+	if now.Sub(w.swept) < w.window {
+		return
+	}
+	w.swept = now
+	start := now.Add(-w.window)
+	for key, events := range w.events {
+		if !events[len(events)-1].After(start) {
+			delete(w.events, key)
+		}
+	}
+}
+
+// RateLimiter allows at most limit events per key within a sliding window
+type RateLimiter struct {
+	mutex  sync.Mutex
+	limit  int
+	events *slidingWindow
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		events: newSlidingWindow(window),
+	}
+}
+
+// Allow records an event for key and reports whether it is within the limit
+func (l *RateLimiter) Allow(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if l.events.count(key, now) >= l.limit {
+		return false
+	}
+	l.events.add(key, now)
+	return true
+}
+
+// Reserve records an event for key if it is within the limit, like Allow, and returns the function
+// to release it, which removes the event. Use it when an event must be allowed by several limiters
+// before it counts for any of them: release the reservations made if a later limiter refuses the event.
+func (l *RateLimiter) Reserve(key string) (ok bool, release func()) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if l.events.count(key, now) >= l.limit {
+		return false, func() {}
+	}
+	l.events.add(key, now)
+	var once sync.Once
+	return true, func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			l.events.remove(key, now)
+		})
+	}
+}
+
+// Keys returns the number of keys with events in the window, or not swept yet
+func (l *RateLimiter) Keys() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.events.events)
+}
+
+// RetryAfter returns how long until an event for key is within the limit again, 0 if it already is
+func (l *RateLimiter) RetryAfter(key string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.events.count(key, now)
+	return l.events.retryAfter(key, l.limit, now)
+}
+
+// BurstDetector flags a source that sends more than Threshold extreme
+// scores, at most LowScore or at least HighScore, within a sliding window
+type BurstDetector struct {
+	mutex     sync.Mutex
+	threshold int
+	lowScore  float64
+	highScore float64
+	events    *slidingWindow
+}
+
+func NewBurstDetector(threshold int, window time.Duration, lowScore, highScore float64) *BurstDetector {
+	return &BurstDetector{
+		threshold: threshold,
+		lowScore:  lowScore,
+		highScore: highScore,
+		events:    newSlidingWindow(window),
+	}
+}
+
+// IsSuspicious records the score given by source and reports whether it is part of a burst
+func (d *BurstDetector) IsSuspicious(source string, score float64) bool {
+	if score > d.lowScore && score < d.highScore {
+		return false
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	count := d.events.count(source, now) + 1
+	d.events.add(source, now)
+	return count > d.threshold
+}
@@ -0,0 +1,93 @@
+package service_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := service.NewRateLimiter(3, time.Hour)
+	for i := 0; i < 3; i++ {
+		require.True(t, limiter.Allow("user1"))
+	}
+	require.False(t, limiter.Allow("user1"))
+	require.True(t, limiter.Allow("user2"))
+
+	require.Zero(t, limiter.RetryAfter("user2"))
+	retryAfter := limiter.RetryAfter("user1")
+	require.True(t, retryAfter > 59*time.Minute && retryAfter <= time.Hour)
+
+	limiter = service.NewRateLimiter(1, 10*time.Millisecond)
+	require.True(t, limiter.Allow("user1"))
+	require.False(t, limiter.Allow("user1"))
+	time.Sleep(20 * time.Millisecond)
+	require.True(t, limiter.Allow("user1"))
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	t.Parallel()
+
+	limiter := service.NewRateLimiter(1, time.Hour)
+	ok, release := limiter.Reserve("user1")
+	require.True(t, ok)
+	ok, _ = limiter.Reserve("user1")
+	require.False(t, ok)
+
+	release()
+	release()
+	require.True(t, limiter.Allow("user1"))
+	require.False(t, limiter.Allow("user1"))
+}
+
+func TestRateLimiterReserveConcurrently(t *testing.T) {
+	t.Parallel()
+
+	const limit = 10
+	limiter := service.NewRateLimiter(limit, time.Hour)
+	var reserved int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10*limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := limiter.Reserve("user1"); ok {
+				atomic.AddInt32(&reserved, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(limit), reserved)
+}
+
+func TestRateLimiterSweepsIdleKeys(t *testing.T) {
+	t.Parallel()
+
+	limiter := service.NewRateLimiter(1, 10*time.Millisecond)
+	for _, key := range []string{"user1", "user2", "user3"} {
+		require.True(t, limiter.Allow(key))
+	}
+	require.Equal(t, 3, limiter.Keys())
+
+	time.Sleep(20 * time.Millisecond)
+	require.True(t, limiter.Allow("user4"))
+	require.Equal(t, 1, limiter.Keys())
+}
+
+func TestBurstDetector(t *testing.T) {
+	t.Parallel()
+
+	detector := service.NewBurstDetector(2, time.Hour, 1, 10)
+	require.False(t, detector.IsSuspicious("user1", 10))
+	require.False(t, detector.IsSuspicious("user1", 5))
+	require.False(t, detector.IsSuspicious("user1", 1))
+	require.True(t, detector.IsSuspicious("user1", 10))
+	require.False(t, detector.IsSuspicious("user2", 10))
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+)
+
+const (
+	AggregationMean      = "mean"
+	AggregationBayesian  = "bayesian"
+	AggregationTimeDecay = "decay"
+)
+
+// RatingAggregator computes the score of a laptop from its ratings
+type RatingAggregator interface {
+	Name() string
+	Aggregate(rating *Rating) float64
+}
+
+// MeanAggregator scores a laptop with the plain mean of its ratings
+type MeanAggregator struct{}
+
+func (MeanAggregator) Name() string {
+	return AggregationMean
+}
+
+func (MeanAggregator) Aggregate(rating *Rating) float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+	return rating.Sum / float64(rating.Count)
+}
+
+// BayesianAggregator pulls the mean towards PriorMean as if the laptop
+// had already received PriorWeight ratings of that value, so a laptop
+// with few ratings cannot outrank one with many
+type BayesianAggregator struct {
+	PriorMean   float64
+	PriorWeight float64
+}
+
+func (a BayesianAggregator) Name() string {
+	return AggregationBayesian
+}
+
+func (a BayesianAggregator) Aggregate(rating *Rating) float64 {
+	weight := a.PriorWeight + float64(rating.Count)
+	if weight == 0 {
+		return 0
+	}
+	return (a.PriorWeight*a.PriorMean + rating.Sum) / weight
+}
+
+// TimeDecayAggregator weights every rating by 0.5^(age/half life),
+// so recent ratings count more than old ones.
+// The half life is the one the rating store is created with, which decays the ratings as they are added.
+type TimeDecayAggregator struct{}
+
+func (TimeDecayAggregator) Name() string {
+	return AggregationTimeDecay
+}
+
+// Aggregate returns the weighted mean of the decayed scores, which ageing doesn't change
+// as all the weights decay by the same factor
+func (TimeDecayAggregator) Aggregate(rating *Rating) float64 {
+	if rating.DecayedWeight == 0 {
+		return 0
+	}
+	return rating.DecayedSum / rating.DecayedWeight
+}
+
+// NewRatingAggregator returns the aggregator with the given name
+func NewRatingAggregator(name string, priorMean, priorWeight float64) (RatingAggregator, error) {
+	switch name {
+	case AggregationMean:
+		return MeanAggregator{}, nil
+	case AggregationBayesian:
+		if priorWeight < 0 {
+			return nil, fmt.Errorf("prior weight must not be negative: %v", priorWeight)
+		}
+		return BayesianAggregator{PriorMean: priorMean, PriorWeight: priorWeight}, nil
+	case AggregationTimeDecay:
+		return TimeDecayAggregator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown rating aggregation: %q", name)
+	}
+}
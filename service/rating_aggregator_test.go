@@ -0,0 +1,103 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/neepoo/pcbook/service"
+)
+
+func TestRatingAggregator(t *testing.T) {
+	t.Parallel()
+
+	// a score of 10 a half life ago, and of 6 now
+	rating := &service.Rating{
+		Count:         2,
+		Sum:           16,
+		DecayedSum:    0.5*10 + 6,
+		DecayedWeight: 1.5,
+	}
+
+	testCases := []struct {
+		name       string
+		aggregator service.RatingAggregator
+		score      float64
+	}{
+		{
+			name:       service.AggregationMean,
+			aggregator: service.MeanAggregator{},
+			score:      8,
+		},
+		{
+			name:       service.AggregationBayesian,
+			aggregator: service.BayesianAggregator{PriorMean: 5, PriorWeight: 2},
+			score:      6.5,
+		},
+		{
+			name:       service.AggregationTimeDecay,
+			aggregator: service.TimeDecayAggregator{},
+			score:      (0.5*10 + 6) / 1.5,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.name, tc.aggregator.Name())
+			require.InDelta(t, tc.score, tc.aggregator.Aggregate(rating), 1e-3)
+		})
+	}
+}
+
+func TestBayesianAggregatorRanking(t *testing.T) {
+	t.Parallel()
+
+	aggregator := service.BayesianAggregator{PriorMean: 7, PriorWeight: 10}
+	single := &service.Rating{Count: 1, Sum: 10}
+	many := &service.Rating{Count: 500, Sum: 500 * 9.4}
+
+	require.Less(t, aggregator.Aggregate(single), aggregator.Aggregate(many))
+}
+
+func TestInMemoryRatingScoreDecay(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryRatingScore(time.Hour)
+	for _, score := range []float64{10, 6} {
+		_, err := store.Add("1", score)
+		require.NoError(t, err)
+	}
+	rating, err := store.Find("1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.Count)
+	// the scores are given at almost the same time
+	require.InDelta(t, 8, service.TimeDecayAggregator{}.Aggregate(rating), 1e-3)
+
+	// a store created without half life decays the scores too, so the decay aggregation always has them
+	store = service.NewInMemoryRatingScore(0)
+	_, err = store.Add("1", 10)
+	require.NoError(t, err)
+	rating, err = store.Find("1")
+	require.NoError(t, err)
+	require.InDelta(t, 10, service.TimeDecayAggregator{}.Aggregate(rating), 1e-9)
+}
+
+func TestNewRatingAggregator(t *testing.T) {
+	t.Parallel()
+
+	aggregator, err := service.NewRatingAggregator(service.AggregationBayesian, 7, 10)
+	require.NoError(t, err)
+	require.Equal(t, service.AggregationBayesian, aggregator.Name())
+
+	aggregator, err = service.NewRatingAggregator(service.AggregationTimeDecay, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, service.AggregationTimeDecay, aggregator.Name())
+
+	_, err = service.NewRatingAggregator("median", 0, 0)
+	require.Error(t, err)
+}
@@ -1,19 +1,33 @@
 package service
 
-import "sync"
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultRatingHalfLife is the half life of the decayed scores of a rating store created without one
+const DefaultRatingHalfLife = 30 * 24 * time.Hour
 
 type RatingStore interface {
 	Add(laptopID string, score float64) (*Rating, error)
+	Find(laptopID string) (*Rating, error)
 }
 
 type Rating struct {
 	Count uint32
 	Sum   float64
+	// DecayedSum and DecayedWeight are the sum and number of the scores weighted by 0.5^(age/half life)
+	// at LastTime, with the half life of the store
+	DecayedSum    float64
+	DecayedWeight float64
+	LastTime      time.Time
 }
 
 type InMemoryRatingScore struct {
-	mutex  sync.RWMutex
-	rating map[string]*Rating
+	halfLife time.Duration
+	mutex    sync.RWMutex
+	rating   map[string]*Rating
 }
 
 func (i *InMemoryRatingScore) Add(laptopID string, score float64) (*Rating, error) {
@@ -21,18 +35,59 @@ func (i *InMemoryRatingScore) Add(laptopID string, score float64) (*Rating, erro
 	defer i.mutex.Unlock()
 	r := i.rating[laptopID]
 	if r == nil {
-		r = &Rating{
-			Count: 1,
-			Sum:   score,
-		}
-	} else {
-		r.Count++
-		r.Sum += score
+		r = &Rating{}
+		i.rating[laptopID] = r
+	}
+	r.Count++
+	r.Sum += score
+	r.addDecayed(score, time.Now(), i.halfLife)
+	return r.copy(), nil
+}
+
+// Find returns the ratings of a laptop, or an empty rating if it has none
+func (i *InMemoryRatingScore) Find(laptopID string) (*Rating, error) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	r := i.rating[laptopID]
+	if r == nil {
+		return &Rating{}, nil
 	}
-	i.rating[laptopID] = r
-	return r, nil
+	return r.copy(), nil
 }
 
-func NewInMemoryRatingScore() *InMemoryRatingScore {
-	return &InMemoryRatingScore{rating: map[string]*Rating{}}
+// Count returns the number of ratings of all laptops
+func (i *InMemoryRatingScore) Count() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	count := 0
+	for _, r := range i.rating {
+		count += int(r.Count)
+	}
+	return count
+}
+
+// addDecayed decays the scores given before LastTime to a score given at a time, and adds it
+func (r *Rating) addDecayed(score float64, at time.Time, halfLife time.Duration) {
+	if at.After(r.LastTime) {
+		w := math.Pow(0.5, float64(at.Sub(r.LastTime))/float64(halfLife))
+		r.DecayedSum *= w
+		r.DecayedWeight *= w
+		r.LastTime = at
+	}
+	r.DecayedSum += score
+	r.DecayedWeight++
+}
+
+func (r *Rating) copy() *Rating {
+	other := *r
+	return &other
+}
+
+// NewInMemoryRatingScore returns a store decaying the scores with halfLife,
+// or with DefaultRatingHalfLife if it is not positive
+func NewInMemoryRatingScore(halfLife time.Duration) *InMemoryRatingScore {
+	if halfLife <= 0 {
+		halfLife = DefaultRatingHalfLife
+	}
+	return &InMemoryRatingScore{halfLife: halfLife, rating: map[string]*Rating{}}
 }
@@ -0,0 +1,26 @@
+package service
+
+import "context"
+
+// Semaphore limits how many callers hold it at once, the others wait for it
+type Semaphore struct {
+	slots chan struct{}
+}
+
+func NewSemaphore(size int) *Semaphore {
+	return &Semaphore{slots: make(chan struct{}, size)}
+}
+
+// Acquire waits until the semaphore is free or ctx is done
+func (semaphore *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case semaphore.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (semaphore *Semaphore) Release() {
+	<-semaphore.slots
+}
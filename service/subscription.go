@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// Subscription receives the items a publisher sends to it without ever waiting for it,
+// such as the events of a LaptopEventBus or the matches of a PriceAlertNotifier
+type Subscription struct {
+	// mutex is the one of the publisher
+	mutex *sync.Mutex
+	items chan interface{}
+	done  chan struct{}
+	err   error
+	ended bool
+	// unsubscribe removes the subscription from the publisher, it is called with the mutex locked
+	unsubscribe func()
+}
+
+func newSubscription(mutex *sync.Mutex, bufferSize int, unsubscribe func()) *Subscription {
+	return &Subscription{
+		mutex:       mutex,
+		items:       make(chan interface{}, bufferSize),
+		done:        make(chan struct{}),
+		unsubscribe: unsubscribe,
+	}
+}
+
+// Items returns the channel of the items
+func (subscription *Subscription) Items() <-chan interface{} {
+	return subscription.items
+}
+
+// Done is closed when the publisher ends the subscription, see Err for the reason.
+// The items sent before can still be received.
+func (subscription *Subscription) Done() <-chan struct{} {
+	return subscription.done
+}
+
+// Err returns why the publisher ended the subscription
+func (subscription *Subscription) Err() error {
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+	return subscription.err
+}
+
+// Close stops the subscription
+func (subscription *Subscription) Close() {
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+	subscription.end(nil)
+}
+
+// Receive calls handle for every item until ctx is done, handle fails or the subscription ends.
+// Once the subscription ends, the items sent before are handled and the reason it ended is returned.
+func (subscription *Subscription) Receive(ctx context.Context, handle func(item interface{}) error) error {
+	for {
+		select {
+		case item := <-subscription.items:
+			err := handle(item)
+			if err != nil {
+				return err
+			}
+		case <-subscription.done:
+			for len(subscription.items) > 0 {
+				err := handle(<-subscription.items)
+				if err != nil {
+					return err
+				}
+			}
+			return subscription.Err()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// send must be called with the mutex locked, it ends the subscription if its buffer is full
+func (subscription *Subscription) send(item interface{}) {
+	if subscription.ended {
+		return
+	}
+	select {
+	case subscription.items <- item:
+	default:
+		subscription.end(ErrSubscriberTooSlow)
+	}
+}
+
+// end must be called with the mutex locked
+func (subscription *Subscription) end(err error) {
+	if subscription.ended {
+		return
+	}
+	subscription.ended = true
+	subscription.unsubscribe()
+	subscription.err = err
+	close(subscription.done)
+}
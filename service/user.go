@@ -0,0 +1,44 @@
+package service
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is a user who can log in to the server
+type User struct {
+	Username       string
+	HashedPassword string
+	Role           string
+}
+
+// NewUser returns a new user with a bcrypt hash of the password
+func NewUser(username string, password string, role string) (*User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("cannot hash password: %w", err)
+	}
+
+	user := &User{
+		Username:       username,
+		HashedPassword: string(hashedPassword),
+		Role:           role,
+	}
+	return user, nil
+}
+
+// IsCorrectPassword checks if the password matches the hashed password of the user
+func (user *User) IsCorrectPassword(password string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
+	return err == nil
+}
+
+// Clone returns a copy of the user
+func (user *User) Clone() *User {
+	return &User{
+		Username:       user.Username,
+		HashedPassword: user.HashedPassword,
+		Role:           user.Role,
+	}
+}
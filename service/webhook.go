@@ -0,0 +1,167 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/neepoo/pcbook/pb"
+)
+
+const (
+	WebhookEventHeader     = "X-Pcbook-Event"
+	WebhookDeliveryHeader  = "X-Pcbook-Delivery"
+	WebhookTimestampHeader = "X-Pcbook-Timestamp"
+	WebhookSignatureHeader = "X-Pcbook-Signature"
+)
+
+// Webhook receives the laptop events of EventTypes as HTTP POST requests to URL,
+// signed with Secret
+type Webhook struct {
+	ID         string
+	URL        string
+	EventTypes []pb.LaptopEvent_Type
+	Secret     string
+	CreatedAt  time.Time
+}
+
+// NewWebhook returns a webhook receiving the events of eventTypes, or all of them if it is empty.
+// A secret is generated if secret is empty.
+func NewWebhook(rawURL string, eventTypes []pb.LaptopEvent_Type, secret string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook URL must be an absolute http or https URL: %q", rawURL)
+	}
+	for _, eventType := range eventTypes {
+		if eventType == pb.LaptopEvent_UNKNOWN || pb.LaptopEvent_Type_name[int32(eventType)] == "" {
+			return nil, fmt.Errorf("unknown event type: %v", eventType)
+		}
+	}
+
+	if secret == "" {
+		random := make([]byte, 32)
+		_, err = rand.Read(random)
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate webhook secret: %w", err)
+		}
+		secret = "whsec_" + base64.RawURLEncoding.EncodeToString(random)
+	}
+
+	return &Webhook{
+		ID:         uuid.NewString(),
+		URL:        rawURL,
+		EventTypes: eventTypes,
+		Secret:     secret,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// Accepts reports whether the webhook receives the events of eventType
+func (webhook *Webhook) Accepts(eventType pb.LaptopEvent_Type) bool {
+	if len(webhook.EventTypes) == 0 {
+		return true
+	}
+	for _, accepted := range webhook.EventTypes {
+		if accepted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (webhook *Webhook) Clone() *Webhook {
+	other := *webhook
+	other.EventTypes = append([]pb.LaptopEvent_Type(nil), webhook.EventTypes...)
+	return &other
+}
+
+// SignWebhookPayload returns the signature of a payload sent at timestamp, the value of the
+// X-Pcbook-Signature header: the hex HMAC-SHA256 of "<timestamp>.<payload>" keyed by the secret.
+// Receivers should reject old timestamps to prevent replays.
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature reports whether signature is the one of a payload sent at timestamp
+func VerifyWebhookSignature(secret string, timestamp int64, payload []byte, signature string) bool {
+	expected := SignWebhookPayload(secret, timestamp, payload)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+type WebhookStore interface {
+	Save(webhook *Webhook) error
+	Find(id string) (*Webhook, error)
+	List() ([]*Webhook, error)
+	Delete(id string) (*Webhook, error)
+}
+
+type InMemoryWebhookStore struct {
+	mutex    sync.RWMutex
+	webhooks map[string]*Webhook
+}
+
+func NewInMemoryWebhookStore() *InMemoryWebhookStore {
+	return &InMemoryWebhookStore{webhooks: map[string]*Webhook{}}
+}
+
+func (store *InMemoryWebhookStore) Save(webhook *Webhook) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.webhooks[webhook.ID] != nil {
+		return ErrAlreadyExists
+	}
+	store.webhooks[webhook.ID] = webhook.Clone()
+	return nil
+}
+
+// Find returns the webhook with the given id, or nil if there is none
+func (store *InMemoryWebhookStore) Find(id string) (*Webhook, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	webhook := store.webhooks[id]
+	if webhook == nil {
+		return nil, nil
+	}
+	return webhook.Clone(), nil
+}
+
+// List returns the webhooks, oldest first
+func (store *InMemoryWebhookStore) List() ([]*Webhook, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	webhooks := make([]*Webhook, 0, len(store.webhooks))
+	for _, webhook := range store.webhooks {
+		webhooks = append(webhooks, webhook.Clone())
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+	return webhooks, nil
+}
+
+func (store *InMemoryWebhookStore) Delete(id string) (*Webhook, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	webhook := store.webhooks[id]
+	if webhook == nil {
+		return nil, ErrNotFound
+	}
+	delete(store.webhooks, id)
+	return webhook, nil
+}
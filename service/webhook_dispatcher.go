@@ -0,0 +1,366 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/neepoo/pcbook/logging"
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/serializer"
+)
+
+const (
+	webhookQueueSize = 1024
+	// the delivered deliveries are forgotten past maxWebhookDeliveries, oldest first
+	maxWebhookDeliveries = 10000
+)
+
+// ErrNotDeadLetter is returned when retrying a delivery which did not fail
+var ErrNotDeadLetter = errors.New("delivery is not a dead letter")
+
+// WebhookDelivery is the delivery of a laptop event to a webhook
+type WebhookDelivery struct {
+	ID             string
+	WebhookID      string
+	EventType      pb.LaptopEvent_Type
+	EventCursor    string
+	Payload        []byte
+	Status         pb.WebhookDelivery_Status
+	Attempts       int
+	LastStatusCode int
+	LastError      string
+	LastAttemptAt  time.Time
+	NextAttemptAt  time.Time
+	CreatedAt      time.Time
+}
+
+// WebhookDispatcher delivers the events of a bus to the webhooks of a store.
+// A failed delivery is retried with an exponential backoff, and becomes a
+// dead letter after MaxAttempts attempts.
+type WebhookDispatcher struct {
+	store          WebhookStore
+	bus            *LaptopEventBus
+	Client         *http.Client
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	mutex      sync.Mutex
+	deliveries map[string]*WebhookDelivery
+	order      []string
+	queue      chan string
+	ctx        context.Context
+	cancel     context.CancelFunc
+	running    sync.WaitGroup
+}
+
+func NewWebhookDispatcher(store WebhookStore, bus *LaptopEventBus) *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookDispatcher{
+		store:          store,
+		bus:            bus,
+		Client:         &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:    6,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+		deliveries:     map[string]*WebhookDelivery{},
+		queue:          make(chan string, webhookQueueSize),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// Start subscribes to the bus, the events published from now on are delivered by workers goroutines
+func (dispatcher *WebhookDispatcher) Start(workers int) error {
+	subscription, err := dispatcher.bus.Subscribe("")
+	if err != nil {
+		return fmt.Errorf("cannot subscribe to laptop events: %w", err)
+	}
+
+	dispatcher.running.Add(1 + workers)
+	go dispatcher.run(subscription)
+	for i := 0; i < workers; i++ {
+		go dispatcher.work()
+	}
+	return nil
+}
+
+// Close stops delivering, the pending deliveries are abandoned
+func (dispatcher *WebhookDispatcher) Close() error {
+	dispatcher.cancel()
+	dispatcher.running.Wait()
+	return nil
+}
+
+// List returns the deliveries of a webhook, or of all webhooks if webhookID is empty,
+// with the given status, or all statuses if it is UNKNOWN. The oldest come first.
+func (dispatcher *WebhookDispatcher) List(webhookID string, status pb.WebhookDelivery_Status) []*WebhookDelivery {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	var deliveries []*WebhookDelivery
+	for _, id := range dispatcher.order {
+		delivery := dispatcher.deliveries[id]
+		if webhookID != "" && delivery.WebhookID != webhookID {
+			continue
+		}
+		if status != pb.WebhookDelivery_UNKNOWN && delivery.Status != status {
+			continue
+		}
+		other := *delivery
+		deliveries = append(deliveries, &other)
+	}
+	return deliveries
+}
+
+// Retry delivers a dead letter again, with MaxAttempts new attempts
+func (dispatcher *WebhookDispatcher) Retry(id string) (*WebhookDelivery, error) {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	delivery := dispatcher.deliveries[id]
+	if delivery == nil {
+		return nil, ErrNotFound
+	}
+	if delivery.Status != pb.WebhookDelivery_FAILED {
+		return nil, ErrNotDeadLetter
+	}
+	delivery.Status = pb.WebhookDelivery_PENDING
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	go dispatcher.enqueue(id)
+
+	other := *delivery
+	return &other, nil
+}
+
+// run dispatches the events, and resubscribes after the last one
+// dispatched when it is too slow to receive them
+func (dispatcher *WebhookDispatcher) run(subscription *Subscription) {
+	defer dispatcher.running.Done()
+
+	for {
+		cursor, err := dispatcher.consume(subscription)
+		if err == nil {
+			return
+		}
+
+		logging.Default().Warn("webhook dispatcher is too slow, resubscribing", "cursor", cursor)
+		subscription, err = dispatcher.bus.Subscribe(cursor)
+		if errors.Is(err, ErrCursorExpired) {
+			logging.Default().Error("webhook events were missed", "error", err)
+			subscription, err = dispatcher.bus.Subscribe("")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// consume dispatches the events of a subscription until it ends, and returns the
+// cursor of the last event dispatched. The error is ErrSubscriberTooSlow if the
+// subscription ended because the dispatcher was late, nil otherwise.
+func (dispatcher *WebhookDispatcher) consume(subscription *Subscription) (string, error) {
+	defer subscription.Close()
+
+	cursor := ""
+	err := subscription.Receive(dispatcher.ctx, func(item interface{}) error {
+		cursor = dispatcher.dispatch(item.(*LaptopEvent))
+		return nil
+	})
+	if errors.Is(err, ErrSubscriberTooSlow) {
+		return cursor, err
+	}
+	return cursor, nil
+}
+
+// dispatch queues a delivery of event to every webhook accepting it, and returns the cursor of event
+func (dispatcher *WebhookDispatcher) dispatch(event *LaptopEvent) string {
+	cursor := dispatcher.bus.Cursor(event)
+	webhooks, err := dispatcher.store.List()
+	if err != nil {
+		logging.Default().Error("cannot list webhooks", "error", err)
+		return cursor
+	}
+
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.Accepts(event.Type) {
+			continue
+		}
+		if payload == nil {
+			payload, err = serializer.ProtobufToJSON(toPbLaptopEvent(event, cursor))
+			if err != nil {
+				logging.Default().Error("cannot marshal laptop event", "error", err)
+				return cursor
+			}
+		}
+
+		now := time.Now()
+		delivery := &WebhookDelivery{
+			ID:            uuid.NewString(),
+			WebhookID:     webhook.ID,
+			EventType:     event.Type,
+			EventCursor:   cursor,
+			Payload:       payload,
+			Status:        pb.WebhookDelivery_PENDING,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		dispatcher.mutex.Lock()
+		dispatcher.deliveries[delivery.ID] = delivery
+		dispatcher.order = append(dispatcher.order, delivery.ID)
+		dispatcher.prune()
+		dispatcher.mutex.Unlock()
+
+		dispatcher.enqueue(delivery.ID)
+	}
+	return cursor
+}
+
+// prune forgets the oldest delivered deliveries past maxWebhookDeliveries,
+// it must be called with the mutex locked
+func (dispatcher *WebhookDispatcher) prune() {
+	excess := len(dispatcher.order) - maxWebhookDeliveries
+	if excess <= 0 {
+		return
+	}
+	// forget a tenth more than needed so that pruning is rare
+	excess += maxWebhookDeliveries / 10
+
+	order := dispatcher.order[:0]
+	for _, id := range dispatcher.order {
+		if excess > 0 && dispatcher.deliveries[id].Status == pb.WebhookDelivery_DELIVERED {
+			delete(dispatcher.deliveries, id)
+			excess--
+			continue
+		}
+		order = append(order, id)
+	}
+	dispatcher.order = order
+}
+
+// enqueue waits for room in the queue, or for the dispatcher to be closed
+func (dispatcher *WebhookDispatcher) enqueue(id string) {
+	select {
+	case dispatcher.queue <- id:
+	case <-dispatcher.ctx.Done():
+	}
+}
+
+func (dispatcher *WebhookDispatcher) work() {
+	defer dispatcher.running.Done()
+
+	for {
+		select {
+		case id := <-dispatcher.queue:
+			dispatcher.attempt(id)
+		case <-dispatcher.ctx.Done():
+			return
+		}
+	}
+}
+
+// attempt makes an attempt at a pending delivery, and schedules the next one if it fails
+func (dispatcher *WebhookDispatcher) attempt(id string) {
+	dispatcher.mutex.Lock()
+	delivery := dispatcher.deliveries[id]
+	if delivery == nil || delivery.Status != pb.WebhookDelivery_PENDING {
+		dispatcher.mutex.Unlock()
+		return
+	}
+	other := *delivery
+	dispatcher.mutex.Unlock()
+
+	retryable := true
+	statusCode := 0
+	webhook, err := dispatcher.store.Find(other.WebhookID)
+	if err == nil && webhook == nil {
+		err = fmt.Errorf("webhook %s was deleted", other.WebhookID)
+		retryable = false
+	}
+	if err == nil {
+		statusCode, err = dispatcher.post(webhook, &other)
+	}
+	if dispatcher.ctx.Err() != nil {
+		// the attempt was cancelled by Close
+		return
+	}
+
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = now
+	delivery.LastStatusCode = statusCode
+	delivery.LastError = ""
+	delivery.NextAttemptAt = time.Time{}
+	logger := logging.Default().With("delivery_id", id, "webhook_id", delivery.WebhookID, "attempts", delivery.Attempts)
+
+	switch {
+	case err == nil:
+		delivery.Status = pb.WebhookDelivery_DELIVERED
+		logger.Debug("delivered webhook")
+	case !retryable || delivery.Attempts >= dispatcher.MaxAttempts:
+		delivery.Status = pb.WebhookDelivery_FAILED
+		delivery.LastError = err.Error()
+		logger.Warn("webhook delivery failed, moved to dead letters", "error", err)
+	default:
+		backoff := dispatcher.backoff(delivery.Attempts)
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(backoff)
+		logger.Debug("webhook delivery failed, retrying", "error", err, "backoff", backoff)
+		time.AfterFunc(backoff, func() {
+			dispatcher.enqueue(id)
+		})
+	}
+}
+
+// backoff returns the delay before the next attempt after the given number of attempts
+func (dispatcher *WebhookDispatcher) backoff(attempts int) time.Duration {
+	backoff := dispatcher.InitialBackoff
+	for i := 1; i < attempts && backoff < dispatcher.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > dispatcher.MaxBackoff {
+		backoff = dispatcher.MaxBackoff
+	}
+	return backoff
+}
+
+// post sends the payload of a delivery to a webhook, any status but 2xx is a failure
+func (dispatcher *WebhookDispatcher) post(webhook *Webhook, delivery *WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(dispatcher.ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("cannot create request: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.EventType.String())
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, timestamp, delivery.Payload))
+
+	res, err := dispatcher.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook responded %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
@@ -0,0 +1,197 @@
+package service_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/serializer"
+	"github.com/neepoo/pcbook/service"
+)
+
+// webhookReceiver records the verified payloads it receives, and fails the first failures requests
+type webhookReceiver struct {
+	mutex    sync.Mutex
+	secret   string
+	failures int
+	events   []*pb.LaptopEvent
+	received chan struct{}
+}
+
+func newWebhookReceiver(t *testing.T, secret string, failures int) (*webhookReceiver, string) {
+	receiver := &webhookReceiver{secret: secret, failures: failures, received: make(chan struct{}, 100)}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	return receiver, server.URL
+}
+
+func (receiver *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	defer func() { receiver.received <- struct{}{} }()
+
+	if receiver.failures != 0 {
+		receiver.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	payload, _ := ioutil.ReadAll(r.Body)
+	timestamp, _ := strconv.ParseInt(r.Header.Get(service.WebhookTimestampHeader), 10, 64)
+	if !service.VerifyWebhookSignature(receiver.secret, timestamp, payload, r.Header.Get(service.WebhookSignatureHeader)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	event := &pb.LaptopEvent{}
+	if serializer.JSONToProtobuf(payload, event) != nil || r.Header.Get(service.WebhookEventHeader) != event.GetType().String() {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	receiver.events = append(receiver.events, event)
+}
+
+func (receiver *webhookReceiver) Events() []*pb.LaptopEvent {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	return append([]*pb.LaptopEvent(nil), receiver.events...)
+}
+
+func startTestWebhookDispatcher(t *testing.T, bus *service.LaptopEventBus, store service.WebhookStore) *service.WebhookDispatcher {
+	dispatcher := service.NewWebhookDispatcher(store, bus)
+	dispatcher.MaxAttempts = 3
+	dispatcher.InitialBackoff = time.Millisecond
+	dispatcher.MaxBackoff = 5 * time.Millisecond
+	require.NoError(t, dispatcher.Start(2))
+	t.Cleanup(func() { dispatcher.Close() })
+	return dispatcher
+}
+
+func waitForDeliveries(t *testing.T, dispatcher *service.WebhookDispatcher, webhookID string, status pb.WebhookDelivery_Status, count int) []*service.WebhookDelivery {
+	var deliveries []*service.WebhookDelivery
+	require.Eventually(t, func() bool {
+		deliveries = dispatcher.List(webhookID, status)
+		return len(deliveries) == count
+	}, time.Second, time.Millisecond)
+	return deliveries
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	t.Parallel()
+
+	bus := service.NewLaptopEventBus(10, 10)
+	store := service.NewInMemoryWebhookStore()
+	dispatcher := startTestWebhookDispatcher(t, bus, store)
+
+	receiver, url := newWebhookReceiver(t, "secret", 2)
+	webhook, err := service.NewWebhook(url, []pb.LaptopEvent_Type{pb.LaptopEvent_CREATED}, "secret")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(webhook))
+
+	laptop := sample.NewLaptop()
+	bus.Publish(pb.LaptopEvent_CREATED, laptop, nil, "")
+	bus.Publish(pb.LaptopEvent_DELETED, laptop, nil, "")
+
+	deliveries := waitForDeliveries(t, dispatcher, webhook.ID, pb.WebhookDelivery_DELIVERED, 1)
+	require.Equal(t, 3, deliveries[0].Attempts)
+	require.Equal(t, http.StatusOK, deliveries[0].LastStatusCode)
+	require.Empty(t, dispatcher.List(webhook.ID, pb.WebhookDelivery_PENDING))
+
+	events := receiver.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, pb.LaptopEvent_CREATED, events[0].GetType())
+	require.Equal(t, laptop.GetId(), events[0].GetLaptop().GetId())
+	require.Equal(t, deliveries[0].EventCursor, events[0].GetCursor())
+}
+
+func TestWebhookDispatcherDeadLetter(t *testing.T) {
+	t.Parallel()
+
+	bus := service.NewLaptopEventBus(10, 10)
+	store := service.NewInMemoryWebhookStore()
+	dispatcher := startTestWebhookDispatcher(t, bus, store)
+
+	receiver, url := newWebhookReceiver(t, "secret", 3)
+	webhook, err := service.NewWebhook(url, nil, "secret")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(webhook))
+
+	bus.Publish(pb.LaptopEvent_IMAGE_ADDED, sample.NewLaptop(), nil, "image")
+	deliveries := waitForDeliveries(t, dispatcher, "", pb.WebhookDelivery_FAILED, 1)
+	require.Equal(t, 3, deliveries[0].Attempts)
+	require.Equal(t, http.StatusInternalServerError, deliveries[0].LastStatusCode)
+	require.Contains(t, deliveries[0].LastError, "500")
+	require.Empty(t, receiver.Events())
+
+	_, err = dispatcher.Retry("unknown")
+	require.ErrorIs(t, err, service.ErrNotFound)
+	retried, err := dispatcher.Retry(deliveries[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, pb.WebhookDelivery_PENDING, retried.Status)
+
+	waitForDeliveries(t, dispatcher, "", pb.WebhookDelivery_DELIVERED, 1)
+	require.Len(t, receiver.Events(), 1)
+	require.Equal(t, "image", receiver.Events()[0].GetImageId())
+	_, err = dispatcher.Retry(deliveries[0].ID)
+	require.ErrorIs(t, err, service.ErrNotDeadLetter)
+}
+
+func TestAdminServerWebhooks(t *testing.T) {
+	t.Parallel()
+
+	bus := service.NewLaptopEventBus(10, 10)
+	store := service.NewInMemoryWebhookStore()
+	adminServer := service.NewAdminServer(service.NewInMemoryFlaggedRatingStore(), service.NewInMemoryRatingScore(0))
+	adminServer.WebhookStore = store
+	adminServer.WebhookDispatcher = startTestWebhookDispatcher(t, bus, store)
+	serverAddr := startTestServer(t, func(grpcServer *grpc.Server) {
+		pb.RegisterAdminServiceServer(grpcServer, adminServer)
+	})
+	conn, err := grpc.Dial(serverAddr, grpc.WithInsecure())
+	require.NoError(t, err)
+	adminClient := pb.NewAdminServiceClient(conn)
+	ctx := context.Background()
+
+	_, err = adminClient.RegisterWebhook(ctx, &pb.RegisterWebhookRequest{Url: "ftp://example.com"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	receiver, url := newWebhookReceiver(t, "", 0)
+	registered, err := adminClient.RegisterWebhook(ctx, &pb.RegisterWebhookRequest{Url: url})
+	require.NoError(t, err)
+	require.NotEmpty(t, registered.GetSecret())
+	receiver.mutex.Lock()
+	receiver.secret = registered.GetSecret()
+	receiver.mutex.Unlock()
+
+	listed, err := adminClient.ListWebhooks(ctx, &pb.ListWebhooksRequest{})
+	require.NoError(t, err)
+	require.Len(t, listed.GetWebhooks(), 1)
+	require.Equal(t, url, listed.GetWebhooks()[0].GetUrl())
+
+	bus.Publish(pb.LaptopEvent_CREATED, sample.NewLaptop(), nil, "")
+	<-receiver.received
+	require.Eventually(t, func() bool {
+		res, err := adminClient.ListWebhookDeliveries(ctx, &pb.ListWebhookDeliveriesRequest{
+			WebhookId: registered.GetWebhook().GetId(),
+			Status:    pb.WebhookDelivery_DELIVERED,
+		})
+		return err == nil && len(res.GetDeliveries()) == 1
+	}, time.Second, time.Millisecond)
+	require.Len(t, receiver.Events(), 1)
+
+	_, err = adminClient.DeleteWebhook(ctx, &pb.DeleteWebhookRequest{Id: registered.GetWebhook().GetId()})
+	require.NoError(t, err)
+	_, err = adminClient.DeleteWebhook(ctx, &pb.DeleteWebhookRequest{Id: registered.GetWebhook().GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
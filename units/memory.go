@@ -0,0 +1,183 @@
+// Package units converts, compares and formats the physical quantities of the catalog
+package units
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/neepoo/pcbook/pb"
+)
+
+var (
+	// ErrUnknownUnit is returned for a quantity whose unit is unknown
+	ErrUnknownUnit = errors.New("unit is unknown")
+	// ErrOverflow is returned when a quantity cannot be represented in the unit it is converted to
+	ErrOverflow = errors.New("value overflows")
+)
+
+// bitsPerUnit is the size of the memory units, which are binary: a kilobyte is 1024 bytes
+var bitsPerUnit = map[pb.Memory_Unit]uint64{
+	pb.Memory_BIT:      1,
+	pb.Memory_BYTE:     8,
+	pb.Memory_KILOBYTE: 8 << 10,
+	pb.Memory_MEGABYTE: 8 << 20,
+	pb.Memory_GIGABYTE: 8 << 30,
+	pb.Memory_TERABYTE: 8 << 40,
+}
+
+// memorySymbols are the symbols the memory units are formatted with
+var memorySymbols = map[pb.Memory_Unit]string{
+	pb.Memory_BIT:      "bit",
+	pb.Memory_BYTE:     "B",
+	pb.Memory_KILOBYTE: "KB",
+	pb.Memory_MEGABYTE: "MB",
+	pb.Memory_GIGABYTE: "GB",
+	pb.Memory_TERABYTE: "TB",
+}
+
+// memoryUnitNames are the lower-case names a memory unit is parsed from
+var memoryUnitNames = map[string]pb.Memory_Unit{
+	"bit": pb.Memory_BIT, "bits": pb.Memory_BIT,
+	"b": pb.Memory_BYTE, "byte": pb.Memory_BYTE, "bytes": pb.Memory_BYTE,
+	"k": pb.Memory_KILOBYTE, "kb": pb.Memory_KILOBYTE, "kib": pb.Memory_KILOBYTE, "kilobyte": pb.Memory_KILOBYTE, "kilobytes": pb.Memory_KILOBYTE,
+	"m": pb.Memory_MEGABYTE, "mb": pb.Memory_MEGABYTE, "mib": pb.Memory_MEGABYTE, "megabyte": pb.Memory_MEGABYTE, "megabytes": pb.Memory_MEGABYTE,
+	"g": pb.Memory_GIGABYTE, "gb": pb.Memory_GIGABYTE, "gib": pb.Memory_GIGABYTE, "gigabyte": pb.Memory_GIGABYTE, "gigabytes": pb.Memory_GIGABYTE,
+	"t": pb.Memory_TERABYTE, "tb": pb.Memory_TERABYTE, "tib": pb.Memory_TERABYTE, "terabyte": pb.Memory_TERABYTE, "terabytes": pb.Memory_TERABYTE,
+}
+
+// MemoryBits returns the number of bits of memory
+func MemoryBits(memory *pb.Memory) (uint64, error) {
+	return ConvertMemoryValue(memory, pb.Memory_BIT)
+}
+
+// ConvertMemoryValue returns the value of memory in unit, rounded down
+func ConvertMemoryValue(memory *pb.Memory, unit pb.Memory_Unit) (uint64, error) {
+	from, ok := bitsPerUnit[memory.GetUnit()]
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", memory.GetUnit(), ErrUnknownUnit)
+	}
+	to, ok := bitsPerUnit[unit]
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", unit, ErrUnknownUnit)
+	}
+	if from < to {
+		return memory.GetValue() / (to / from), nil
+	}
+	hi, value := bits.Mul64(memory.GetValue(), from/to)
+	if hi != 0 {
+		return 0, fmt.Errorf("%s in %s: %w", FormatMemory(memory), unit, ErrOverflow)
+	}
+	return value, nil
+}
+
+// ConvertMemory returns memory in unit, rounded down
+func ConvertMemory(memory *pb.Memory, unit pb.Memory_Unit) (*pb.Memory, error) {
+	value, err := ConvertMemoryValue(memory, unit)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Memory{Value: value, Unit: unit}, nil
+}
+
+// CompareMemory returns -1, 0 or 1 when a is smaller than, equal to or larger than b.
+// It compares memories of any size exactly.
+func CompareMemory(a, b *pb.Memory) (int, error) {
+	aHi, aLo, err := exactBits(a)
+	if err != nil {
+		return 0, err
+	}
+	bHi, bLo, err := exactBits(b)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case aHi < bHi || aHi == bHi && aLo < bLo:
+		return -1, nil
+	case aHi > bHi || aLo > bLo:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// exactBits returns the number of bits of memory as a 128-bit number
+func exactBits(memory *pb.Memory) (hi, lo uint64, err error) {
+	size, ok := bitsPerUnit[memory.GetUnit()]
+	if !ok {
+		return 0, 0, fmt.Errorf("%s: %w", memory.GetUnit(), ErrUnknownUnit)
+	}
+	hi, lo = bits.Mul64(memory.GetValue(), size)
+	return hi, lo, nil
+}
+
+// AddMemory returns the sum of memories in the smallest of their units, so that it is exact
+func AddMemory(memories ...*pb.Memory) (*pb.Memory, error) {
+	sum := &pb.Memory{Unit: pb.Memory_TERABYTE}
+	for _, memory := range memories {
+		if _, ok := bitsPerUnit[memory.GetUnit()]; !ok {
+			return nil, fmt.Errorf("%s: %w", memory.GetUnit(), ErrUnknownUnit)
+		}
+		if bitsPerUnit[memory.GetUnit()] < bitsPerUnit[sum.Unit] {
+			value, err := ConvertMemoryValue(sum, memory.GetUnit())
+			if err != nil {
+				return nil, err
+			}
+			sum = &pb.Memory{Value: value, Unit: memory.GetUnit()}
+		}
+		value, err := ConvertMemoryValue(memory, sum.Unit)
+		if err != nil {
+			return nil, err
+		}
+		total, carry := bits.Add64(sum.Value, value, 0)
+		if carry != 0 {
+			return nil, fmt.Errorf("sum of memories in %s: %w", sum.Unit, ErrOverflow)
+		}
+		sum.Value = total
+	}
+	return sum, nil
+}
+
+// FormatMemory returns memory in the largest unit it is a whole number of, like "16 GB"
+func FormatMemory(memory *pb.Memory) string {
+	if _, ok := memorySymbols[memory.GetUnit()]; !ok {
+		return fmt.Sprintf("%d (%s)", memory.GetValue(), memory.GetUnit())
+	}
+	value, unit := memory.GetValue(), memory.GetUnit()
+	for value != 0 && unit != pb.Memory_TERABYTE {
+		next := unit + 1
+		ratio := bitsPerUnit[next] / bitsPerUnit[unit]
+		if value%ratio != 0 {
+			break
+		}
+		value, unit = value/ratio, next
+	}
+	return strconv.FormatUint(value, 10) + " " + memorySymbols[unit]
+}
+
+// ParseMemory parses a memory like "512GiB", "1 TB" or "16 gigabytes".
+// The units are case-insensitive and binary whatever their symbol: a GB is 1024 MB like a GiB.
+func ParseMemory(s string) (*pb.Memory, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i == 0 {
+		return nil, fmt.Errorf("memory %q must start with a number", s)
+	}
+	if i < 0 {
+		return nil, fmt.Errorf("memory %q has no unit", s)
+	}
+	value, err := strconv.ParseUint(s[:i], 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("memory %q: %w", s, ErrOverflow)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("memory %q: %w", s, err)
+	}
+	unit, ok := memoryUnitNames[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return nil, fmt.Errorf("memory %q: %w", s, ErrUnknownUnit)
+	}
+	return &pb.Memory{Value: value, Unit: unit}, nil
+}
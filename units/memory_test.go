@@ -0,0 +1,135 @@
+package units_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/units"
+)
+
+func TestConvertMemory(t *testing.T) {
+	t.Parallel()
+
+	bits, err := units.MemoryBits(&pb.Memory{Value: 2, Unit: pb.Memory_KILOBYTE})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2*8*1024), bits)
+
+	memory, err := units.ConvertMemory(&pb.Memory{Value: 1, Unit: pb.Memory_TERABYTE}, pb.Memory_MEGABYTE)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1024*1024), memory.GetValue())
+	require.Equal(t, pb.Memory_MEGABYTE, memory.GetUnit())
+
+	// a conversion to a larger unit is rounded down
+	memory, err = units.ConvertMemory(&pb.Memory{Value: 1536, Unit: pb.Memory_MEGABYTE}, pb.Memory_GIGABYTE)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), memory.GetValue())
+
+	_, err = units.MemoryBits(&pb.Memory{Value: 1 << 21, Unit: pb.Memory_TERABYTE})
+	require.ErrorIs(t, err, units.ErrOverflow)
+	_, err = units.MemoryBits(&pb.Memory{Value: 1, Unit: pb.Memory_UNKNOWN})
+	require.ErrorIs(t, err, units.ErrUnknownUnit)
+	_, err = units.MemoryBits(nil)
+	require.ErrorIs(t, err, units.ErrUnknownUnit)
+}
+
+func TestCompareMemory(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		a, b *pb.Memory
+		cmp  int
+	}{
+		{&pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}, &pb.Memory{Value: 16384, Unit: pb.Memory_MEGABYTE}, 0},
+		{&pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE}, &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}, -1},
+		{&pb.Memory{Value: 1, Unit: pb.Memory_TERABYTE}, &pb.Memory{Value: 1023, Unit: pb.Memory_GIGABYTE}, 1},
+		// too large to be a number of bits
+		{&pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_TERABYTE}, &pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_GIGABYTE}, 1},
+		{&pb.Memory{Value: 1 << 21, Unit: pb.Memory_TERABYTE}, &pb.Memory{Value: 1 << 31, Unit: pb.Memory_GIGABYTE}, 0},
+	}
+	for _, tc := range testCases {
+		cmp, err := units.CompareMemory(tc.a, tc.b)
+		require.NoError(t, err)
+		require.Equal(t, tc.cmp, cmp, "%v vs %v", tc.a, tc.b)
+		cmp, err = units.CompareMemory(tc.b, tc.a)
+		require.NoError(t, err)
+		require.Equal(t, -tc.cmp, cmp, "%v vs %v", tc.b, tc.a)
+	}
+
+	_, err := units.CompareMemory(&pb.Memory{Value: 1, Unit: pb.Memory_GIGABYTE}, &pb.Memory{})
+	require.ErrorIs(t, err, units.ErrUnknownUnit)
+}
+
+func TestAddMemory(t *testing.T) {
+	t.Parallel()
+
+	sum, err := units.AddMemory(
+		&pb.Memory{Value: 1, Unit: pb.Memory_TERABYTE},
+		&pb.Memory{Value: 512, Unit: pb.Memory_GIGABYTE},
+		&pb.Memory{Value: 256, Unit: pb.Memory_MEGABYTE},
+	)
+	require.NoError(t, err)
+	require.Equal(t, &pb.Memory{Value: (1024+512)*1024 + 256, Unit: pb.Memory_MEGABYTE}, sum)
+
+	sum, err = units.AddMemory()
+	require.NoError(t, err)
+	require.Zero(t, sum.GetValue())
+
+	_, err = units.AddMemory(
+		&pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_BYTE},
+		&pb.Memory{Value: 1, Unit: pb.Memory_BYTE},
+	)
+	require.ErrorIs(t, err, units.ErrOverflow)
+	_, err = units.AddMemory(
+		&pb.Memory{Value: 1, Unit: pb.Memory_TERABYTE},
+		&pb.Memory{Value: 1, Unit: pb.Memory_BIT},
+		&pb.Memory{Value: 1 << 30, Unit: pb.Memory_TERABYTE},
+	)
+	require.ErrorIs(t, err, units.ErrOverflow)
+	_, err = units.AddMemory(&pb.Memory{Value: 1})
+	require.ErrorIs(t, err, units.ErrUnknownUnit)
+}
+
+func TestFormatMemory(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "16 GB", units.FormatMemory(&pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}))
+	require.Equal(t, "16 GB", units.FormatMemory(&pb.Memory{Value: 16384, Unit: pb.Memory_MEGABYTE}))
+	require.Equal(t, "1536 MB", units.FormatMemory(&pb.Memory{Value: 1536, Unit: pb.Memory_MEGABYTE}))
+	require.Equal(t, "2048 TB", units.FormatMemory(&pb.Memory{Value: 2048, Unit: pb.Memory_TERABYTE}))
+	require.Equal(t, "1 B", units.FormatMemory(&pb.Memory{Value: 8, Unit: pb.Memory_BIT}))
+	require.Equal(t, "0 GB", units.FormatMemory(&pb.Memory{Value: 0, Unit: pb.Memory_GIGABYTE}))
+	require.Equal(t, "3 (UNKNOWN)", units.FormatMemory(&pb.Memory{Value: 3}))
+}
+
+func TestParseMemory(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]*pb.Memory{
+		"512GiB":        {Value: 512, Unit: pb.Memory_GIGABYTE},
+		"1TB":           {Value: 1, Unit: pb.Memory_TERABYTE},
+		" 16 gb ":       {Value: 16, Unit: pb.Memory_GIGABYTE},
+		"8 megabytes":   {Value: 8, Unit: pb.Memory_MEGABYTE},
+		"64b":           {Value: 64, Unit: pb.Memory_BYTE},
+		"64bits":        {Value: 64, Unit: pb.Memory_BIT},
+		"4096 kilobyte": {Value: 4096, Unit: pb.Memory_KILOBYTE},
+	}
+	for s, expected := range testCases {
+		memory, err := units.ParseMemory(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, memory, s)
+	}
+
+	for _, s := range []string{"", "GB", "16", "16 parsec", "-1GB", "1.5GB", "99999999999999999999GB", "١٦GB", "1٦GB"} {
+		_, err := units.ParseMemory(s)
+		require.Error(t, err, s)
+	}
+	_, err := units.ParseMemory("16 parsec")
+	require.ErrorIs(t, err, units.ErrUnknownUnit)
+	_, err = units.ParseMemory("99999999999999999999GB")
+	require.ErrorIs(t, err, units.ErrOverflow)
+	_, err = units.ParseMemory("1٦GB")
+	require.NotErrorIs(t, err, units.ErrOverflow)
+}
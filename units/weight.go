@@ -0,0 +1,150 @@
+package units
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/neepoo/pcbook/pb"
+)
+
+// KilogramsPerPound is the exact mass of the international avoirdupois pound
+const KilogramsPerPound = 0.45359237
+
+// weightTolerance is the relative difference under which two weights are equal,
+// so that a weight converted back and forth between the units equals itself
+const weightTolerance = 1e-9
+
+var kilogramsPerUnit = map[pb.Weight_Unit]float64{
+	pb.Weight_KILOGRAM: 1,
+	pb.Weight_POUND:    KilogramsPerPound,
+}
+
+var weightSymbols = map[pb.Weight_Unit]string{
+	pb.Weight_KILOGRAM: "kg",
+	pb.Weight_POUND:    "lb",
+}
+
+// weightUnitNames are the lower-case names a weight unit is parsed from
+var weightUnitNames = map[string]pb.Weight_Unit{
+	"kg": pb.Weight_KILOGRAM, "kgs": pb.Weight_KILOGRAM, "kilogram": pb.Weight_KILOGRAM, "kilograms": pb.Weight_KILOGRAM,
+	"lb": pb.Weight_POUND, "lbs": pb.Weight_POUND, "pound": pb.Weight_POUND, "pounds": pb.Weight_POUND,
+}
+
+// LaptopWeight returns the weight of a laptop whichever unit it is in, nil if it has none
+func LaptopWeight(laptop *pb.Laptop) *pb.Weight {
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		return &pb.Weight{Value: weight.WeightKg, Unit: pb.Weight_KILOGRAM}
+	case *pb.Laptop_WeightLb:
+		return &pb.Weight{Value: weight.WeightLb, Unit: pb.Weight_POUND}
+	default:
+		return nil
+	}
+}
+
+// SetLaptopWeight sets the weight of a laptop in the unit of weight
+func SetLaptopWeight(laptop *pb.Laptop, weight *pb.Weight) error {
+	switch weight.GetUnit() {
+	case pb.Weight_KILOGRAM:
+		laptop.Weight = &pb.Laptop_WeightKg{WeightKg: weight.GetValue()}
+	case pb.Weight_POUND:
+		laptop.Weight = &pb.Laptop_WeightLb{WeightLb: weight.GetValue()}
+	default:
+		return fmt.Errorf("%s: %w", weight.GetUnit(), ErrUnknownUnit)
+	}
+	return nil
+}
+
+// ConvertLaptopWeight converts the weight of a laptop to unit, if it has one
+func ConvertLaptopWeight(laptop *pb.Laptop, unit pb.Weight_Unit) error {
+	weight := LaptopWeight(laptop)
+	if weight == nil {
+		return nil
+	}
+	converted, err := ConvertWeight(weight, unit)
+	if err != nil {
+		return err
+	}
+	return SetLaptopWeight(laptop, converted)
+}
+
+// ConvertWeight returns weight in unit
+func ConvertWeight(weight *pb.Weight, unit pb.Weight_Unit) (*pb.Weight, error) {
+	from, ok := kilogramsPerUnit[weight.GetUnit()]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", weight.GetUnit(), ErrUnknownUnit)
+	}
+	to, ok := kilogramsPerUnit[unit]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", unit, ErrUnknownUnit)
+	}
+	if from == to {
+		return &pb.Weight{Value: weight.GetValue(), Unit: unit}, nil
+	}
+	return &pb.Weight{Value: weight.GetValue() * from / to, Unit: unit}, nil
+}
+
+// CompareWeight returns -1, 0 or 1 when a is lighter than, as heavy as or heavier than b.
+// Weights whose relative difference is within rounding errors are as heavy.
+func CompareWeight(a, b *pb.Weight) (int, error) {
+	aKg, err := ConvertWeight(a, pb.Weight_KILOGRAM)
+	if err != nil {
+		return 0, err
+	}
+	bKg, err := ConvertWeight(b, pb.Weight_KILOGRAM)
+	if err != nil {
+		return 0, err
+	}
+	difference := aKg.GetValue() - bKg.GetValue()
+	if math.Abs(difference) <= weightTolerance*math.Max(math.Abs(aKg.GetValue()), math.Abs(bKg.GetValue())) {
+		return 0, nil
+	}
+	if difference < 0 {
+		return -1, nil
+	}
+	return 1, nil
+}
+
+// FormatWeight returns weight rounded to the gram or thousandth of a pound, like "1.5 kg"
+func FormatWeight(weight *pb.Weight) string {
+	symbol, ok := weightSymbols[weight.GetUnit()]
+	if !ok {
+		return fmt.Sprintf("%g (%s)", weight.GetValue(), weight.GetUnit())
+	}
+	return strconv.FormatFloat(math.Round(weight.GetValue()*1000)/1000, 'f', -1, 64) + " " + symbol
+}
+
+// ParseWeightUnit parses a weight unit like "kg", "lb" or "pounds", case-insensitive
+func ParseWeightUnit(s string) (pb.Weight_Unit, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if unit, ok := weightUnitNames[name]; ok {
+		return unit, nil
+	}
+	if unit, ok := pb.Weight_Unit_value[strings.ToUpper(name)]; ok && unit != int32(pb.Weight_UNKNOWN) {
+		return pb.Weight_Unit(unit), nil
+	}
+	return pb.Weight_UNKNOWN, fmt.Errorf("weight unit %q: %w", s, ErrUnknownUnit)
+}
+
+// ParseWeight parses a weight like "1.5kg" or "3.3 lb"
+func ParseWeight(s string) (*pb.Weight, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i == 0 {
+		return nil, fmt.Errorf("weight %q must start with a number", s)
+	}
+	if i < 0 {
+		return nil, fmt.Errorf("weight %q has no unit", s)
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return nil, fmt.Errorf("weight %q: %w", s, err)
+	}
+	unit, err := ParseWeightUnit(s[i:])
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Weight{Value: value, Unit: unit}, nil
+}
@@ -0,0 +1,88 @@
+package units_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/units"
+)
+
+func TestConvertWeight(t *testing.T) {
+	t.Parallel()
+
+	weight, err := units.ConvertWeight(&pb.Weight{Value: 2, Unit: pb.Weight_POUND}, pb.Weight_KILOGRAM)
+	require.NoError(t, err)
+	require.Equal(t, pb.Weight_KILOGRAM, weight.GetUnit())
+	require.InDelta(t, 0.90718474, weight.GetValue(), 1e-12)
+
+	back, err := units.ConvertWeight(weight, pb.Weight_POUND)
+	require.NoError(t, err)
+	cmp, err := units.CompareWeight(back, &pb.Weight{Value: 2, Unit: pb.Weight_POUND})
+	require.NoError(t, err)
+	require.Zero(t, cmp)
+
+	_, err = units.ConvertWeight(&pb.Weight{Value: 2}, pb.Weight_KILOGRAM)
+	require.ErrorIs(t, err, units.ErrUnknownUnit)
+	_, err = units.ConvertWeight(weight, pb.Weight_UNKNOWN)
+	require.ErrorIs(t, err, units.ErrUnknownUnit)
+}
+
+func TestCompareWeight(t *testing.T) {
+	t.Parallel()
+
+	cmp, err := units.CompareWeight(&pb.Weight{Value: 1, Unit: pb.Weight_KILOGRAM}, &pb.Weight{Value: 2.2, Unit: pb.Weight_POUND})
+	require.NoError(t, err)
+	require.Equal(t, 1, cmp)
+	cmp, err = units.CompareWeight(&pb.Weight{Value: 1, Unit: pb.Weight_KILOGRAM}, &pb.Weight{Value: 2.3, Unit: pb.Weight_POUND})
+	require.NoError(t, err)
+	require.Equal(t, -1, cmp)
+	cmp, err = units.CompareWeight(&pb.Weight{Value: units.KilogramsPerPound, Unit: pb.Weight_KILOGRAM}, &pb.Weight{Value: 1, Unit: pb.Weight_POUND})
+	require.NoError(t, err)
+	require.Zero(t, cmp)
+
+	_, err = units.CompareWeight(nil, &pb.Weight{Value: 1, Unit: pb.Weight_POUND})
+	require.ErrorIs(t, err, units.ErrUnknownUnit)
+}
+
+func TestLaptopWeight(t *testing.T) {
+	t.Parallel()
+
+	laptop := &pb.Laptop{}
+	require.Nil(t, units.LaptopWeight(laptop))
+	require.NoError(t, units.ConvertLaptopWeight(laptop, pb.Weight_POUND))
+	require.Nil(t, laptop.GetWeight())
+
+	laptop.Weight = &pb.Laptop_WeightKg{WeightKg: 1.5}
+	require.Equal(t, &pb.Weight{Value: 1.5, Unit: pb.Weight_KILOGRAM}, units.LaptopWeight(laptop))
+	require.NoError(t, units.ConvertLaptopWeight(laptop, pb.Weight_POUND))
+	require.InDelta(t, 3.306934, laptop.GetWeightLb(), 1e-6)
+	require.Equal(t, "3.307 lb", units.FormatWeight(units.LaptopWeight(laptop)))
+
+	require.ErrorIs(t, units.SetLaptopWeight(laptop, &pb.Weight{Value: 1}), units.ErrUnknownUnit)
+}
+
+func TestParseWeight(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]*pb.Weight{
+		"1.5kg":          {Value: 1.5, Unit: pb.Weight_KILOGRAM},
+		" 4 LB ":         {Value: 4, Unit: pb.Weight_POUND},
+		"3 pounds":       {Value: 3, Unit: pb.Weight_POUND},
+		"2 KILOGRAM":     {Value: 2, Unit: pb.Weight_KILOGRAM},
+		"0.75 kilograms": {Value: 0.75, Unit: pb.Weight_KILOGRAM},
+	}
+	for s, expected := range testCases {
+		weight, err := units.ParseWeight(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, weight, s)
+	}
+
+	for _, s := range []string{"", "kg", "1.5", "1.5 stone", "1..5kg", "-1kg"} {
+		_, err := units.ParseWeight(s)
+		require.Error(t, err, s)
+	}
+	_, err := units.ParseWeightUnit("unknown")
+	require.ErrorIs(t, err, units.ErrUnknownUnit)
+}
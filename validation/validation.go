@@ -0,0 +1,184 @@
+// Package validation checks the messages received by the services against the rules of the catalog
+package validation
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+
+	"github.com/neepoo/pcbook/money"
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/rpcerror"
+)
+
+// Violation is a rule broken by a field, whose path is relative to the request message
+type Violation struct {
+	Field       string
+	Description string
+}
+
+// Violations are all the rules broken by a message
+type Violations []Violation
+
+func (violations *Violations) add(field, format string, args ...interface{}) {
+	*violations = append(*violations, Violation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// Err returns an InvalidArgument status error listing the violations,
+// with a VALIDATION_FAILED reason and a google.rpc.BadRequest detail, or nil if there is none
+func (violations Violations) Err() error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, len(violations))
+	badRequest := &errdetails.BadRequest{}
+	for i, violation := range violations {
+		descriptions[i] = violation.Field + ": " + violation.Description
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       violation.Field,
+			Description: violation.Description,
+		})
+	}
+
+	return rpcerror.New(codes.InvalidArgument, rpcerror.ReasonValidationFailed, "invalid request: %s", strings.Join(descriptions, "; ")).
+		WithBadRequest(badRequest).
+		Err()
+}
+
+// Laptop returns the rules broken by a laptop, field is the path of the laptop in the request
+func Laptop(field string, laptop *pb.Laptop) Violations {
+	var violations Violations
+	if laptop == nil {
+		violations.add(field, "laptop is required")
+		return violations
+	}
+
+	if laptop.GetCpu() == nil {
+		violations.add(field+".cpu", "cpu is required")
+	} else {
+		cpu := laptop.GetCpu()
+		if cpu.GetNumberThreads() < cpu.GetNumberCores() {
+			violations.add(field+".cpu.number_threads", "%d threads are fewer than the %d cores", cpu.GetNumberThreads(), cpu.GetNumberCores())
+		}
+		frequencies(&violations, field+".cpu", cpu.GetMinGhz(), cpu.GetMaxGhz())
+	}
+
+	memory(&violations, field+".ram", laptop.GetRam())
+
+	for i, gpu := range laptop.GetGpus() {
+		path := fmt.Sprintf("%s.gpus[%d]", field, i)
+		frequencies(&violations, path, gpu.GetMinGhz(), gpu.GetMaxGhz())
+		memory(&violations, path+".memory", gpu.GetMemory())
+	}
+
+	if len(laptop.GetStorages()) == 0 {
+		violations.add(field+".storages", "at least one storage is required")
+	}
+	for i, storage := range laptop.GetStorages() {
+		memory(&violations, fmt.Sprintf("%s.storages[%d].memory", field, i), storage.GetMemory())
+	}
+
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		if weight.WeightKg < 0 {
+			violations.add(field+".weight_kg", "weight must not be negative, got %g", weight.WeightKg)
+		}
+	case *pb.Laptop_WeightLb:
+		if weight.WeightLb < 0 {
+			violations.add(field+".weight_lb", "weight must not be negative, got %g", weight.WeightLb)
+		}
+	}
+
+	if laptop.GetPriceUsd() <= 0 {
+		violations.add(field+".price_usd", "price must be positive, got %g", laptop.GetPriceUsd())
+	}
+	currencies := make(map[string]bool)
+	for i, price := range laptop.GetPrices() {
+		path := fmt.Sprintf("%s.prices[%d]", field, i)
+		switch {
+		case price.GetCurrencyCode() == money.USD:
+			violations.add(path+".currency_code", "the %s price is %s.price_usd", money.USD, field)
+		case currencies[price.GetCurrencyCode()]:
+			violations.add(path+".currency_code", "there is another price in %s", price.GetCurrencyCode())
+		}
+		currencies[price.GetCurrencyCode()] = true
+		amount(&violations, path, price)
+	}
+	return violations
+}
+
+// Filter returns the rules broken by a search filter, field is the path of the filter in the request
+func Filter(field string, filter *pb.Filter) Violations {
+	var violations Violations
+	if filter.GetMaxPrice() != nil {
+		amount(&violations, field+".max_price", filter.GetMaxPrice())
+		if filter.GetMaxPriceUsd() != 0 {
+			violations.add(field+".max_price", "max price cannot be set with %s.max_price_usd", field)
+		}
+	}
+	if within := filter.GetPriceDroppedWithin(); within != nil && (!within.IsValid() || within.AsDuration() <= 0) {
+		violations.add(field+".price_dropped_within", "duration must be positive")
+	}
+	return violations
+}
+
+// PriceAlert returns the rules broken by a price alert, field is the path of the alert in the request.
+// The target price is the max price of the alert, so its filter has none.
+func PriceAlert(field string, alert *pb.PriceAlert) Violations {
+	violations := Filter(field+".filter", alert.GetFilter())
+	if alert.GetFilter().GetMaxPrice() != nil {
+		violations.add(field+".filter.max_price", "the max price of an alert is %s.target_price", field)
+	}
+	if alert.GetFilter().GetMaxPriceUsd() != 0 {
+		violations.add(field+".filter.max_price_usd", "the max price of an alert is %s.target_price", field)
+	}
+	if alert.GetTargetPrice() == nil {
+		violations.add(field+".target_price", "target price is required")
+	} else {
+		amount(&violations, field+".target_price", alert.GetTargetPrice())
+	}
+	return violations
+}
+
+// WeightUnit returns the rules broken by the unit weights are requested in, which is optional
+func WeightUnit(field string, unit pb.Weight_Unit) Violations {
+	var violations Violations
+	if _, ok := pb.Weight_Unit_name[int32(unit)]; !ok {
+		violations.add(field, "weight unit %d is unknown", unit)
+	}
+	return violations
+}
+
+func amount(violations *Violations, field string, amount *pb.Money) {
+	if !money.IsSupported(amount.GetCurrencyCode()) {
+		violations.add(field+".currency_code", "currency %q is not one of %s", amount.GetCurrencyCode(), strings.Join(money.Currencies(), ", "))
+	}
+	if amount.GetMinorUnits() <= 0 {
+		violations.add(field+".minor_units", "amount must be positive, got %d", amount.GetMinorUnits())
+	}
+}
+
+func frequencies(violations *Violations, field string, minGhz, maxGhz float64) {
+	if minGhz < 0 {
+		violations.add(field+".min_ghz", "frequency must not be negative, got %g", minGhz)
+	}
+	if minGhz > maxGhz {
+		violations.add(field+".min_ghz", "min frequency %g is greater than the max frequency %g", minGhz, maxGhz)
+	}
+}
+
+func memory(violations *Violations, field string, memory *pb.Memory) {
+	if memory == nil {
+		violations.add(field, "memory is required")
+		return
+	}
+	if memory.GetUnit() == pb.Memory_UNKNOWN {
+		violations.add(field+".unit", "memory unit is unknown")
+	}
+}
@@ -0,0 +1,152 @@
+package validation_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/neepoo/pcbook/pb"
+	"github.com/neepoo/pcbook/rpcerror"
+	"github.com/neepoo/pcbook/sample"
+	"github.com/neepoo/pcbook/validation"
+)
+
+func TestLaptop(t *testing.T) {
+	t.Parallel()
+
+	require.Empty(t, validation.Laptop("laptop", sample.NewLaptop()))
+	require.NoError(t, validation.Laptop("laptop", sample.NewLaptop()).Err())
+
+	testCases := []struct {
+		name   string
+		change func(laptop *pb.Laptop)
+		fields []string
+	}{
+		{
+			name:   "min_ghz_greater_than_max_ghz",
+			change: func(laptop *pb.Laptop) { laptop.Cpu.MinGhz, laptop.Cpu.MaxGhz = 4, 3 },
+			fields: []string{"laptop.cpu.min_ghz"},
+		},
+		{
+			name:   "fewer_threads_than_cores",
+			change: func(laptop *pb.Laptop) { laptop.Cpu.NumberCores, laptop.Cpu.NumberThreads = 8, 4 },
+			fields: []string{"laptop.cpu.number_threads"},
+		},
+		{
+			name:   "zero_price",
+			change: func(laptop *pb.Laptop) { laptop.PriceUsd = 0 },
+			fields: []string{"laptop.price_usd"},
+		},
+		{
+			name:   "unknown_memory_unit",
+			change: func(laptop *pb.Laptop) { laptop.Ram.Unit = pb.Memory_UNKNOWN },
+			fields: []string{"laptop.ram.unit"},
+		},
+		{
+			name:   "no_storage",
+			change: func(laptop *pb.Laptop) { laptop.Storages = nil },
+			fields: []string{"laptop.storages"},
+		},
+		{
+			name:   "negative_weight",
+			change: func(laptop *pb.Laptop) { laptop.Weight = &pb.Laptop_WeightLb{WeightLb: -1} },
+			fields: []string{"laptop.weight_lb"},
+		},
+		{
+			name: "invalid_prices",
+			change: func(laptop *pb.Laptop) {
+				laptop.Prices = []*pb.Money{
+					{CurrencyCode: "EUR", MinorUnits: 100},
+					{CurrencyCode: "EUR", MinorUnits: 0},
+					{CurrencyCode: "USD", MinorUnits: 100},
+					{CurrencyCode: "XXX", MinorUnits: 100},
+				}
+			},
+			fields: []string{"laptop.prices[1].currency_code", "laptop.prices[1].minor_units", "laptop.prices[2].currency_code", "laptop.prices[3].currency_code"},
+		},
+		{
+			name: "all_at_once",
+			change: func(laptop *pb.Laptop) {
+				laptop.Cpu = nil
+				laptop.Gpus[0].MinGhz = laptop.Gpus[0].MaxGhz + 1
+				laptop.Storages[1].Memory.Unit = pb.Memory_UNKNOWN
+				laptop.PriceUsd = -1
+			},
+			fields: []string{"laptop.cpu", "laptop.gpus[0].min_ghz", "laptop.storages[1].memory.unit", "laptop.price_usd"},
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptop := sample.NewLaptop()
+			tc.change(laptop)
+			violations := validation.Laptop("laptop", laptop)
+			var fields []string
+			for _, violation := range violations {
+				fields = append(fields, violation.Field)
+			}
+			require.Equal(t, tc.fields, fields)
+
+			err := violations.Err()
+			st := status.Convert(err)
+			require.Equal(t, codes.InvalidArgument, st.Code())
+			require.Equal(t, rpcerror.ReasonValidationFailed, rpcerror.Reason(err))
+			require.Len(t, st.Details(), 2)
+			badRequest, ok := st.Details()[1].(*errdetails.BadRequest)
+			require.True(t, ok)
+			require.Len(t, badRequest.GetFieldViolations(), len(tc.fields))
+			for i, violation := range badRequest.GetFieldViolations() {
+				require.Equal(t, tc.fields[i], violation.GetField())
+				require.NotEmpty(t, violation.GetDescription())
+			}
+		})
+	}
+}
+
+func TestLaptopMissing(t *testing.T) {
+	t.Parallel()
+
+	violations := validation.Laptop("laptop", nil)
+	require.Equal(t, validation.Violations{{Field: "laptop", Description: "laptop is required"}}, violations)
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	require.Empty(t, validation.Filter("filter", &pb.Filter{MaxPriceUsd: 1000}))
+	require.Empty(t, validation.Filter("filter", &pb.Filter{MaxPrice: &pb.Money{CurrencyCode: "EUR", MinorUnits: 100000}}))
+
+	violations := validation.Filter("filter", &pb.Filter{
+		MaxPriceUsd: 1000,
+		MaxPrice:    &pb.Money{CurrencyCode: "EUR", MinorUnits: 100000},
+	})
+	require.Len(t, violations, 1)
+	require.Equal(t, "filter.max_price", violations[0].Field)
+}
+
+func TestPriceAlert(t *testing.T) {
+	t.Parallel()
+
+	alert := &pb.PriceAlert{
+		Filter:      &pb.Filter{MinCpuCores: 4},
+		TargetPrice: &pb.Money{CurrencyCode: "EUR", MinorUnits: 100000},
+	}
+	require.Empty(t, validation.PriceAlert("alert", alert))
+
+	violations := validation.PriceAlert("alert", &pb.PriceAlert{
+		Filter: &pb.Filter{MaxPriceUsd: 1000, MaxPrice: &pb.Money{CurrencyCode: "EUR", MinorUnits: 100}},
+	})
+	var fields []string
+	for _, violation := range violations {
+		fields = append(fields, violation.Field)
+	}
+	require.Equal(t, []string{"alert.filter.max_price", "alert.filter.max_price", "alert.filter.max_price_usd", "alert.target_price"}, fields)
+
+	violations = validation.PriceAlert("alert", &pb.PriceAlert{TargetPrice: &pb.Money{CurrencyCode: "XXX", MinorUnits: -1}})
+	require.Len(t, violations, 2)
+}